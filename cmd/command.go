@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+const usage = `Usage:
+  go-user               run the service
+  go-user config print  print the effective configuration with secrets redacted
+`
+
+func runCommand(args []string) int {
+	switch strings.Join(args, " ") {
+	case "config print":
+		return configPrint()
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", strings.Join(args, " "), usage)
+		return 2
+	}
+}
+
+func configPrint() int {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"os"
+
 	"github.com/iliadmitriev/go-user-test/internal/app"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	application := app.NewApplication()
 	application.Run()
 }
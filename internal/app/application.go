@@ -8,9 +8,15 @@ import (
 	"github.com/iliadmitriev/go-user-test/internal/config"
 	"github.com/iliadmitriev/go-user-test/internal/db"
 	"github.com/iliadmitriev/go-user-test/internal/handler"
+	"github.com/iliadmitriev/go-user-test/internal/logger"
+	"github.com/iliadmitriev/go-user-test/internal/mailer"
+	"github.com/iliadmitriev/go-user-test/internal/outbox"
+	"github.com/iliadmitriev/go-user-test/internal/ratelimit"
 	"github.com/iliadmitriev/go-user-test/internal/repository"
 	"github.com/iliadmitriev/go-user-test/internal/server"
 	"github.com/iliadmitriev/go-user-test/internal/service"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
+	"github.com/iliadmitriev/go-user-test/internal/webhook"
 )
 
 func NewApplication() *fx.App {
@@ -29,11 +35,7 @@ func NewApplication() *fx.App {
 			fx.As(new(server.Server)),
 		)),
 
-		fx.Provide(fx.Annotate(
-			handler.NewUserHandler,
-			fx.ResultTags(`group:"http_routes"`),
-			fx.As(new(handler.HTTPHandler)),
-		)),
+		handler.HTTPRoutes,
 
 		fx.Provide(fx.Annotate(
 			handler.NewGRPCUserHandler,
@@ -41,14 +43,52 @@ func NewApplication() *fx.App {
 			fx.As(new(handler.GRPCHandler)),
 		)),
 
+		fx.Provide(fx.Annotate(
+			outbox.NewLogSink,
+			fx.ResultTags(`group:"outbox_sinks"`),
+		)),
+
+		fx.Provide(fx.Annotate(
+			webhook.NewSink,
+			fx.ResultTags(`group:"outbox_sinks"`),
+		)),
+
+		fx.Provide(handler.NewAuthenticator),
+		fx.Provide(server.NewListeners),
 		fx.Provide(config.NewConfig),
+		fx.Provide(config.NewObserver),
+		fx.Provide(ratelimit.NewMemoryStore),
+		fx.Provide(ratelimit.NewLimiter),
 		fx.Provide(repository.NewUserDB),
+		fx.Provide(repository.NewPasskeyDB),
+		fx.Provide(repository.NewAPIKeyDB),
+		fx.Provide(repository.NewAuditDB),
+		fx.Provide(repository.NewOutboxDB),
+		fx.Provide(repository.NewWebhookDB),
+		fx.Provide(repository.NewIdempotencyDB),
+		fx.Provide(mailer.NewMailer),
 		fx.Provide(service.NewUserService),
+		fx.Provide(service.NewLoginService),
+		fx.Provide(service.NewPasskeyService),
+		fx.Provide(service.NewAPIKeyService),
+		fx.Provide(service.NewAuditService),
+		fx.Provide(service.NewWebhookService),
+		fx.Provide(service.NewBulkService),
+		fx.Provide(service.NewWatchService),
+		fx.Provide(watch.NewHub),
 		fx.Provide(db.NewSqliteDB),
-		fx.Provide(zap.NewProduction),
+		fx.Provide(logger.NewLogger),
+
+		fx.Invoke(logger.WatchLevel),
+
+		fx.Invoke(fx.Annotate(
+			outbox.NewRelay,
+			fx.ParamTags(`group:"outbox_sinks"`),
+		)),
+		fx.Invoke(webhook.NewDispatcher),
 
 		fx.Invoke(fx.Annotate(
-			func([]server.Server) {},
+			server.RegisterDrain,
 			fx.ParamTags(`group:"servers"`),
 		)),
 
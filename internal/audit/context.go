@@ -0,0 +1,41 @@
+// Package audit carries who made a request and where it came from down to
+// the repositories recording audit events.
+package audit
+
+import (
+	"context"
+
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+)
+
+// Anonymous is the actor of requests without a principal, like signup
+// and password reset.
+const Anonymous = "anonymous"
+
+// Request identifies the request making a change.
+type Request struct {
+	ID       string
+	ClientIP string
+}
+
+type requestKey struct{}
+
+func NewContext(ctx context.Context, request *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// FromContext returns the request of ctx, changes made outside of requests
+// have none.
+func FromContext(ctx context.Context) (*Request, bool) {
+	request, ok := ctx.Value(requestKey{}).(*Request)
+	return request, ok
+}
+
+// Actor names the principal of ctx.
+func Actor(ctx context.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Subject()
+	}
+
+	return Anonymous
+}
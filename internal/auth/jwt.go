@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidToken = errors.New("invalid access token")
+
+// Claims of access tokens, the subject is the user ID.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	Login string `json:"login"`
+}
+
+// NewToken issues an access token for the user signed with HS256.
+func NewToken(key []byte, issuer string, userID uuid.UUID, login string, expires time.Time) (string, error) {
+	now := time.Now()
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+		Login: login,
+	}).SignedString(key)
+}
+
+// ParseToken checks the signature, issuer and expiry of the token and
+// returns the user ID and login it was issued for.
+func ParseToken(key []byte, issuer, token string) (uuid.UUID, string, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) { return key, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, "", errors.Join(ErrInvalidToken, err)
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil || claims.Login == "" {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+
+	return userID, claims.Login, nil
+}
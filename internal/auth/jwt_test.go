@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	userID := uuid.New()
+
+	token, err := NewToken(key, "go-user", userID, "alice", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	gotID, login, err := ParseToken(key, "go-user", token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, gotID)
+	assert.Equal(t, "alice", login)
+
+	_, _, err = ParseToken([]byte("another key of thirty two bytes!"), "go-user", token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, _, err = ParseToken(key, "someone else", token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	expired, err := NewToken(key, "go-user", userID, "alice", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	_, _, err = ParseToken(key, "go-user", expired)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,57 @@
+// Package auth holds the identity of callers. Credentials are resolved to
+// a Principal by the transport and passed down in the context.
+package auth
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Method is how a principal proved its identity.
+type Method string
+
+const (
+	MethodAdminToken Method = "admin_token"
+	MethodAPIKey     Method = "api_key"
+	MethodJWT        Method = "jwt"
+	MethodBasic      Method = "basic"
+	MethodClientCert Method = "client_cert"
+)
+
+// Principal is the authenticated caller. Users have UserID, Login and
+// Roles, API keys have APIKeyID and Scopes, the admin token has the admin
+// role only.
+type Principal struct {
+	Method   Method
+	UserID   uuid.UUID
+	Login    string
+	Roles    []string
+	APIKeyID string
+	Scopes   []string
+}
+
+// Subject identifies the principal in logs and rate limits.
+func (p *Principal) Subject() string {
+	switch {
+	case p.Login != "":
+		return p.Login
+	case p.APIKeyID != "":
+		return "api_key:" + p.APIKeyID
+	default:
+		return string(p.Method)
+	}
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of the request, anonymous requests
+// have none.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
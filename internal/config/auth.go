@@ -0,0 +1,40 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const minJWTKeySize = 32
+
+// AuthConfig describes access tokens issued on login. Tokens are JWTs
+// signed with HS256 using JWTKey, a base64 encoded key of at least 32
+// bytes, logins return no token while it is empty.
+type AuthConfig struct {
+	JWTKey   string        `yaml:"jwt_key" toml:"jwt_key" env:"JWT_KEY" secret:"true"`
+	Issuer   string        `yaml:"issuer" toml:"issuer" env:"ISSUER" env-default:"go-user"`
+	TokenTTL time.Duration `yaml:"token_ttl" toml:"token_ttl" env:"TOKEN_TTL" env-default:"15m"`
+}
+
+func (a AuthConfig) Enabled() bool {
+	return a.JWTKey != ""
+}
+
+// Key returns the decoded signing key.
+func (a AuthConfig) Key() []byte {
+	key, _ := base64.StdEncoding.DecodeString(a.JWTKey)
+	return key
+}
+
+func validateAuth(name string, a AuthConfig) error {
+	if !a.Enabled() {
+		return nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(a.JWTKey); err != nil || len(key) < minJWTKeySize {
+		return fmt.Errorf("%s.jwt_key: must be at least %d bytes encoded with base64", name, minJWTKeySize)
+	}
+
+	return validateDuration(name+".token_ttl", a.TokenTTL)
+}
@@ -1,34 +1,126 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigPath = "config.yaml"
+	unixScheme        = "unix://"
 )
 
 type Config struct {
-	Listen       string        `yaml:"listen" env:"LISTEN" env-defautl:":8000"`
-	ListenGRPC   string        `yaml:"listen_grpc" env:"LISTEN_GRPC" env-defautl:":5000"`
-	StoragePath  string        `yaml:"storage_path" env:"STORAGE_PATH" env-defautl:"main.db"`
-	ReadTimeout  time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT" env-defautl:"15s"`
-	WriteTimeout time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT" env-defautl:"15s"`
+	Listen             string          `yaml:"listen" toml:"listen" env:"LISTEN" env-default:":8000" restart:"true"`
+	ListenGRPC         string          `yaml:"listen_grpc" toml:"listen_grpc" env:"LISTEN_GRPC" env-default:":5000" restart:"true"`
+	UnixSocketMode     string          `yaml:"unix_socket_mode" toml:"unix_socket_mode" env:"UNIX_SOCKET_MODE" env-default:"0660" restart:"true"`
+	SinglePort         bool            `yaml:"single_port" toml:"single_port" env:"SINGLE_PORT" env-default:"false" restart:"true"`
+	StoragePath        string          `yaml:"storage_path" toml:"storage_path" env:"STORAGE_PATH" env-default:"main.db" restart:"true"`
+	LogLevel           string          `yaml:"log_level" toml:"log_level" env:"LOG_LEVEL" env-default:"info"`
+	TLS                TLSConfig       `yaml:"tls" toml:"tls" env-prefix:"TLS_" restart:"true"`
+	GRPCTLS            TLSConfig       `yaml:"grpc_tls" toml:"grpc_tls" env-prefix:"GRPC_TLS_" restart:"true"`
+	ReadTimeout        time.Duration   `yaml:"read_timeout" toml:"read_timeout" env:"READ_TIMEOUT" env-default:"15s"`
+	WriteTimeout       time.Duration   `yaml:"write_timeout" toml:"write_timeout" env:"WRITE_TIMEOUT" env-default:"15s"`
+	ShutdownDrainDelay time.Duration   `yaml:"shutdown_drain_delay" toml:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY" env-default:"0s"`
+	IdempotencyTTL     time.Duration   `yaml:"idempotency_ttl" toml:"idempotency_ttl" env:"IDEMPOTENCY_TTL" env-default:"24h"`
+	RateLimit          RateLimitConfig `yaml:"rate_limit" toml:"rate_limit" env-prefix:"RATE_LIMIT_"`
+	Lockout            LockoutConfig   `yaml:"lockout" toml:"lockout" env-prefix:"LOCKOUT_"`
+	MFA                MFAConfig       `yaml:"mfa" toml:"mfa" env-prefix:"MFA_"`
+	WebAuthn           WebAuthnConfig  `yaml:"webauthn" toml:"webauthn" env-prefix:"WEBAUTHN_"`
+	Mail               MailConfig      `yaml:"mail" toml:"mail" env-prefix:"MAIL_"`
+	Auth               AuthConfig      `yaml:"auth" toml:"auth" env-prefix:"AUTH_"`
+	Outbox             OutboxConfig    `yaml:"outbox" toml:"outbox" env-prefix:"OUTBOX_"`
+	Webhook            WebhookConfig   `yaml:"webhook" toml:"webhook" env-prefix:"WEBHOOK_"`
+	Watch              WatchConfig     `yaml:"watch" toml:"watch" env-prefix:"WATCH_"`
+	CORS               CORSConfig      `yaml:"cors" toml:"cors" env-prefix:"CORS_"`
+	AdminToken         string          `yaml:"admin_token" toml:"admin_token" env:"ADMIN_TOKEN" secret:"true"`
+	AllowSignup        bool            `yaml:"allow_signup" toml:"allow_signup" env:"ALLOW_SIGNUP" env-default:"false"`
+}
+
+// UnixSocketPath returns the socket path for addresses like
+// unix:///run/go-user/http.sock.
+func UnixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixScheme)
+}
+
+// SocketMode returns file mode for unix sockets.
+func (cfg *Config) SocketMode() os.FileMode {
+	mode, _ := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// UnmarshalJSON decodes JSON config files with the YAML decoder, which
+// understands the yaml tags and duration strings like "15s".
+func (cfg *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	return yaml.Unmarshal(data, (*plain)(cfg))
 }
 
+// NewConfig loads configuration from the file pointed by CONFIG_PATH
+// (config.yaml by default), overrides it with environment variables
+// and validates the result.
+//
+// When CONFIG_PATH is not set and config.yaml does not exist the
+// configuration is read from the environment only.
 func NewConfig() (*Config, error) {
-	cfgPath := os.Getenv("CONFIG_PATH")
-	if cfgPath == "" {
-		cfgPath = "config.yaml"
+	cfg, err := Load(Path())
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(cfgPath); err != nil {
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
+	return cfg, nil
+}
+
+// Load reads configuration without validating it. YAML, TOML and JSON
+// files are supported, the format is chosen by the file extension.
+func Load(cfgPath string) (*Config, error) {
 	var cfg Config
-	if err := cleanenv.ReadConfig(cfgPath, &cfg); err != nil {
+
+	if err := read(cfgPath, &cfg); err != nil {
+		return nil, err
+	}
+
+	if err := readSecretFiles(&cfg); err != nil {
 		return nil, err
 	}
 
 	return &cfg, nil
 }
+
+// Path returns the config file in use, or an empty string when the
+// configuration is read from the environment only.
+func Path() string {
+	if cfgPath := os.Getenv("CONFIG_PATH"); cfgPath != "" {
+		return cfgPath
+	}
+
+	if _, err := os.Stat(defaultConfigPath); errors.Is(err, fs.ErrNotExist) {
+		return ""
+	}
+
+	return defaultConfigPath
+}
+
+func read(cfgPath string, cfg *Config) error {
+	if cfgPath == "" {
+		return cleanenv.ReadEnv(cfg)
+	}
+
+	if err := cleanenv.ReadConfig(cfgPath, cfg); err != nil {
+		return fmt.Errorf("config %s: %w", cfgPath, err)
+	}
+
+	return nil
+}
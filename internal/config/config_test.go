@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	return path
+}
+
+func TestNewConfig_EnvOnly(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("LISTEN", "127.0.0.1:9000")
+
+	cfg, err := NewConfig()
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:9000", cfg.Listen)
+	assert.Equal(t, ":5000", cfg.ListenGRPC)
+	assert.Equal(t, "main.db", cfg.StoragePath)
+	assert.Equal(t, 15*time.Second, cfg.ReadTimeout)
+	assert.Equal(t, 15*time.Second, cfg.WriteTimeout)
+}
+
+func TestNewConfig_MissingExplicitFile(t *testing.T) {
+	t.Setenv("CONFIG_PATH", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	_, err := NewConfig()
+	require.Error(t, err)
+}
+
+func TestLoad_Formats(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: "listen_grpc: :7000\nread_timeout: 3s\n",
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			data: "listen_grpc = \":7000\"\nread_timeout = \"3s\"\n",
+		},
+		{
+			name: "json",
+			file: "config.json",
+			data: `{"listen_grpc": ":7000", "read_timeout": "3s"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(writeFile(t, tt.file, tt.data))
+			require.NoError(t, err)
+
+			assert.Equal(t, ":7000", cfg.ListenGRPC)
+			assert.Equal(t, 3*time.Second, cfg.ReadTimeout)
+			assert.Equal(t, 15*time.Second, cfg.WriteTimeout)
+		})
+	}
+}
+
+func TestLoad_SecretFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("STORAGE_PATH_FILE", writeFile(t, "storage", "/data/users.db\n"))
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/data/users.db", cfg.StoragePath)
+}
+
+func TestValidate_AggregatesErrors(t *testing.T) {
+	cfg := &Config{
+		Listen:         "localhost",
+		ListenGRPC:     ":5000",
+		UnixSocketMode: "0660",
+		ReadTimeout:    -time.Second,
+		WriteTimeout:   time.Second,
+	}
+
+	err := cfg.Validate()
+	require.Error(t, err)
+
+	lines := strings.Split(err.Error(), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, err.Error(), "listen: invalid address")
+	assert.Contains(t, err.Error(), "storage_path: must not be empty")
+	assert.Contains(t, err.Error(), "read_timeout: must be positive")
+}
+
+func TestValidate_MailTransport(t *testing.T) {
+	mail := MailConfig{
+		From:      "go-user@localhost",
+		TokenKey:  base64.StdEncoding.EncodeToString(make([]byte, mailTokenKeySize)),
+		VerifyTTL: time.Hour,
+		ResetTTL:  time.Hour,
+	}
+	assert.ErrorContains(t, validateMail("mail", mail), "mail.transport: required with token_key")
+
+	mail.Transport = MailTransportLog
+	assert.NoError(t, validateMail("mail", mail))
+
+	assert.NoError(t, validateMail("mail", MailConfig{}), "not required while mail is disabled")
+}
+
+func TestPrint(t *testing.T) {
+	cfg := &Config{
+		Listen:       ":8000",
+		ListenGRPC:   ":5000",
+		StoragePath:  "main.db",
+		LogLevel:     "info",
+		TLS:          TLSConfig{CertFile: "server.crt", CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+
+	var out strings.Builder
+	require.NoError(t, cfg.Print(&out))
+
+	assert.Contains(t, out.String(), "listen: :8000\nlisten_grpc: :5000\n")
+	assert.Contains(t, out.String(), "storage_path: main.db\nlog_level: info\n")
+	assert.Contains(t, out.String(), "tls:\n  cert_file: server.crt\n")
+	assert.Contains(t, out.String(), "  cipher_suites:\n    - TLS_AES_128_GCM_SHA256\n")
+	assert.Contains(t, out.String(), "read_timeout: 15s\nwrite_timeout: 1m0s\n")
+}
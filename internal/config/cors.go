@@ -0,0 +1,35 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// CORSConfig lists origins of the pages allowed to call the Connect and
+// gRPC-Web routes from browsers, like https://app.example.com. "*" allows
+// any origin, none allows same origin requests only.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins" env:"ALLOWED_ORIGINS" env-separator:","`
+}
+
+// AllowOrigin reports whether pages of origin may call the routes.
+func (c CORSConfig) AllowOrigin(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
+
+func validateCORS(name string, c CORSConfig) error {
+	var errs []error
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("%s.allowed_origins: invalid origin %q", name, origin))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockoutConfig describes brute-force protection of logins. After every
+// failed attempt the account can't be used for Delay doubled with each
+// failure up to MaxDelay, after MaxAttempts failures it is locked for
+// Duration. MaxAttempts 0 disables the protection.
+type LockoutConfig struct {
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts" env:"MAX_ATTEMPTS" env-default:"5"`
+	Duration    time.Duration `yaml:"duration" toml:"duration" env:"DURATION" env-default:"15m"`
+	Delay       time.Duration `yaml:"delay" toml:"delay" env:"DELAY" env-default:"1s"`
+	MaxDelay    time.Duration `yaml:"max_delay" toml:"max_delay" env:"MAX_DELAY" env-default:"30s"`
+}
+
+// Enabled reports whether failed attempts are tracked.
+func (l LockoutConfig) Enabled() bool {
+	return l.MaxAttempts > 0
+}
+
+// DelayAfter returns how long the account is unavailable after the given
+// number of consecutive failures.
+func (l LockoutConfig) DelayAfter(failures int) time.Duration {
+	if failures >= l.MaxAttempts {
+		return l.Duration
+	}
+
+	delay := l.Delay
+	for range failures - 1 {
+		if delay >= l.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+
+	return min(delay, l.MaxDelay)
+}
+
+func validateLockout(name string, l LockoutConfig) error {
+	if !l.Enabled() {
+		if l.MaxAttempts < 0 {
+			return fmt.Errorf("%s.max_attempts: must not be negative, got %d", name, l.MaxAttempts)
+		}
+		return nil
+	}
+
+	var errs []error
+
+	errs = append(errs,
+		validateDuration(name+".duration", l.Duration),
+		validateNotNegative(name+".delay", l.Delay),
+		validateNotNegative(name+".max_delay", l.MaxDelay),
+	)
+
+	if l.MaxDelay < l.Delay {
+		errs = append(errs, fmt.Errorf("%s.max_delay: must not be less than delay", name))
+	}
+
+	return errors.Join(errs...)
+}
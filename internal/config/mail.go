@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+const (
+	MailTransportSMTP = "smtp"
+	MailTransportFile = "file"
+	MailTransportLog  = "log"
+
+	mailTokenKeySize = 32
+)
+
+// MailConfig describes outgoing mail and the tokens sent by mail. Email
+// verification and password reset are disabled while TokenKey, a base64
+// encoded 32 byte key signing the tokens, is empty.
+//
+// Transport has no default, the log transport writes the tokens to the log
+// and has to be chosen explicitly. Links in messages point to
+// BaseURL/verify-email and BaseURL/reset-password, without BaseURL messages
+// carry the token only.
+type MailConfig struct {
+	Transport string        `yaml:"transport" toml:"transport" env:"TRANSPORT"`
+	From      string        `yaml:"from" toml:"from" env:"FROM" env-default:"go-user@localhost"`
+	Dir       string        `yaml:"dir" toml:"dir" env:"DIR" env-default:"mail"`
+	SMTP      SMTPConfig    `yaml:"smtp" toml:"smtp" env-prefix:"SMTP_"`
+	BaseURL   string        `yaml:"base_url" toml:"base_url" env:"BASE_URL"`
+	TokenKey  string        `yaml:"token_key" toml:"token_key" env:"TOKEN_KEY" secret:"true"`
+	VerifyTTL time.Duration `yaml:"verify_ttl" toml:"verify_ttl" env:"VERIFY_TTL" env-default:"24h"`
+	ResetTTL  time.Duration `yaml:"reset_ttl" toml:"reset_ttl" env:"RESET_TTL" env-default:"1h"`
+}
+
+// SMTPConfig is the server for the smtp transport. STARTTLS is used when
+// the server offers it, credentials are only sent over TLS.
+type SMTPConfig struct {
+	Host     string `yaml:"host" toml:"host" env:"HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"PORT" env-default:"587"`
+	Username string `yaml:"username" toml:"username" env:"USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"PASSWORD" secret:"true"`
+}
+
+func (m MailConfig) Enabled() bool {
+	return m.TokenKey != ""
+}
+
+// Key returns the decoded token key.
+func (m MailConfig) Key() []byte {
+	key, _ := base64.StdEncoding.DecodeString(m.TokenKey)
+	return key
+}
+
+func validateMail(name string, m MailConfig) error {
+	if !m.Enabled() {
+		return nil
+	}
+
+	var errs []error
+
+	switch m.Transport {
+	case MailTransportSMTP:
+		errs = append(errs, validateNotEmpty(name+".smtp.host", m.SMTP.Host))
+		if m.SMTP.Port <= 0 || m.SMTP.Port > 65535 {
+			errs = append(errs, fmt.Errorf("%s.smtp.port: invalid port %d", name, m.SMTP.Port))
+		}
+	case MailTransportFile:
+		errs = append(errs, validateNotEmpty(name+".dir", m.Dir))
+	case MailTransportLog:
+	case "":
+		errs = append(errs, fmt.Errorf("%s.transport: required with token_key, one of smtp, file or log", name))
+	default:
+		errs = append(errs, fmt.Errorf("%s.transport: must be one of smtp, file or log, got %q", name, m.Transport))
+	}
+
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		errs = append(errs, fmt.Errorf("%s.from: %w", name, err))
+	}
+
+	if m.BaseURL != "" {
+		if u, err := url.Parse(m.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.base_url: invalid url %q", name, m.BaseURL))
+		}
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(m.TokenKey); err != nil || len(key) != mailTokenKeySize {
+		errs = append(errs, fmt.Errorf("%s.token_key: must be %d bytes encoded with base64", name, mailTokenKeySize))
+	}
+
+	errs = append(errs,
+		validateDuration(name+".verify_ttl", m.VerifyTTL),
+		validateDuration(name+".reset_ttl", m.ResetTTL),
+	)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const mfaKeySize = 32
+
+// MFAConfig describes TOTP two-factor authentication. TOTP secrets are
+// encrypted with EncryptionKey, a base64 encoded 32 byte key, enrolment is
+// disabled while it is empty.
+type MFAConfig struct {
+	Issuer        string        `yaml:"issuer" toml:"issuer" env:"ISSUER" env-default:"go-user"`
+	EncryptionKey string        `yaml:"encryption_key" toml:"encryption_key" env:"ENCRYPTION_KEY" secret:"true"`
+	ChallengeTTL  time.Duration `yaml:"challenge_ttl" toml:"challenge_ttl" env:"CHALLENGE_TTL" env-default:"5m"`
+}
+
+func (m MFAConfig) Enabled() bool {
+	return m.EncryptionKey != ""
+}
+
+// Key returns the decoded encryption key.
+func (m MFAConfig) Key() []byte {
+	key, _ := base64.StdEncoding.DecodeString(m.EncryptionKey)
+	return key
+}
+
+func validateMFA(name string, m MFAConfig) error {
+	if !m.Enabled() {
+		return nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(m.EncryptionKey); err != nil || len(key) != mfaKeySize {
+		return fmt.Errorf("%s.encryption_key: must be %d bytes encoded with base64", name, mfaKeySize)
+	}
+
+	return validateDuration(name+".challenge_ttl", m.ChallengeTTL)
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// reloadDebounce groups bursts of file events (editors usually write a
+// file in several steps) into a single reload.
+const reloadDebounce = 200 * time.Millisecond
+
+// Observer holds the current configuration, reloads it on SIGHUP or when
+// the config file changes and notifies subscribers about new values.
+//
+// Fields tagged with `restart:"true"` can't be changed at runtime, their
+// new values are ignored until the service is restarted.
+type Observer struct {
+	path    string
+	current atomic.Pointer[Config]
+	logger  *zap.SugaredLogger
+
+	mu          sync.Mutex
+	subscribers []func(cfg *Config)
+
+	signals chan os.Signal
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewObserver(cfg *Config, lc fx.Lifecycle, logger *zap.Logger) *Observer {
+	obs := &Observer{
+		path:   Path(),
+		logger: logger.Sugar().Named("ConfigObserver"),
+	}
+	obs.current.Store(cfg)
+
+	lc.Append(fx.Hook{
+		OnStart: obs.Start,
+		OnStop:  obs.Stop,
+	})
+
+	return obs
+}
+
+// Current returns the latest valid configuration.
+func (obs *Observer) Current() *Config {
+	return obs.current.Load()
+}
+
+// Subscribe registers fn to be called with the new configuration after
+// every successful reload.
+func (obs *Observer) Subscribe(fn func(cfg *Config)) {
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+
+	obs.subscribers = append(obs.subscribers, fn)
+}
+
+func (obs *Observer) Start(ctx context.Context) error {
+	obs.done = make(chan struct{})
+	obs.signals = make(chan os.Signal, 1)
+	signal.Notify(obs.signals, syscall.SIGHUP)
+
+	var events <-chan fsnotify.Event
+	if obs.path != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+
+		// watch the directory so that atomic replaces (rename over the file)
+		// and symlink swaps done by kubernetes are noticed as well
+		if err := watcher.Add(filepath.Dir(obs.path)); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+
+		obs.watcher = watcher
+		events = watcher.Events
+	}
+
+	obs.wg.Add(1)
+	go obs.loop(events)
+
+	obs.logger.Infow("Watching config", "path", obs.path)
+
+	return nil
+}
+
+func (obs *Observer) Stop(ctx context.Context) error {
+	signal.Stop(obs.signals)
+	close(obs.done)
+
+	var err error
+	if obs.watcher != nil {
+		err = obs.watcher.Close()
+	}
+
+	obs.wg.Wait()
+
+	return err
+}
+
+func (obs *Observer) loop(events <-chan fsnotify.Event) {
+	defer obs.wg.Done()
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+
+	name := filepath.Clean(obs.path)
+
+	for {
+		select {
+		case <-obs.done:
+			debounce.Stop()
+			return
+		case <-obs.signals:
+			obs.logger.Info("Got SIGHUP, reloading config")
+			_ = obs.Reload()
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if filepath.Clean(event.Name) == name || filepath.Base(event.Name) == "..data" {
+				debounce.Reset(reloadDebounce)
+			}
+		case <-debounce.C:
+			obs.logger.Infow("Config file changed, reloading", "path", obs.path)
+			_ = obs.Reload()
+		}
+	}
+}
+
+// Reload reads and validates the configuration and publishes it to the
+// subscribers. Invalid configuration is logged and ignored.
+func (obs *Observer) Reload() error {
+	cfg, err := Load(obs.path)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		obs.logger.Errorw("Config reload failed, keeping current config", "error", err)
+		return err
+	}
+
+	current := obs.Current()
+	for _, field := range keepRestartFields(current, cfg) {
+		obs.logger.Warnw("Config field requires restart, change ignored", "field", field)
+	}
+
+	if reflect.DeepEqual(current, cfg) {
+		obs.logger.Info("Config unchanged")
+		return nil
+	}
+
+	obs.current.Store(cfg)
+
+	obs.mu.Lock()
+	subscribers := append([]func(*Config){}, obs.subscribers...)
+	obs.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(cfg)
+	}
+
+	obs.logger.Info("Config reloaded")
+
+	return nil
+}
+
+// keepRestartFields copies values of fields tagged `restart:"true"`
+// from current into next and returns yaml names of the changed ones.
+func keepRestartFields(current, next *Config) []string {
+	return keepRestartFieldsStruct(reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem(), "")
+}
+
+func keepRestartFieldsStruct(current, next reflect.Value, prefix string) []string {
+	var changed []string
+
+	t := current.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := prefix + yamlName(field)
+
+		if field.Tag.Get("restart") != "true" {
+			if field.Type.Kind() == reflect.Struct {
+				changed = append(changed, keepRestartFieldsStruct(current.Field(i), next.Field(i), name+".")...)
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(current.Field(i).Interface(), next.Field(i).Interface()) {
+			changed = append(changed, name)
+			next.Field(i).Set(current.Field(i))
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestObserver_Reload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "listen: :8000\nlog_level: info\n")
+	t.Setenv("CONFIG_PATH", path)
+
+	cfg, err := NewConfig()
+	require.NoError(t, err)
+
+	obs := NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+
+	var got *Config
+	obs.Subscribe(func(cfg *Config) { got = cfg })
+
+	require.NoError(t, os.WriteFile(path, []byte("listen: :9000\nlog_level: debug\nread_timeout: 5s\n"), 0o600))
+	require.NoError(t, obs.Reload())
+
+	require.NotNil(t, got)
+	assert.Same(t, got, obs.Current())
+	assert.Equal(t, "debug", got.LogLevel)
+	assert.Equal(t, 5*time.Second, got.ReadTimeout)
+	assert.Equal(t, ":8000", got.Listen, "restart only field must keep its value")
+}
+
+func TestObserver_ReloadInvalid(t *testing.T) {
+	path := writeFile(t, "config.yaml", "log_level: info\n")
+	t.Setenv("CONFIG_PATH", path)
+
+	cfg, err := NewConfig()
+	require.NoError(t, err)
+
+	obs := NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+	obs.Subscribe(func(*Config) { t.Error("subscriber must not be called") })
+
+	require.NoError(t, os.WriteFile(path, []byte("log_level: loud\n"), 0o600))
+	require.Error(t, obs.Reload())
+
+	assert.Same(t, cfg, obs.Current())
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OutboxConfig describes delivery of domain events. The relay reads up to
+// BatchSize due events every PollInterval, failed deliveries are retried
+// after RetryDelay doubled with each attempt up to MaxRetryDelay, an event
+// failing MaxAttempts times is dead. Delivered events are removed after
+// Retention, 0 keeps them forever.
+// BatchSize 0 pauses delivery, events are kept in the outbox.
+type OutboxConfig struct {
+	PollInterval  time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"POLL_INTERVAL" env-default:"1s"`
+	BatchSize     int           `yaml:"batch_size" toml:"batch_size" env:"BATCH_SIZE" env-default:"100"`
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout" env:"TIMEOUT" env-default:"10s"`
+	MaxAttempts   int           `yaml:"max_attempts" toml:"max_attempts" env:"MAX_ATTEMPTS" env-default:"20"`
+	RetryDelay    time.Duration `yaml:"retry_delay" toml:"retry_delay" env:"RETRY_DELAY" env-default:"1s"`
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay" toml:"max_retry_delay" env:"MAX_RETRY_DELAY" env-default:"1h"`
+	Retention     time.Duration `yaml:"retention" toml:"retention" env:"RETENTION" env-default:"168h"`
+}
+
+// Enabled reports whether events are delivered.
+func (o OutboxConfig) Enabled() bool {
+	return o.BatchSize > 0
+}
+
+// RetryAfter returns how long to wait before the next delivery after the
+// given number of failed attempts.
+func (o OutboxConfig) RetryAfter(attempts int) time.Duration {
+	return backoff(o.RetryDelay, o.MaxRetryDelay, attempts)
+}
+
+func validateOutbox(name string, o OutboxConfig) error {
+	if !o.Enabled() {
+		if o.BatchSize < 0 {
+			return fmt.Errorf("%s.batch_size: must not be negative, got %d", name, o.BatchSize)
+		}
+		return nil
+	}
+
+	var errs []error
+
+	errs = append(errs,
+		validateDuration(name+".poll_interval", o.PollInterval),
+		validateDuration(name+".timeout", o.Timeout),
+		validateDuration(name+".retry_delay", o.RetryDelay),
+		validateNotNegative(name+".retention", o.Retention),
+	)
+
+	if o.MaxAttempts <= 0 {
+		errs = append(errs, fmt.Errorf("%s.max_attempts: must be positive, got %d", name, o.MaxAttempts))
+	}
+	if o.MaxRetryDelay < o.RetryDelay {
+		errs = append(errs, fmt.Errorf("%s.max_retry_delay: must not be less than retry_delay", name))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// Print writes the effective configuration to w as YAML. Fields tagged
+// with `secret:"true"` are redacted.
+func (cfg *Config) Print(w io.Writer) error {
+	node, err := printNode(reflect.ValueOf(cfg).Elem())
+	if err != nil {
+		return err
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+func printNode(v reflect.Value) (*yaml.Node, error) {
+	t := v.Type()
+	node := &yaml.Node{Kind: yaml.MappingNode}
+
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+
+		name := yamlName(field)
+		if name == "" || name == "-" {
+			continue
+		}
+
+		var valueNode *yaml.Node
+		switch {
+		case field.Tag.Get("secret") == "true":
+			valueNode = &yaml.Node{Kind: yaml.ScalarNode}
+			if !value.IsZero() {
+				valueNode.Value = redacted
+			}
+		case field.Type == durationType:
+			valueNode = &yaml.Node{Kind: yaml.ScalarNode, Value: value.Interface().(time.Duration).String()}
+		case field.Type.Kind() == reflect.Struct:
+			var err error
+			if valueNode, err = printNode(value); err != nil {
+				return nil, err
+			}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			valueNode = &yaml.Node{Kind: yaml.SequenceNode}
+			for j := range value.Len() {
+				item, err := printNode(value.Index(j))
+				if err != nil {
+					return nil, err
+				}
+				valueNode.Content = append(valueNode.Content, item)
+			}
+		default:
+			valueNode = &yaml.Node{}
+			if err := valueNode.Encode(value.Interface()); err != nil {
+				return nil, err
+			}
+		}
+
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, valueNode)
+	}
+
+	return node, nil
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return name
+}
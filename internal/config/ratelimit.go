@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Rate limit keys.
+const (
+	RateLimitKeyIP    = "ip"
+	RateLimitKeyUser  = "user"
+	RateLimitKeyLogin = "login"
+)
+
+// RateLimitConfig describes token bucket limits applied to HTTP routes and
+// gRPC methods. Client IP is taken from X-Forwarded-For only when the
+// request comes from one of TrustedProxies.
+type RateLimitConfig struct {
+	Enabled        bool            `yaml:"enabled" toml:"enabled" env:"ENABLED" env-default:"false"`
+	TrustedProxies []string        `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES" env-separator:","`
+	Rules          []RateLimitRule `yaml:"rules" toml:"rules"`
+}
+
+// RateLimitRule allows Requests per Period with bursts up to Burst
+// (Requests when not set) for every distinct Key.
+//
+// Match is an HTTP route pattern as registered in the mux, optionally
+// prefixed with a method ("POST /user/"), or a full gRPC method name
+// ("/user.v1.UserService/Create").
+type RateLimitRule struct {
+	Match    string        `yaml:"match" toml:"match"`
+	Key      string        `yaml:"key" toml:"key"`
+	Requests int           `yaml:"requests" toml:"requests"`
+	Period   time.Duration `yaml:"period" toml:"period"`
+	Burst    int           `yaml:"burst" toml:"burst"`
+}
+
+// Route splits Match into the HTTP method and the route pattern.
+func (r RateLimitRule) Route() (method, pattern string) {
+	if method, pattern, ok := strings.Cut(r.Match, " "); ok {
+		return method, strings.TrimSpace(pattern)
+	}
+
+	return "", r.Match
+}
+
+// Rate returns the number of tokens added to the bucket per second.
+func (r RateLimitRule) Rate() float64 {
+	return float64(r.Requests) / r.Period.Seconds()
+}
+
+// BurstSize returns the bucket capacity.
+func (r RateLimitRule) BurstSize() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+
+	return r.Requests
+}
+
+// Proxies parses TrustedProxies, single addresses become /32 or /128
+// prefixes.
+func (c RateLimitConfig) Proxies() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parseProxy(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
+
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		return netip.ParsePrefix(proxy)
+	}
+
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func validateRateLimit(name string, c RateLimitConfig) error {
+	var errs []error
+
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("%s.trusted_proxies: invalid address %q", name, proxy))
+		}
+	}
+
+	for i, rule := range c.Rules {
+		prefix := fmt.Sprintf("%s.rules[%d]", name, i)
+
+		if rule.Match == "" {
+			errs = append(errs, fmt.Errorf("%s.match: must not be empty", prefix))
+		}
+
+		switch rule.Key {
+		case RateLimitKeyIP, RateLimitKeyUser, RateLimitKeyLogin:
+		default:
+			errs = append(errs, fmt.Errorf("%s.key: unknown key %q, expected one of ip, user, login", prefix, rule.Key))
+		}
+
+		if rule.Requests <= 0 {
+			errs = append(errs, fmt.Errorf("%s.requests: must be positive, got %d", prefix, rule.Requests))
+		}
+
+		errs = append(errs, validateDuration(prefix+".period", rule.Period))
+
+		if rule.Burst < 0 {
+			errs = append(errs, fmt.Errorf("%s.burst: must not be negative, got %d", prefix, rule.Burst))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+// backoff returns delay doubled for every attempt after the first, up to
+// maxDelay.
+func backoff(delay, maxDelay time.Duration, attempts int) time.Duration {
+	for range attempts - 1 {
+		if delay >= maxDelay {
+			break
+		}
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// secretFileSuffix is appended to the env name of a string field to
+// read its value from a file, e.g. JWT_SECRET_FILE=/run/secrets/jwt.
+const secretFileSuffix = "_FILE"
+
+func readSecretFiles(cfg *Config) error {
+	return readSecretFilesStruct(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func readSecretFilesStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := readSecretFilesStruct(value, prefix+field.Tag.Get("env-prefix")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env := field.Tag.Get("env")
+		if env == "" || field.Type.Kind() != reflect.String {
+			continue
+		}
+
+		path, ok := os.LookupEnv(prefix + env + secretFileSuffix)
+		if !ok {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s%s%s: %w", prefix, env, secretFileSuffix, err)
+		}
+
+		value.SetString(strings.TrimRight(string(data), "\r\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// TLSConfig describes a TLS listener. TLS is enabled when CertFile is set,
+// setting ClientCAFile additionally requires clients to present a
+// certificate signed by one of the CAs in the bundle (mTLS).
+//
+// Certificates are reloaded automatically when the files change.
+type TLSConfig struct {
+	CertFile     string   `yaml:"cert_file" toml:"cert_file" env:"CERT_FILE"`
+	KeyFile      string   `yaml:"key_file" toml:"key_file" env:"KEY_FILE"`
+	ClientCAFile string   `yaml:"client_ca_file" toml:"client_ca_file" env:"CLIENT_CA_FILE"`
+	MinVersion   string   `yaml:"min_version" toml:"min_version" env:"MIN_VERSION" env-default:"1.2"`
+	CipherSuites []string `yaml:"cipher_suites" toml:"cipher_suites" env:"CIPHER_SUITES" env-separator:","`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Version returns the minimal TLS version as tls.VersionTLS* constant.
+func (t TLSConfig) Version() uint16 {
+	return tlsVersions[t.MinVersion]
+}
+
+// CipherSuiteIDs maps cipher suite names to their IDs. Empty list means
+// Go defaults. Cipher suites are not configurable for TLS 1.3.
+func (t TLSConfig) CipherSuiteIDs() []uint16 {
+	if len(t.CipherSuites) == 0 {
+		return nil
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		if suite := cipherSuite(name); suite != nil {
+			ids = append(ids, suite.ID)
+		}
+	}
+
+	return ids
+}
+
+func cipherSuite(name string) *tls.CipherSuite {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite
+		}
+	}
+
+	return nil
+}
+
+func validateTLS(name string, t TLSConfig) error {
+	var errs []error
+
+	if t.Enabled() != (t.KeyFile != "") {
+		errs = append(errs, fmt.Errorf("%s: cert_file and key_file must be set together", name))
+	}
+
+	if !t.Enabled() {
+		if t.ClientCAFile != "" {
+			errs = append(errs, fmt.Errorf("%s: client_ca_file requires cert_file and key_file", name))
+		}
+		return errors.Join(errs...)
+	}
+
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("%s: unknown min_version %q, expected one of 1.0, 1.1, 1.2, 1.3", name, t.MinVersion))
+	}
+
+	for _, suite := range t.CipherSuites {
+		if cipherSuite(suite) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown or insecure cipher suite %q", name, suite))
+		}
+	}
+
+	return errors.Join(errs...)
+}
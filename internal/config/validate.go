@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Validate checks addresses and durations and returns all problems
+// found joined into one error.
+func (cfg *Config) Validate() error {
+	return errors.Join(
+		validateAddr("listen", cfg.Listen),
+		validateAddr("listen_grpc", cfg.ListenGRPC),
+		validateFileMode("unix_socket_mode", cfg.UnixSocketMode),
+		validateNotEmpty("storage_path", cfg.StoragePath),
+		validateLogLevel("log_level", cfg.LogLevel),
+		validateTLS("tls", cfg.TLS),
+		validateTLS("grpc_tls", cfg.GRPCTLS),
+		validateSinglePort(cfg),
+		validateDuration("read_timeout", cfg.ReadTimeout),
+		validateDuration("write_timeout", cfg.WriteTimeout),
+		validateNotNegative("shutdown_drain_delay", cfg.ShutdownDrainDelay),
+		validateNotNegative("idempotency_ttl", cfg.IdempotencyTTL),
+		validateRateLimit("rate_limit", cfg.RateLimit),
+		validateLockout("lockout", cfg.Lockout),
+		validateMFA("mfa", cfg.MFA),
+		validateWebAuthn("webauthn", cfg.WebAuthn),
+		validateMail("mail", cfg.Mail),
+		validateAuth("auth", cfg.Auth),
+		validateOutbox("outbox", cfg.Outbox),
+		validateWebhook("webhook", cfg.Webhook),
+		validateWatch("watch", cfg.Watch),
+		validateCORS("cors", cfg.CORS),
+	)
+}
+
+func validateAddr(name, addr string) error {
+	if path, ok := UnixSocketPath(addr); ok {
+		if path == "" {
+			return fmt.Errorf("%s: empty unix socket path in %q", name, addr)
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: invalid address %q: %w", name, addr, err)
+	}
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("%s: invalid port %q", name, port)
+		}
+	}
+
+	return nil
+}
+
+func validateNotEmpty(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s: must not be empty", name)
+	}
+
+	return nil
+}
+
+func validateDuration(name string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s: must be positive, got %s", name, d)
+	}
+
+	return nil
+}
+
+func validateNotNegative(name string, d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("%s: must not be negative, got %s", name, d)
+	}
+
+	return nil
+}
+
+func validateLogLevel(name, level string) error {
+	if _, err := zapcore.ParseLevel(level); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
+
+func validateSinglePort(cfg *Config) error {
+	if cfg.SinglePort && cfg.GRPCTLS.Enabled() {
+		return errors.New("grpc_tls: not supported with single_port, TLS for both protocols is set in tls")
+	}
+
+	return nil
+}
+
+func validateFileMode(name, mode string) error {
+	if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+		return fmt.Errorf("%s: invalid octal file mode %q", name, mode)
+	}
+
+	return nil
+}
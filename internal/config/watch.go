@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WatchConfig describes change feeds of users. New events are read every
+// PollInterval and queued for every watcher, a watcher with more than
+// Buffer events queued is disconnected and has to resume from its last
+// revision. Idle SSE streams get a comment every Heartbeat. Buffer 0
+// disables change feeds.
+type WatchConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"POLL_INTERVAL" env-default:"1s"`
+	Buffer       int           `yaml:"buffer" toml:"buffer" env:"BUFFER" env-default:"256"`
+	Heartbeat    time.Duration `yaml:"heartbeat" toml:"heartbeat" env:"HEARTBEAT" env-default:"15s"`
+}
+
+// Enabled reports whether users can be watched.
+func (w WatchConfig) Enabled() bool {
+	return w.Buffer > 0
+}
+
+func validateWatch(name string, w WatchConfig) error {
+	if !w.Enabled() {
+		if w.Buffer < 0 {
+			return fmt.Errorf("%s.buffer: must not be negative, got %d", name, w.Buffer)
+		}
+		return nil
+	}
+
+	return errors.Join(
+		validateDuration(name+".poll_interval", w.PollInterval),
+		validateDuration(name+".heartbeat", w.Heartbeat),
+	)
+}
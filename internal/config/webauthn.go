@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// WebAuthnConfig describes the WebAuthn relying party, passkeys are
+// enabled when RPID is set. RPOrigins lists origins of the pages allowed
+// to run ceremonies, like https://login.example.com.
+type WebAuthnConfig struct {
+	RPID          string        `yaml:"rp_id" toml:"rp_id" env:"RP_ID"`
+	RPDisplayName string        `yaml:"rp_display_name" toml:"rp_display_name" env:"RP_DISPLAY_NAME" env-default:"go-user"`
+	RPOrigins     []string      `yaml:"rp_origins" toml:"rp_origins" env:"RP_ORIGINS" env-separator:","`
+	SessionTTL    time.Duration `yaml:"session_ttl" toml:"session_ttl" env:"SESSION_TTL" env-default:"5m"`
+}
+
+func (w WebAuthnConfig) Enabled() bool {
+	return w.RPID != ""
+}
+
+func validateWebAuthn(name string, w WebAuthnConfig) error {
+	if !w.Enabled() {
+		return nil
+	}
+
+	var errs []error
+
+	if len(w.RPOrigins) == 0 {
+		errs = append(errs, fmt.Errorf("%s.rp_origins: must not be empty", name))
+	}
+
+	for _, origin := range w.RPOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.rp_origins: invalid origin %q", name, origin))
+		}
+	}
+
+	errs = append(errs, validateDuration(name+".session_ttl", w.SessionTTL))
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WebhookConfig describes sending of webhooks. Up to BatchSize due
+// deliveries are sent every PollInterval, a delivery failing MaxAttempts
+// times is dead. Retries wait RetryDelay doubled with each attempt up to
+// MaxRetryDelay. BatchSize 0 pauses sending. Loopback, link-local and
+// private addresses are refused unless AllowPrivate is set.
+type WebhookConfig struct {
+	PollInterval  time.Duration `yaml:"poll_interval" toml:"poll_interval" env:"POLL_INTERVAL" env-default:"1s"`
+	BatchSize     int           `yaml:"batch_size" toml:"batch_size" env:"BATCH_SIZE" env-default:"100"`
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout" env:"TIMEOUT" env-default:"10s"`
+	MaxAttempts   int           `yaml:"max_attempts" toml:"max_attempts" env:"MAX_ATTEMPTS" env-default:"10"`
+	RetryDelay    time.Duration `yaml:"retry_delay" toml:"retry_delay" env:"RETRY_DELAY" env-default:"10s"`
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay" toml:"max_retry_delay" env:"MAX_RETRY_DELAY" env-default:"6h"`
+	AllowPrivate  bool          `yaml:"allow_private" toml:"allow_private" env:"ALLOW_PRIVATE"`
+}
+
+// Enabled reports whether webhooks are sent.
+func (w WebhookConfig) Enabled() bool {
+	return w.BatchSize > 0
+}
+
+// RetryAfter returns how long to wait before the next attempt after the
+// given number of failed attempts.
+func (w WebhookConfig) RetryAfter(attempts int) time.Duration {
+	return backoff(w.RetryDelay, w.MaxRetryDelay, attempts)
+}
+
+func validateWebhook(name string, w WebhookConfig) error {
+	if !w.Enabled() {
+		if w.BatchSize < 0 {
+			return fmt.Errorf("%s.batch_size: must not be negative, got %d", name, w.BatchSize)
+		}
+		return nil
+	}
+
+	var errs []error
+
+	errs = append(errs,
+		validateDuration(name+".poll_interval", w.PollInterval),
+		validateDuration(name+".timeout", w.Timeout),
+		validateDuration(name+".retry_delay", w.RetryDelay),
+	)
+
+	if w.MaxAttempts <= 0 {
+		errs = append(errs, fmt.Errorf("%s.max_attempts: must be positive, got %d", name, w.MaxAttempts))
+	}
+	if w.MaxRetryDelay < w.RetryDelay {
+		errs = append(errs, fmt.Errorf("%s.max_retry_delay: must not be less than retry_delay", name))
+	}
+
+	return errors.Join(errs...)
+}
@@ -3,17 +3,40 @@ package db
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/iliadmitriev/go-user-test/internal/config"
 )
 
-type DB interface {
+// Querier runs statements, both DB and transactions are queriers.
+type Querier interface {
 	ExecContext(context.Context, string, ...any) (sql.Result, error)
 	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
 }
 
+type DB interface {
+	Querier
+
+	BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+}
+
 func NewSqliteDB(cfg *config.Config) (DB, error) {
-	return sql.Open("sqlite3", cfg.StoragePath)
+	return sql.Open("sqlite3", immediateTx(cfg.StoragePath))
+}
+
+// immediateTx makes transactions take the write lock when they begin.
+// Transactions read before they write, two of them upgrading their read
+// locks at the same time would fail with "database is locked".
+func immediateTx(dsn string) string {
+	if strings.Contains(dsn, "_txlock=") {
+		return dsn
+	}
+
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_txlock=immediate"
+	}
+
+	return dsn + "?_txlock=immediate"
 }
@@ -0,0 +1,45 @@
+// Package dbtest creates databases for tests.
+package dbtest
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/db"
+)
+
+// schema reads main.sql from the root of the repository once, wherever the
+// tests run.
+var schema = sync.OnceValues(func() ([]byte, error) {
+	_, file, _, _ := runtime.Caller(0)
+
+	return os.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "main.sql"))
+})
+
+// New returns a sqlite database in a temporary directory with the schema
+// from main.sql, closed when the test ends.
+func New(t testing.TB) db.DB {
+	t.Helper()
+
+	statements, err := schema()
+	require.NoError(t, err)
+
+	sqlite, err := db.NewSqliteDB(&config.Config{StoragePath: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	if closer, ok := sqlite.(io.Closer); ok {
+		t.Cleanup(func() { _ = closer.Close() })
+	}
+
+	_, err = sqlite.ExecContext(context.Background(), string(statements))
+	require.NoError(t, err)
+
+	return sqlite
+}
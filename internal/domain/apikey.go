@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+// APIKey is a key for service-to-service access. Only a hash of the secret
+// part is stored, Scopes are permissions granted on every user.
+type APIKey struct {
+	ID         string
+	Name       string
+	SecretHash string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+type APIKeyIn struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeyOut describes a key, Key holds the whole key and is only returned
+// once on creation.
+type APIKeyOut struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit actions, one per kind of change of a user.
+const (
+	AuditCreateUser      = "user.create"
+	AuditUpdateUser      = "user.update"
+	AuditDeleteUser      = "user.delete"
+	AuditSetPassword     = "user.set_password"
+	AuditVerifyEmail     = "user.verify_email"
+	AuditSetLockout      = "user.set_lockout"
+	AuditSetRoles        = "user.set_roles"
+	AuditEnrollTOTP      = "user.enroll_totp"
+	AuditConfirmTOTP     = "user.confirm_totp"
+	AuditUseRecoveryCode = "user.use_recovery_code"
+	AuditFailLogin       = "user.fail_login"
+	AuditAddPasskey      = "user.add_passkey"
+	AuditUsePasskey      = "user.use_passkey"
+	AuditDeletePasskey   = "user.delete_passkey"
+)
+
+// AuditEvent records a change of a user. Diff holds the changed fields,
+// secrets are redacted.
+type AuditEvent struct {
+	ID        int64                  `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Actor     string                 `json:"actor"`
+	Action    string                 `json:"action"`
+	UserID    uuid.UUID              `json:"user_id"`
+	Login     string                 `json:"login"`
+	RequestID string                 `json:"request_id,omitempty"`
+	ClientIP  string                 `json:"client_ip,omitempty"`
+	Diff      map[string]AuditChange `json:"diff"`
+}
+
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditFilter selects audit events, empty fields match any event. Events
+// are returned in order, AfterID continues after the last seen event.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	UserID    *uuid.UUID
+	Login     string
+	RequestID string
+	Since     *time.Time
+	Until     *time.Time
+	AfterID   int64
+	Limit     int
+}
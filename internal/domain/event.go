@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published through the outbox.
+const (
+	EventUserCreated     = "user.created"
+	EventUserUpdated     = "user.updated"
+	EventUserDeleted     = "user.deleted"
+	EventPasswordChanged = "user.password_changed"
+)
+
+// Event is a change of a user published to other services. Events are
+// delivered at least once, Key is unique per event and lets consumers drop
+// duplicates. ID is the position in the outbox, change feeds use it as the
+// revision.
+type Event struct {
+	ID         int64     `json:"-"`
+	Key        string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	User       EventUser `json:"user"`
+	// Changed lists the changed fields of updated users
+	Changed []string `json:"changed,omitempty"`
+	// Attempts counts failed deliveries
+	Attempts int `json:"-"`
+}
+
+// EventUser is the state of the user after the change, or before it for
+// deleted users.
+type EventUser struct {
+	ID            uuid.UUID `json:"id"`
+	Login         string    `json:"login"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"email_verified"`
+	Roles         []string  `json:"roles"`
+}
@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// IdempotencyRecord is the first request a caller made with an idempotency
+// key. Response is nil while the request is being served.
+type IdempotencyRecord struct {
+	Caller      string
+	Key         string
+	Fingerprint string
+	Response    []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Passkey is a WebAuthn credential of a user. Credential keeps the
+// credential record as JSON, SignCount mirrors its signature counter.
+type Passkey struct {
+	ID         []byte
+	UserID     uuid.UUID
+	Name       string
+	Credential []byte
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// PasskeyRegistration starts registration of a passkey, Code is required
+// when the user has TOTP enabled.
+type PasskeyRegistration struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+}
+
+// PasskeyCeremony holds options for navigator.credentials.create() or
+// get() and the session to finish the ceremony with.
+type PasskeyCeremony struct {
+	SessionID string          `json:"session_id"`
+	Options   json.RawMessage `json:"options"`
+}
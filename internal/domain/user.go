@@ -10,6 +10,9 @@ type UserIn struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+	// IdempotencyKey makes retries of the request return the first response
+	IdempotencyKey string `json:"-"`
 }
 
 type UserOut struct {
@@ -18,13 +21,121 @@ type UserOut struct {
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Version grows with every change of the name or email
+	Version int64 `json:"version"`
+	// Email, Lockout and Roles are only filled for administrators
+	Email           string     `json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Lockout         *Lockout   `json:"lockout,omitempty"`
+	Roles           []string   `json:"roles,omitempty"`
+}
+
+// UserUpdate changes the fields which are set, a changed email has to be
+// verified again.
+type UserUpdate struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+}
+
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// MFACode is the second login step, either a TOTP code or a recovery code.
+type MFACode struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// PasswordReset sets a new password with a token sent by mail.
+type PasswordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// LoginResult holds the user, or the token for the second step when the
+// user has two-factor authentication enabled. AccessToken is only issued
+// when access tokens are configured.
+type LoginResult struct {
+	User        *UserOut
+	MFAToken    string
+	AccessToken string
+	ExpiresAt   *time.Time
+}
+
+// Lockout is the brute-force protection state of an account.
+type Lockout struct {
+	FailedAttempts int        `json:"failed_attempts"`
+	LockedUntil    *time.Time `json:"locked_until"`
 }
 
 type User struct {
-	ID        uuid.UUID
-	Login     string
-	Password  string
-	Name      string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID              uuid.UUID
+	Login           string
+	Password        string
+	Name            string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	FailedAttempts  int
+	LockedUntil     *time.Time
+	Email           string
+	EmailVerifiedAt *time.Time
+	Version         int64
+}
+
+// TOTP is a TOTP secret of a user, the secret is encrypted.
+type TOTP struct {
+	UserID      uuid.UUID
+	Secret      []byte
+	ConfirmedAt *time.Time
+	LastStep    int64
+	CreatedAt   time.Time
+	// RecoveryCodes counts the unused recovery codes
+	RecoveryCodes int
+}
+
+type TOTPEnrollment struct {
+	URI   string `json:"uri"`
+	QRPNG []byte `json:"qr_png"`
+}
+
+// UserImport is a row of a bulk import, either Password or an imported
+// bcrypt or argon2id PasswordHash is set. Row is the position in the
+// input, counted from 1.
+type UserImport struct {
+	Row          int    `json:"-"`
+	Login        string `json:"login"`
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	Name         string `json:"name"`
+	Email        string `json:"email,omitempty"`
+}
+
+// Statuses of imported rows, valid rows are not created in dry runs.
+const (
+	ImportCreated = "created"
+	ImportValid   = "valid"
+	ImportFailed  = "failed"
+)
+
+type ImportResult struct {
+	Row    int        `json:"row"`
+	Login  string     `json:"login"`
+	Status string     `json:"status"`
+	ID     *uuid.UUID `json:"id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// UserExport is a user in a bulk export, exports can be imported back.
+type UserExport struct {
+	ID              uuid.UUID  `json:"id"`
+	Login           string     `json:"login"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	PasswordHash    string     `json:"password_hash,omitempty"`
 }
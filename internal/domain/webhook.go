@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+// Webhook delivery states. Failed deliveries stay pending until they run
+// out of attempts and become dead.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is a subscription to events. Events lists the event types sent
+// to URL, all of them when empty. Payloads are signed with Secret.
+type Webhook struct {
+	ID        string
+	URL       string
+	Events    []string
+	Secret    string
+	CreatedAt time.Time
+}
+
+type WebhookIn struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhookOut describes a subscription, Secret is only returned once on
+// creation.
+type WebhookOut struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+// WebhookDelivery is an event sent to a webhook and the outcome of the
+// last attempt. URL, Secret and Payload are filled for sending only.
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     string     `json:"webhook_id"`
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	ResponseCode  int        `json:"response_code,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+
+	URL     string `json:"-"`
+	Secret  string `json:"-"`
+	Payload []byte `json:"-"`
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+type adminHandler struct {
+	userService service.UserServiceInterface
+	logger      *zap.SugaredLogger
+}
+
+func (adminhandler *adminHandler) GetMux(mux Router) {
+	mux.HandleFunc("GET /admin/user/{login}", adminhandler.getUser)
+	mux.HandleFunc("DELETE /admin/user/{login}", adminhandler.deleteUser)
+	mux.HandleFunc("POST /admin/user/{login}/unlock", adminhandler.unlockUser)
+	mux.HandleFunc("GET /admin/user/{login}/roles", adminhandler.getRoles)
+	mux.HandleFunc("PUT /admin/user/{login}/roles", adminhandler.setRoles)
+}
+
+func (adminhandler *adminHandler) getUser(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	user, err := adminhandler.userService.GetUser(r.Context(), login)
+	if err != nil {
+		serveAuthError(w, adminhandler.logger, login, err)
+		return
+	}
+
+	serveJSON(w, user, http.StatusOK)
+}
+
+func (adminhandler *adminHandler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	if err := adminhandler.userService.DeleteUser(r.Context(), login); err != nil {
+		serveAuthError(w, adminhandler.logger, login, err)
+		return
+	}
+
+	adminhandler.logger.Infow("User deleted", "login", login)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (adminhandler *adminHandler) unlockUser(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	if err := adminhandler.userService.UnlockUser(r.Context(), login); err != nil {
+		serveAuthError(w, adminhandler.logger, login, err)
+		return
+	}
+
+	adminhandler.logger.Infow("User unlocked", "login", login)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type rolesJSON struct {
+	Roles []string `json:"roles"`
+}
+
+func (adminhandler *adminHandler) getRoles(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	roles, err := adminhandler.userService.GetRoles(r.Context(), login)
+	if err != nil {
+		serveAuthError(w, adminhandler.logger, login, err)
+		return
+	}
+
+	serveJSON(w, rolesJSON{Roles: roles}, http.StatusOK)
+}
+
+func (adminhandler *adminHandler) setRoles(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	var in rolesJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	roles, err := adminhandler.userService.SetRoles(r.Context(), login, in.Roles)
+	if err != nil {
+		serveAuthError(w, adminhandler.logger, login, err)
+		return
+	}
+
+	adminhandler.logger.Infow("Roles changed", "login", login, "roles", roles)
+	serveJSON(w, rolesJSON{Roles: roles}, http.StatusOK)
+}
+
+func NewAdminHandler(userService service.UserServiceInterface, logger *zap.Logger) HTTPHandler {
+	return &adminHandler{
+		userService,
+		logger.Named("AdminHandler").Sugar(),
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+type apiKeyHandler struct {
+	apiKeyService service.APIKeyServiceInterface
+	logger        *zap.SugaredLogger
+}
+
+func (apikeyhandler *apiKeyHandler) GetMux(mux Router) {
+	mux.HandleFunc("POST /admin/api-keys", apikeyhandler.createAPIKey)
+	mux.HandleFunc("GET /admin/api-keys", apikeyhandler.listAPIKeys)
+	mux.HandleFunc("DELETE /admin/api-keys/{id}", apikeyhandler.revokeAPIKey)
+}
+
+func (apikeyhandler *apiKeyHandler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var in domain.APIKeyIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key, err := apikeyhandler.apiKeyService.CreateAPIKey(r.Context(), &in)
+	if err != nil {
+		serveAuthError(w, apikeyhandler.logger, "", err)
+		return
+	}
+
+	apikeyhandler.logger.Infow("API key created", "id", key.ID, "scopes", key.Scopes)
+	serveJSON(w, key, http.StatusCreated)
+}
+
+func (apikeyhandler *apiKeyHandler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := apikeyhandler.apiKeyService.ListAPIKeys(r.Context())
+	if err != nil {
+		serveAuthError(w, apikeyhandler.logger, "", err)
+		return
+	}
+
+	serveJSON(w, keys, http.StatusOK)
+}
+
+func (apikeyhandler *apiKeyHandler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := apikeyhandler.apiKeyService.RevokeAPIKey(r.Context(), id); err != nil {
+		serveAuthError(w, apikeyhandler.logger, "", err)
+		return
+	}
+
+	apikeyhandler.logger.Infow("API key revoked", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func NewAPIKeyHandler(apiKeyService service.APIKeyServiceInterface, logger *zap.Logger) HTTPHandler {
+	return &apiKeyHandler{
+		apiKeyService,
+		logger.Named("APIKeyHandler").Sugar(),
+	}
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_apiKeyHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userService := service.NewUserService(repository.NewUserDB(sqlite), nil, nil, obs)
+	apiKeyService := service.NewAPIKeyService(repository.NewAPIKeyDB(sqlite), zap.NewNop())
+
+	mux := newTestMux(userService, apiKeyService, obs,
+		NewUserHandler(userService, zap.NewNop()), NewAPIKeyHandler(apiKeyService, zap.NewNop()))
+
+	do := func(header map[string]string, method, url string, body any, v any) int {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(method, url, bytes.NewReader(data))
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+
+		return w.Code
+	}
+
+	admin := map[string]string{"Authorization": "Bearer admin-secret"}
+	require.Equal(t, http.StatusCreated,
+		do(admin, http.MethodPost, "/user/", domain.UserIn{Login: "alice", Password: "secret"}, nil))
+
+	var key domain.APIKeyOut
+	require.Equal(t, http.StatusCreated, do(admin, http.MethodPost, "/admin/api-keys",
+		domain.APIKeyIn{Name: "billing", Scopes: []string{"user:read"}}, &key))
+	require.True(t, strings.HasPrefix(key.Key, "gu_"+key.ID+"_"), key.Key)
+	assert.Equal(t, []string{"user:read"}, key.Scopes)
+
+	bearer := map[string]string{"Authorization": "Bearer " + key.Key}
+
+	t.Run("validation", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(admin, http.MethodPost, "/admin/api-keys",
+			domain.APIKeyIn{Name: "none"}, nil))
+		assert.Equal(t, http.StatusBadRequest, do(admin, http.MethodPost, "/admin/api-keys",
+			domain.APIKeyIn{Name: "root", Scopes: []string{"everything"}}, nil))
+
+		expired := time.Now().Add(-time.Hour)
+		assert.Equal(t, http.StatusBadRequest, do(admin, http.MethodPost, "/admin/api-keys",
+			domain.APIKeyIn{Name: "old", Scopes: []string{"user:read"}, ExpiresAt: &expired}, nil))
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		var user domain.UserOut
+		require.Equal(t, http.StatusOK, do(bearer, http.MethodGet, "/user/alice", nil, &user))
+		assert.Equal(t, "alice", user.Login)
+		assert.Equal(t, http.StatusOK, do(map[string]string{"X-Api-Key": key.Key}, http.MethodGet, "/user/alice", nil, nil))
+
+		assert.Equal(t, http.StatusForbidden,
+			do(bearer, http.MethodPost, "/user/", domain.UserIn{Login: "bob", Password: "secret"}, nil))
+		assert.Equal(t, http.StatusForbidden, do(bearer, http.MethodGet, "/admin/api-keys", nil, nil))
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		forged := map[string]string{"X-Api-Key": "gu_" + key.ID + "_WRONG"}
+		assert.Equal(t, http.StatusUnauthorized, do(forged, http.MethodGet, "/user/alice", nil, nil))
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		var keys []domain.APIKeyOut
+		require.Equal(t, http.StatusOK, do(admin, http.MethodGet, "/admin/api-keys", nil, &keys))
+		require.Len(t, keys, 1)
+		assert.Empty(t, keys[0].Key)
+		assert.NotNil(t, keys[0].LastUsedAt)
+
+		require.Equal(t, http.StatusNoContent, do(admin, http.MethodDelete, "/admin/api-keys/"+key.ID, nil, nil))
+		assert.Equal(t, http.StatusNotFound, do(admin, http.MethodDelete, "/admin/api-keys/"+key.ID, nil, nil))
+		assert.Equal(t, http.StatusUnauthorized, do(bearer, http.MethodGet, "/user/alice", nil, nil))
+	})
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+type auditHandler struct {
+	auditService service.AuditServiceInterface
+	logger       *zap.SugaredLogger
+}
+
+func (audithandler *auditHandler) GetMux(mux Router) {
+	mux.HandleFunc("GET /admin/audit", audithandler.listAuditEvents)
+	mux.HandleFunc("GET /admin/audit/export", audithandler.exportAuditEvents)
+}
+
+func (audithandler *auditHandler) listAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r.URL.Query())
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	events, err := audithandler.auditService.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		serveAuthError(w, audithandler.logger, "", err)
+		return
+	}
+
+	serveJSON(w, events, http.StatusOK)
+}
+
+// exportAuditEvents streams every matching event as JSON Lines, limit
+// is ignored.
+func (audithandler *auditHandler) exportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r.URL.Query())
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Limit = service.MaxAuditEvents
+
+	// the first page is read before the headers are written, so errors
+	// still get a proper status
+	events, err := audithandler.auditService.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		serveAuthError(w, audithandler.logger, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+
+	for {
+		for i := range events {
+			if err := encoder.Encode(&events[i]); err != nil {
+				return
+			}
+		}
+		_ = rc.Flush()
+
+		if len(events) < filter.Limit {
+			return
+		}
+
+		filter.AfterID = events[len(events)-1].ID
+		if events, err = audithandler.auditService.ListAuditEvents(r.Context(), filter); err != nil {
+			audithandler.logger.Warnw("Error exporting audit events", "err", err)
+			return
+		}
+	}
+}
+
+// auditFilter parses the actor, action, user_id, login, request_id, since,
+// until (RFC 3339), after and limit query parameters.
+func auditFilter(query url.Values) (*domain.AuditFilter, error) {
+	filter := &domain.AuditFilter{
+		Actor:     query.Get("actor"),
+		Action:    query.Get("action"),
+		Login:     query.Get("login"),
+		RequestID: query.Get("request_id"),
+	}
+
+	if value := query.Get("user_id"); value != "" {
+		userID, err := uuid.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("user_id: %w", err)
+		}
+		filter.UserID = &userID
+	}
+
+	for name, field := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			*field = &t
+		}
+	}
+
+	if value := query.Get("after"); value != "" {
+		afterID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("after: %w", err)
+		}
+		filter.AfterID = afterID
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("limit: %w", err)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+func NewAuditHandler(auditService service.AuditServiceInterface, logger *zap.Logger) HTTPHandler {
+	return &auditHandler{
+		auditService,
+		logger.Named("AuditHandler").Sugar(),
+	}
+}
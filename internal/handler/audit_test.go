@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/audit"
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_auditHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userRepository := repository.NewUserDB(sqlite)
+	userService := service.NewUserService(userRepository, nil, nil, obs)
+	auditService := service.NewAuditService(repository.NewAuditDB(sqlite))
+
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()),
+		NewAdminHandler(userService, zap.NewNop()), NewAuditHandler(auditService, zap.NewNop()))
+
+	do := func(authorization, method, url string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(method, url, bytes.NewReader(data))
+		r = r.WithContext(audit.NewContext(r.Context(), &audit.Request{ID: "req-" + method, ClientIP: "192.0.2.1"}))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	list := func(query string) []domain.AuditEvent {
+		t.Helper()
+
+		w := do("Bearer admin-secret", http.MethodGet, "/admin/audit"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var events []domain.AuditEvent
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+
+		return events
+	}
+
+	admin := "Bearer admin-secret"
+	require.Equal(t, http.StatusCreated,
+		do(admin, http.MethodPost, "/user/", domain.UserIn{Login: "alice", Password: "secret"}).Code)
+	require.Equal(t, http.StatusOK,
+		do(admin, http.MethodPut, "/admin/user/alice/roles", rolesJSON{Roles: []string{"admin", "user"}}).Code)
+
+	alice, err := userRepository.GetUserAuth(context.Background(), "alice")
+	require.NoError(t, err)
+	require.NoError(t, userRepository.SetPassword(context.Background(), alice.ID, "new-hash", time.Now()))
+
+	t.Run("events", func(t *testing.T) {
+		events := list("")
+		require.Len(t, events, 3)
+
+		created := events[0]
+		assert.Equal(t, domain.AuditCreateUser, created.Action)
+		assert.Equal(t, string(auth.MethodAdminToken), created.Actor)
+		assert.Equal(t, alice.ID, created.UserID)
+		assert.Equal(t, "alice", created.Login)
+		assert.Equal(t, "req-POST", created.RequestID)
+		assert.Equal(t, "192.0.2.1", created.ClientIP)
+		assert.Equal(t, domain.AuditChange{After: "alice"}, created.Diff["login"])
+		assert.Equal(t, domain.AuditChange{After: "[redacted]"}, created.Diff["password"])
+
+		roles := events[1]
+		assert.Equal(t, domain.AuditSetRoles, roles.Action)
+		assert.Equal(t, []any{"admin", "user"}, roles.Diff["roles"].After)
+		assert.Len(t, roles.Diff, 1)
+
+		password := events[2]
+		assert.Equal(t, domain.AuditSetPassword, password.Action)
+		assert.Equal(t, audit.Anonymous, password.Actor)
+		assert.Empty(t, password.RequestID)
+		assert.Equal(t, domain.AuditChange{Before: "[redacted]", After: "[redacted]"}, password.Diff["password"])
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		assert.Len(t, list("?action=user.set_roles"), 1)
+		assert.Len(t, list("?request_id=req-PUT"), 1)
+		assert.Len(t, list("?actor=admin_token&user_id="+alice.ID.String()), 2)
+		assert.Len(t, list("?login=bob"), 0)
+		assert.Len(t, list("?since="+time.Now().Add(time.Hour).Format(time.RFC3339)), 0)
+
+		first := list("?limit=1")
+		require.Len(t, first, 1)
+		assert.Len(t, list("?after="+strconv.FormatInt(first[0].ID, 10)), 2)
+
+		assert.Equal(t, http.StatusBadRequest, do(admin, http.MethodGet, "/admin/audit?since=yesterday", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, do(admin, http.MethodGet, "/admin/audit?user_id=alice", nil).Code)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		w := do(admin, http.MethodGet, "/admin/audit/export?login=alice", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+		var actions []string
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var event domain.AuditEvent
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+			actions = append(actions, event.Action)
+		}
+		assert.Equal(t, []string{domain.AuditCreateUser, domain.AuditSetRoles, domain.AuditSetPassword}, actions)
+	})
+
+	t.Run("append only", func(t *testing.T) {
+		_, err := sqlite.ExecContext(context.Background(), "UPDATE audit_events SET actor = 'nobody'")
+		assert.ErrorContains(t, err, "append-only")
+		_, err = sqlite.ExecContext(context.Background(), "DELETE FROM audit_events")
+		assert.ErrorContains(t, err, "append-only")
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated,
+			do(admin, http.MethodPost, "/user/", domain.UserIn{Login: "bob", Password: "secret"}).Code)
+		assert.Equal(t, http.StatusForbidden, do(basicAuth("bob", "secret"), http.MethodGet, "/admin/audit", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, do("", http.MethodGet, "/admin/audit/export", nil).Code)
+	})
+
+	t.Run("deleted users", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, do(basicAuth("bob", "secret"), http.MethodDelete, "/admin/user/alice", nil).Code)
+		require.Equal(t, http.StatusNoContent, do(admin, http.MethodDelete, "/admin/user/alice", nil).Code)
+		assert.Equal(t, http.StatusNotFound, do(admin, http.MethodGet, "/admin/user/alice", nil).Code)
+		assert.Equal(t, http.StatusNotFound, do(admin, http.MethodDelete, "/admin/user/alice", nil).Code)
+
+		roles, err := userRepository.GetRoles(context.Background(), alice.ID)
+		require.NoError(t, err)
+		assert.Empty(t, roles, "rows of the user are removed too")
+
+		events := list("?action=user.delete")
+		require.Len(t, events, 1)
+		assert.Equal(t, alice.ID, events[0].UserID)
+		assert.Equal(t, "alice", events[0].Login)
+		assert.Equal(t, domain.AuditChange{Before: "alice"}, events[0].Diff["login"])
+		assert.Equal(t, domain.AuditChange{Before: "[redacted]"}, events[0].Diff["password"])
+		assert.NotContains(t, events[0].Diff, "email_verified_at", "unset fields are left out")
+
+		published, err := repository.NewOutboxDB(sqlite).EventsAfter(context.Background(), 0, 100)
+		require.NoError(t, err)
+		deleted := published[len(published)-1]
+		assert.Equal(t, domain.EventUserDeleted, deleted.Type)
+		assert.Equal(t, domain.EventUser{ID: alice.ID, Login: "alice", Roles: []string{"admin", "user"}}, deleted.User)
+		assert.Empty(t, deleted.Changed)
+	})
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/ratelimit"
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+// publicHandler marks routes served without credentials, see Public.
+type publicHandler struct {
+	http.HandlerFunc
+}
+
+// Public registers a route which doesn't require credentials, like signup
+// and login. Credentials sent anyway are still checked.
+func Public(h http.HandlerFunc) http.Handler {
+	return publicHandler{h}
+}
+
+// publicMethods are gRPC methods which don't require credentials.
+var publicMethods = map[string]bool{
+	user_proto.UserService_Create_FullMethodName:               true,
+	user_proto.UserService_Login_FullMethodName:                true,
+	user_proto.UserService_LoginMFA_FullMethodName:             true,
+	user_proto.UserService_EnrollTOTP_FullMethodName:           true,
+	user_proto.UserService_ConfirmTOTP_FullMethodName:          true,
+	user_proto.UserService_VerifyEmail_FullMethodName:          true,
+	user_proto.UserService_RequestPasswordReset_FullMethodName: true,
+	user_proto.UserService_ResetPassword_FullMethodName:        true,
+
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_Watch_FullMethodName: true,
+
+	grpc_reflection_v1.ServerReflection_ServerReflectionInfo_FullMethodName:      true,
+	grpc_reflection_v1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: true,
+}
+
+// Authenticator resolves credentials of requests on both transports to
+// an auth.Principal and puts it in the request context.
+//
+// The authorization header carries an access token, an API key or
+// admin_token as a bearer token, or basic credentials of a user. API keys
+// may come in the x-api-key header as well. Without credentials the common
+// name of a verified client certificate is looked up as a user login.
+type Authenticator struct {
+	userService   service.UserServiceInterface
+	apiKeyService service.APIKeyServiceInterface
+	obs           *config.Observer
+	logger        *zap.SugaredLogger
+}
+
+func NewAuthenticator(
+	userService service.UserServiceInterface,
+	apiKeyService service.APIKeyServiceInterface,
+	obs *config.Observer,
+	logger *zap.Logger,
+) *Authenticator {
+	return &Authenticator{
+		userService:   userService,
+		apiKeyService: apiKeyService,
+		obs:           obs,
+		logger:        logger.Named("Authenticator").Sugar(),
+	}
+}
+
+// Handler authenticates requests to routes of mux and passes them to next.
+// Routes not registered with Public answer 401 without a principal.
+func (a *Authenticator) Handler(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cert *x509.Certificate
+		if r.TLS != nil {
+			cert = clientCert(r.TLS)
+		}
+
+		ctx, err := a.authenticate(r.Context(), r.Header.Get("Authorization"), r.Header.Get("X-Api-Key"), cert)
+		if err != nil {
+			serveAuthError(w, a.logger, "", err)
+			return
+		}
+
+		h, pattern := mux.Handler(r)
+		if _, ok := auth.FromContext(ctx); !ok && pattern != "" {
+			if _, ok := h.(publicHandler); !ok {
+				serveAuthError(w, a.logger, "", service.ErrUnauthenticated)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *Authenticator) UnaryInterceptor(
+	ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, err := a.authenticateGRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *Authenticator) StreamInterceptor(
+	srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	ctx, err := a.authenticateGRPC(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream replaces the context of a stream.
+type authenticatedStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (a *Authenticator) authenticateGRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	var cert *x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			cert = clientCert(&info.State)
+		}
+	}
+
+	ctx, err := a.authenticate(ctx, first("authorization"), first("x-api-key"), cert)
+	if err != nil {
+		return ctx, grpcAuthError(ctx, a.logger, "", err)
+	}
+
+	if _, ok := auth.FromContext(ctx); !ok && !publicMethods[fullMethod] {
+		return ctx, status.Error(codes.Unauthenticated, service.ErrUnauthenticated.Error())
+	}
+
+	return ctx, nil
+}
+
+// authenticate puts the principal of the credentials in ctx. Requests
+// without credentials are anonymous, wrong credentials are an error.
+func (a *Authenticator) authenticate(
+	ctx context.Context, authorization, apiKey string, cert *x509.Certificate,
+) (context.Context, error) {
+	principal, err := a.principal(ctx, authorization, apiKey, cert)
+	if err != nil || principal == nil {
+		return ctx, err
+	}
+
+	ctx = auth.NewContext(ctx, principal)
+
+	return ratelimit.WithUser(ctx, principal.Subject()), nil
+}
+
+func (a *Authenticator) principal(
+	ctx context.Context, authorization, apiKey string, cert *x509.Certificate,
+) (*auth.Principal, error) {
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok && service.IsAPIKey(token) {
+		if apiKey != "" {
+			return nil, service.ErrInvalidAPIKey
+		}
+		apiKey, authorization = token, ""
+	}
+
+	if apiKey != "" {
+		if authorization != "" || a.apiKeyService == nil {
+			return nil, service.ErrInvalidAPIKey
+		}
+
+		return a.apiKeyService.Authenticate(ctx, apiKey)
+	}
+
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		adminToken := a.obs.Current().AdminToken
+		if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+			return &auth.Principal{Method: auth.MethodAdminToken, Roles: []string{service.RoleAdmin}}, nil
+		}
+
+		return a.userService.AuthenticateToken(ctx, token)
+	}
+
+	if authorization != "" {
+		credentials, ok := basicCredentials(authorization)
+		if !ok {
+			return nil, service.ErrInvalidCredentials
+		}
+
+		return a.userService.Authenticate(ctx, credentials)
+	}
+
+	if cert == nil || cert.Subject.CommonName == "" {
+		return nil, nil
+	}
+
+	principal, err := a.userService.AuthenticateClientCert(ctx, cert.Subject.CommonName)
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		// certificates of clients which are not users are fine, the
+		// request goes on as anonymous
+		return nil, nil
+	}
+
+	return principal, err
+}
+
+// clientCert returns the leaf of the verified client certificate chain.
+func clientCert(state *tls.ConnectionState) *x509.Certificate {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
+
+func basicCredentials(authorization string) (*domain.Credentials, bool) {
+	encoded, ok := strings.CutPrefix(authorization, "Basic ")
+	if !ok {
+		return nil, false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	login, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, false
+	}
+
+	return &domain.Credentials{Login: login, Password: password}, true
+}
@@ -0,0 +1,375 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+var (
+	errUnsupportedFormat = errors.New("unsupported format, use text/csv or application/x-ndjson")
+	errNoLoginColumn     = errors.New("csv header has no login column")
+)
+
+// maxImportLine limits the length of a JSON Lines row.
+const maxImportLine = 1 << 20
+
+// exportColumns is the CSV header of exports, the login, name, email and
+// password_hash columns are read back by imports.
+var exportColumns = []string{
+	"id", "login", "name", "email", "email_verified_at", "created_at", "updated_at", "password_hash",
+}
+
+type bulkHandler struct {
+	bulkService service.BulkServiceInterface
+	logger      *zap.SugaredLogger
+}
+
+func (bulkhandler *bulkHandler) GetMux(mux Router) {
+	mux.HandleFunc("POST /users:import", bulkhandler.importUsers)
+	mux.HandleFunc("GET /users:export", bulkhandler.exportUsers)
+}
+
+// importUsers creates users from a CSV or JSON Lines body in batches and
+// streams a JSON Lines result for every row. Batches are created in a
+// transaction each, dry_run only validates.
+func (bulkhandler *bulkHandler) importUsers(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := queryBool(r, "dry_run")
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var rows rowReader
+	switch mediaType {
+	case "text/csv":
+		rows, err = newCSVRows(r.Body)
+	case "application/x-ndjson", "application/jsonl":
+		rows = newJSONRows(r.Body)
+	default:
+		serveErrorJSON(w, http.StatusUnsupportedMediaType, errUnsupportedFormat)
+		return
+	}
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := bulkhandler.bulkService.ImportUsers(r.Context(), nil, dryRun); err != nil {
+		serveAuthError(w, bulkhandler.logger, "", err)
+		return
+	}
+
+	// large imports outlive read_timeout and write_timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	batch := make([]domain.UserImport, 0, service.ImportBatchSize)
+	var failed []domain.ImportResult
+
+	flush := func() bool {
+		var (
+			results []domain.ImportResult
+			err     error
+		)
+		if len(batch) > 0 {
+			results, err = bulkhandler.bulkService.ImportUsers(r.Context(), batch, dryRun)
+		}
+		if err != nil {
+			bulkhandler.logger.Errorw("Error importing users", "err", err)
+			for _, row := range batch {
+				failed = append(failed, domain.ImportResult{
+					Row: row.Row, Login: row.Login, Status: domain.ImportFailed, Error: errInternal.Error(),
+				})
+			}
+		}
+
+		results = append(results, failed...)
+		slices.SortFunc(results, func(a, b domain.ImportResult) int { return a.Row - b.Row })
+		for i := range results {
+			if encoder.Encode(&results[i]) != nil {
+				return false
+			}
+		}
+		_ = rc.Flush()
+
+		batch, failed = batch[:0], failed[:0]
+
+		return err == nil
+	}
+
+	for {
+		row, err := rows.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var rowErr *importRowError
+		switch {
+		case errors.As(err, &rowErr):
+			failed = append(failed, domain.ImportResult{Row: rowErr.row, Status: domain.ImportFailed, Error: rowErr.Error()})
+		case err != nil:
+			bulkhandler.logger.Infow("Error reading import", "err", err)
+			return
+		default:
+			batch = append(batch, *row)
+		}
+
+		if len(batch) == service.ImportBatchSize && !flush() {
+			return
+		}
+	}
+
+	flush()
+}
+
+// exportUsers streams every user as JSON Lines or CSV (format=csv),
+// password hashes are included with password_hashes=true.
+func (bulkhandler *bulkHandler) exportUsers(w http.ResponseWriter, r *http.Request) {
+	passwordHashes, err := queryBool(r, "password_hashes")
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "ndjson" {
+		serveErrorJSON(w, http.StatusBadRequest, errUnsupportedFormat)
+		return
+	}
+
+	// the first page is read before the headers are written, so errors
+	// still get a proper status
+	users, err := bulkhandler.bulkService.ExportUsers(r.Context(), "", service.MaxExportUsers, passwordHashes)
+	if err != nil {
+		serveAuthError(w, bulkhandler.logger, "", err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	write := writeJSONUsers(w)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		write = writeCSVUsers(w)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.jsonl"`)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		if err := write(users); err != nil {
+			return
+		}
+		_ = rc.Flush()
+
+		if len(users) < service.MaxExportUsers {
+			return
+		}
+
+		after := users[len(users)-1].Login
+		if users, err = bulkhandler.bulkService.ExportUsers(r.Context(), after, service.MaxExportUsers, passwordHashes); err != nil {
+			bulkhandler.logger.Warnw("Error exporting users", "err", err)
+			return
+		}
+	}
+}
+
+func writeJSONUsers(w io.Writer) func([]domain.UserExport) error {
+	encoder := json.NewEncoder(w)
+
+	return func(users []domain.UserExport) error {
+		for i := range users {
+			if err := encoder.Encode(&users[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func writeCSVUsers(w io.Writer) func([]domain.UserExport) error {
+	writer := csv.NewWriter(w)
+	header := true
+
+	return func(users []domain.UserExport) error {
+		if header {
+			_ = writer.Write(exportColumns)
+			header = false
+		}
+
+		for _, user := range users {
+			var verifiedAt string
+			if user.EmailVerifiedAt != nil {
+				verifiedAt = user.EmailVerifiedAt.Format(time.RFC3339)
+			}
+			_ = writer.Write([]string{
+				user.ID.String(), user.Login, user.Name, user.Email, verifiedAt,
+				user.CreatedAt.Format(time.RFC3339), user.UpdatedAt.Format(time.RFC3339), user.PasswordHash,
+			})
+		}
+		writer.Flush()
+
+		return writer.Error()
+	}
+}
+
+func queryBool(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// rowReader reads rows of an import, rows which can't be parsed are
+// reported with an *importRowError and reading goes on. io.EOF ends the
+// import.
+type rowReader interface {
+	Read() (*domain.UserImport, error)
+}
+
+type importRowError struct {
+	row int
+	err error
+}
+
+func (e *importRowError) Error() string {
+	return e.err.Error()
+}
+
+type csvRows struct {
+	reader  *csv.Reader
+	columns map[string]int
+	row     int
+}
+
+// newCSVRows reads the header, columns other than login, name, email,
+// password and password_hash are ignored.
+func newCSVRows(body io.Reader) (*csvRows, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["login"]; !ok {
+		return nil, errNoLoginColumn
+	}
+
+	return &csvRows{reader: reader, columns: columns}, nil
+}
+
+func (c *csvRows) Read() (*domain.UserImport, error) {
+	record, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	c.row++
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, &importRowError{row: c.row, err: parseErr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	field := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	return &domain.UserImport{
+		Row:          c.row,
+		Login:        field("login"),
+		Password:     field("password"),
+		PasswordHash: field("password_hash"),
+		Name:         field("name"),
+		Email:        field("email"),
+	}, nil
+}
+
+type jsonRows struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func newJSONRows(body io.Reader) *jsonRows {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, maxImportLine)
+
+	return &jsonRows{scanner: scanner}
+}
+
+// Read skips blank lines, rows are numbered by line.
+func (j *jsonRows) Read() (*domain.UserImport, error) {
+	for j.scanner.Scan() {
+		j.row++
+
+		line := j.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var row domain.UserImport
+		if err := json.Unmarshal(line, &row); err != nil {
+			return nil, &importRowError{row: j.row, err: err}
+		}
+		row.Row = j.row
+
+		return &row, nil
+	}
+
+	if err := j.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+func NewBulkHandler(bulkService service.BulkServiceInterface, logger *zap.Logger) HTTPHandler {
+	return &bulkHandler{
+		bulkService,
+		logger.Named("BulkHandler").Sugar(),
+	}
+}
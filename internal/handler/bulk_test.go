@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_bulkHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := newTestObserver(t)
+	users := repository.NewUserDB(sqlite)
+
+	userService := service.NewUserService(users, nil, nil, obs)
+	mux := newTestMux(userService, nil, obs,
+		NewUserHandler(userService, zap.NewNop()), NewBulkHandler(service.NewBulkService(users), zap.NewNop()))
+
+	do := func(authorization, method, url, contentType, body string) (int, string) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Authorization", authorization)
+		r.Header.Set("Content-Type", contentType)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w.Code, w.Body.String()
+	}
+
+	results := func(body string) []domain.ImportResult {
+		t.Helper()
+
+		var results []domain.ImportResult
+		lines := bufio.NewScanner(strings.NewReader(body))
+		for lines.Scan() {
+			var result domain.ImportResult
+			require.NoError(t, json.Unmarshal(lines.Bytes(), &result), lines.Text())
+			results = append(results, result)
+		}
+
+		return results
+	}
+
+	statuses := func(results []domain.ImportResult) []string {
+		var statuses []string
+		for _, result := range results {
+			statuses = append(statuses, fmt.Sprintf("%d %s %s", result.Row, result.Status, result.Error))
+		}
+		return statuses
+	}
+
+	admin := "Bearer admin-secret"
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	salt := []byte("0123456789abcdef")
+	argon2Hash := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("argon2-secret"), salt, 1, 1024, 1, 32)))
+
+	input := "login,name,email,password,password_hash,ignored\n" +
+		"alice,Alice,alice@example.com,alice-secret,,x\n" +
+		"bob,Bob,,,\"" + string(bcryptHash) + "\",x\n" +
+		"carol,Carol,,,\"" + argon2Hash + "\",x\n" +
+		"alice,Alice again,,secret,,x\n" +
+		"dave,Dave,not-an-email,secret,,x\n" +
+		"erin,Erin,,,$2a$broken,x\n" +
+		"eve,Eve,,,\"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5\",x\n" +
+		"frank,Frank,,,,x\n" +
+		"grace,\"Grace,secret\n"
+
+	t.Run("authorization", func(t *testing.T) {
+		code, _ := do("", http.MethodPost, "/users:import", "text/csv", input)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = do(admin, http.MethodPost, "/users:import", "application/json", "{}")
+		assert.Equal(t, http.StatusUnsupportedMediaType, code)
+		code, _ = do(admin, http.MethodPost, "/users:import", "text/csv", "name,email\n")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	want := []string{
+		"1 %s ",
+		"2 %s ",
+		"3 %s ",
+		"4 failed user with login already exists",
+		"5 failed invalid email address",
+		"6 failed password_hash must be a bcrypt or argon2id hash",
+		"7 failed password_hash must be a bcrypt or argon2id hash",
+		"8 failed password must not be empty",
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		code, body := do(admin, http.MethodPost, "/users:import?dry_run=true", "text/csv", input)
+		require.Equal(t, http.StatusOK, code, body)
+
+		got := statuses(results(body))
+		require.Len(t, got, len(want)+1, body)
+		for i := range want {
+			assert.Equal(t, strings.Replace(want[i], "%s", domain.ImportValid, 1), got[i])
+		}
+		assert.True(t, strings.HasPrefix(got[8], "9 failed"), got[8])
+
+		_, err := users.GetUser(t.Context(), "alice")
+		assert.ErrorIs(t, err, repository.ErrUserNotFound)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		code, body := do(admin, http.MethodPost, "/users:import", "text/csv", input)
+		require.Equal(t, http.StatusOK, code, body)
+
+		imported := results(body)
+		for i := range want {
+			assert.Equal(t, strings.Replace(want[i], "%s", domain.ImportCreated, 1), statuses(imported)[i])
+		}
+		assert.NotNil(t, imported[0].ID)
+
+		for login, password := range map[string]string{"alice": "alice-secret", "bob": "bcrypt-secret", "carol": "argon2-secret"} {
+			code, _ := do(basicAuth(login, password), http.MethodGet, "/user/"+login, "", "")
+			assert.Equal(t, http.StatusOK, code, login)
+		}
+		code, _ = do(basicAuth("carol", "wrong"), http.MethodGet, "/user/carol", "", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("json lines", func(t *testing.T) {
+		input := `{"login": "heidi", "name": "Heidi", "password": "secret"}` + "\n\n" +
+			`{"login": "ivan", "name": "Ivan", "email": "ALICE@example.com", "password": "secret"}` + "\n" +
+			`{"login": "judy"` + "\n"
+
+		code, body := do(admin, http.MethodPost, "/users:import", "application/x-ndjson", input)
+		require.Equal(t, http.StatusOK, code, body)
+
+		got := statuses(results(body))
+		require.Len(t, got, 3, body)
+		assert.Equal(t, "1 created ", got[0])
+		assert.Equal(t, "3 failed user with email already exists", got[1])
+		assert.True(t, strings.HasPrefix(got[2], "4 failed"), got[2])
+	})
+
+	t.Run("export", func(t *testing.T) {
+		code, _ := do(basicAuth("alice", "alice-secret"), http.MethodGet, "/users:export", "", "")
+		assert.Equal(t, http.StatusForbidden, code)
+
+		code, body := do(admin, http.MethodGet, "/users:export", "", "")
+		require.Equal(t, http.StatusOK, code)
+
+		var exported []domain.UserExport
+		lines := bufio.NewScanner(strings.NewReader(body))
+		for lines.Scan() {
+			var user domain.UserExport
+			require.NoError(t, json.Unmarshal(lines.Bytes(), &user))
+			exported = append(exported, user)
+		}
+		require.Len(t, exported, 4)
+		assert.Equal(t, "alice", exported[0].Login)
+		assert.Equal(t, "alice@example.com", exported[0].Email)
+		assert.Empty(t, exported[0].PasswordHash)
+
+		code, body = do(admin, http.MethodGet, "/users:export?format=csv&password_hashes=true", "", "")
+		require.Equal(t, http.StatusOK, code)
+
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, "bob", records[2][1])
+		assert.Equal(t, string(bcryptHash), records[2][7])
+		assert.Equal(t, argon2Hash, records[3][7])
+	})
+
+	t.Run("export imports back", func(t *testing.T) {
+		code, export := do(admin, http.MethodGet, "/users:export?format=csv&password_hashes=true", "", "")
+		require.Equal(t, http.StatusOK, code)
+
+		code, body := do(admin, http.MethodPost, "/users:import?dry_run=true", "text/csv", export)
+		require.Equal(t, http.StatusOK, code)
+		for _, result := range results(body) {
+			assert.Equal(t, service.ErrUserAlreadyExists.Error(), result.Error, result.Login)
+		}
+	})
+
+	t.Run("internal errors", func(t *testing.T) {
+		require.NoError(t, sqlite.(io.Closer).Close())
+
+		code, body := do(admin, http.MethodPost, "/users:import", "application/x-ndjson",
+			`{"login": "mallory", "name": "Mallory", "password": "secret"}`+"\n")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{"1 failed internal error"}, statuses(results(body)), "database errors are not sent")
+	})
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// docsFS holds the OpenAPI document of the HTTP routes and the API explorer.
+//
+//go:embed docs
+var docsFS embed.FS
+
+type docsHandler struct {
+	spec   []byte
+	ui     http.Handler
+	logger *zap.SugaredLogger
+}
+
+func (docshandler *docsHandler) GetMux(mux Router) {
+	mux.Handle("GET /openapi.json", Public(docshandler.getSpec))
+	mux.Handle("GET /docs/", Public(docshandler.ui.ServeHTTP))
+	mux.Handle("GET /docs", Public(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/", http.StatusMovedPermanently)
+	}))
+}
+
+func (docshandler *docsHandler) getSpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", mediaTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(docshandler.spec)
+}
+
+func NewDocsHandler(logger *zap.Logger) (HTTPHandler, error) {
+	spec, err := openAPISpec()
+	if err != nil {
+		return nil, err
+	}
+
+	ui, err := fs.Sub(docsFS, "docs/ui")
+	if err != nil {
+		return nil, err
+	}
+
+	return &docsHandler{
+		spec,
+		http.StripPrefix("/docs/", http.FileServerFS(ui)),
+		logger.Named("DocsHandler").Sugar(),
+	}, nil
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+// routeRecorder is a Router which records the registered patterns.
+type routeRecorder []string
+
+func (r *routeRecorder) Handle(pattern string, _ http.Handler) {
+	*r = append(*r, pattern)
+}
+
+func (r *routeRecorder) HandleFunc(pattern string, _ func(http.ResponseWriter, *http.Request)) {
+	*r = append(*r, pattern)
+}
+
+// allHTTPHandlers returns every HTTP handler the application registers,
+// built from HTTPRoutes like in the application. Services are nil, routes
+// are registered without them.
+func allHTTPHandlers(t *testing.T) []HTTPHandler {
+	t.Helper()
+
+	var handlers []HTTPHandler
+	fxtest.New(t,
+		fx.NopLogger,
+		HTTPRoutes,
+		fx.Provide(
+			zap.NewNop,
+			func() *config.Observer { return nil },
+			func() service.UserServiceInterface { return nil },
+			func() service.PasskeyServiceInterface { return nil },
+			func() service.APIKeyServiceInterface { return nil },
+			func() service.AuditServiceInterface { return nil },
+			func() service.WebhookServiceInterface { return nil },
+			func() service.BulkServiceInterface { return nil },
+			func() service.WatchServiceInterface { return nil },
+		),
+		fx.Invoke(fx.Annotate(
+			func(registered []HTTPHandler) { handlers = registered },
+			fx.ParamTags(`group:"http_routes"`),
+		)),
+	).RequireStart().RequireStop()
+	require.NotEmpty(t, handlers)
+
+	return handlers
+}
+
+func Test_openAPISpec(t *testing.T) {
+	data, err := openAPISpec()
+	require.NoError(t, err)
+
+	var spec struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(data, &spec))
+	assert.Equal(t, "3.1.0", spec.OpenAPI)
+
+	// patterns without a method accept any, they need an operation of some
+	// method in the spec
+	registered := make(map[string]bool)
+	for _, handler := range allHTTPHandlers(t) {
+		var routes routeRecorder
+		handler.GetMux(&routes)
+
+		for _, route := range routes {
+			method, path, ok := strings.Cut(route, " ")
+			if !ok {
+				method, path = "", route
+			}
+			registered[method+" "+path] = true
+
+			item, ok := spec.Paths[path]
+			if !assert.True(t, ok, "route %q missing from the OpenAPI document", route) {
+				continue
+			}
+			if method == "" {
+				assert.NotEmpty(t, operations(item), "route %q has no operation", route)
+			} else {
+				assert.Contains(t, item, strings.ToLower(method), "route %q missing from the OpenAPI document", route)
+			}
+		}
+	}
+
+	for path, item := range spec.Paths {
+		for _, method := range operations(item) {
+			assert.True(t, registered[strings.ToUpper(method)+" "+path] || registered[" "+path],
+				"%s %s is documented but not registered", strings.ToUpper(method), path)
+		}
+	}
+}
+
+// operations returns the methods of the operations of a path item.
+func operations(item map[string]any) []string {
+	var methods []string
+	for key := range item {
+		switch key {
+		case "get", "put", "post", "delete", "patch", "head", "options", "trace":
+			methods = append(methods, key)
+		}
+	}
+
+	return methods
+}
+
+func Test_docsHandler(t *testing.T) {
+	docs, err := NewDocsHandler(zap.NewNop())
+	require.NoError(t, err)
+	mux := newTestMux(nil, nil, nil, docs)
+
+	r := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(w.Body.Bytes()))
+
+	r = httptest.NewRequest(http.MethodGet, "/docs/", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "/openapi.json")
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// etag is the strong entity tag of the user version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// representationETag is the strong entity tag of a representation of the
+// user version: the version followed by a hash of the body. The body holds
+// what the caller may see in the media type and fields asked for, so fields
+// which don't change the version, like roles or the lockout, change the tag
+// too.
+func representationETag(version int64, body []byte) string {
+	hash := fnv.New64a()
+	_, _ = hash.Write(body)
+
+	return fmt.Sprintf(`"%d-%016x"`, version, hash.Sum64())
+}
+
+// parseETag returns the version of a single strong entity tag of any
+// representation.
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	number, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+	version, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// etagMatch reports whether If-None-Match lists the entity tag, tags are
+// compared weakly.
+func etagMatch(header string, tag string) bool {
+	if header == "" {
+		return false
+	}
+
+	for listed := range strings.SplitSeq(header, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == "*" || strings.TrimPrefix(listed, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatch evaluates If-Match with the strong comparison of RFC 9110: "*"
+// matches any version, a list matches when one of its tags does. Tags of
+// all representations of the version match, an update changes them all.
+func ifMatch(header string, version int64) bool {
+	for tag := range strings.SplitSeq(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if v, ok := parseETag(tag); ok && v == version {
+			return true
+		}
+	}
+
+	return false
+}
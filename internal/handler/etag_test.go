@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_userHandler_etag(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{
+		AdminToken: "admin-secret",
+		Lockout:    config.LockoutConfig{MaxAttempts: 5, Duration: time.Hour, Delay: time.Second, MaxDelay: time.Minute},
+	}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	users := repository.NewUserDB(sqlite)
+	userService := service.NewUserService(users, nil, nil, obs)
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+	do := func(method, header, value, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(method, "/user/alice", strings.NewReader(body))
+		if method == http.MethodPost {
+			r = httptest.NewRequest(method, "/user/", strings.NewReader(body))
+		}
+		r.Header.Set("Authorization", "Bearer admin-secret")
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := do(http.MethodPost, "", "", `{"login": "alice", "name": "Alice", "password": "secret"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// version returns the version of the entity tag after checking it is
+	// the tag of the body
+	version := func(w *httptest.ResponseRecorder) int64 {
+		t.Helper()
+
+		tag := w.Header().Get("ETag")
+		v, ok := parseETag(tag)
+		require.True(t, ok, tag)
+		assert.Equal(t, representationETag(v, w.Body.Bytes()), tag)
+
+		return v
+	}
+
+	w = do(http.MethodGet, "", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1), version(w))
+	tag := w.Header().Get("ETag")
+
+	t.Run("not modified", func(t *testing.T) {
+		w := do(http.MethodGet, "If-None-Match", `"7", W/`+tag, "")
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, tag, w.Header().Get("ETag"))
+
+		w = do(http.MethodGet, "If-None-Match", `"1"`, "")
+		assert.Equal(t, http.StatusOK, w.Code, "the version alone isn't the tag")
+	})
+
+	t.Run("update needs If-Match", func(t *testing.T) {
+		w := do(http.MethodPatch, "", "", `{"name": "Alicia"}`)
+		assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+		w = do(http.MethodPatch, "If-Match", `W/"1"`, `{"name": "Alicia"}`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "weak tags don't match")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		w := do(http.MethodPatch, "If-Match", tag, `{"name": "Alicia"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int64(2), version(w))
+		tag := w.Header().Get("ETag")
+
+		var user domain.UserOut
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+		assert.Equal(t, "Alicia", user.Name)
+		assert.Equal(t, int64(2), user.Version)
+
+		w = do(http.MethodPatch, "If-Match", `"1"`, `{"name": "Alice"}`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "lost update")
+
+		w = do(http.MethodPatch, "If-Match", `"2"`, `{"name": "Alicia"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, tag, w.Header().Get("ETag"), "nothing changed")
+	})
+
+	t.Run("If-Match lists", func(t *testing.T) {
+		w := do(http.MethodPatch, "If-Match", `"1", "7"`, `{"name": "Alice"}`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		w = do(http.MethodPatch, "If-Match", `"1", "2"`, `{"name": "Alice"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int64(3), version(w))
+
+		w = do(http.MethodPatch, "If-Match", `*`, `{"name": "Alicia"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int64(4), version(w))
+	})
+
+	t.Run("email taken meanwhile", func(t *testing.T) {
+		w := do(http.MethodPost, "", "", `{"login": "bob", "name": "Bob", "password": "secret", "email": "bob@example.com"}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		// the service checks the email first, the constraint catches races
+		alice, err := users.GetUserAuth(t.Context(), "alice")
+		require.NoError(t, err)
+		alice.Email = "bob@example.com"
+		assert.ErrorIs(t, users.UpdateUser(t.Context(), alice, alice.Version), repository.ErrUserEmailExists)
+	})
+
+	t.Run("failed logins change the tag", func(t *testing.T) {
+		w := do(http.MethodGet, "", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		tag := w.Header().Get("ETag")
+
+		r := httptest.NewRequest(http.MethodGet, "/user/alice", nil)
+		r.Header.Set("Authorization", basicAuth("alice", "wrong"))
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// the lockout shown to admins changed, the version didn't
+		w = do(http.MethodGet, "If-None-Match", tag, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, tag, w.Header().Get("ETag"))
+		assert.Equal(t, int64(4), version(w))
+	})
+
+	t.Run("updates keep a verification done meanwhile", func(t *testing.T) {
+		stale, err := users.GetUserAuth(t.Context(), "bob")
+		require.NoError(t, err)
+		require.NoError(t, users.SetEmailVerified(t.Context(), stale.ID, time.Now().UTC()))
+
+		stale.Name = "Bobby"
+		require.NoError(t, users.UpdateUser(t.Context(), stale, stale.Version))
+
+		bob, err := users.GetUserAuth(t.Context(), "bob")
+		require.NoError(t, err)
+		assert.Equal(t, "Bobby", bob.Name)
+		assert.NotNil(t, bob.EmailVerifiedAt)
+
+		bob.Email = "robert@example.com"
+		require.NoError(t, users.UpdateUser(t.Context(), bob, bob.Version))
+
+		bob, err = users.GetUserAuth(t.Context(), "bob")
+		require.NoError(t, err)
+		assert.Nil(t, bob.EmailVerifiedAt, "a new email is verified again")
+	})
+}
+
+func Test_ifMatch(t *testing.T) {
+	assert.True(t, ifMatch(`*`, 3))
+	assert.True(t, ifMatch(`"1", "3"`, 3))
+	assert.False(t, ifMatch(`W/"3"`, 3))
+	assert.False(t, ifMatch(`"4"`, 3))
+	assert.True(t, ifMatch(`"3-protobuf"`, 3))
+}
+
+func Test_etagMatch(t *testing.T) {
+	assert.True(t, etagMatch(`*`, `"3"`))
+	assert.True(t, etagMatch(`"1", "3"`, `"3"`))
+	assert.True(t, etagMatch(`W/"3"`, `"3"`))
+	assert.False(t, etagMatch(``, `"3"`))
+	assert.False(t, etagMatch(`"4"`, `"3"`))
+	assert.False(t, etagMatch(`3`, `"3"`))
+	assert.False(t, etagMatch(`"3"`, `"3-protobuf"`))
+}
+
+func Test_representationETag(t *testing.T) {
+	tag := representationETag(3, []byte(`{"login":"alice"}`))
+	assert.Regexp(t, `^"3-[0-9a-f]{16}"$`, tag)
+	assert.Equal(t, tag, representationETag(3, []byte(`{"login":"alice"}`)))
+	assert.NotEqual(t, tag, representationETag(3, []byte(`{"login":"alice","roles":["admin"]}`)))
+	assert.NotEqual(t, tag, representationETag(4, []byte(`{"login":"alice"}`)))
+
+	version, ok := parseETag(tag)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), version)
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+)
+
+var errETagField = errors.New("fields: etag is sent in the ETag header")
+
+// readMask returns the paths of the fields query parameter, comma separated
+// or repeated. They are the field names of the gRPC user message, so both
+// APIs select fields the same way.
+func readMask(r *http.Request) ([]string, error) {
+	var paths []string
+	for _, fields := range r.URL.Query()["fields"] {
+		for path := range strings.SplitSeq(fields, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	if _, err := fieldmaskpb.New(&user_proto.GetUserResponse{}, paths...); err != nil {
+		return nil, fmt.Errorf("fields: %w", err)
+	}
+	if slices.Contains(paths, "etag") {
+		// the tag of the representation is only known after masking
+		return nil, errETagField
+	}
+
+	return paths, nil
+}
+
+// maskTree groups paths by their first name, a nil slice selects the whole
+// field.
+func maskTree(paths []string) map[string][]string {
+	tree := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		head, rest, nested := strings.Cut(path, ".")
+		sub, seen := tree[head]
+		switch {
+		case !nested:
+			tree[head] = nil
+		case !seen || sub != nil:
+			tree[head] = append(sub, rest)
+		}
+	}
+
+	return tree
+}
+
+// maskMessage clears the fields of m which are not selected by paths,
+// nothing is cleared without paths.
+func maskMessage(m protoreflect.Message, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	tree := maskTree(paths)
+
+	var clear []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		sub, ok := tree[string(fd.Name())]
+		switch {
+		case !ok:
+			clear = append(clear, fd)
+		case sub != nil && fd.Message() != nil && fd.Cardinality() != protoreflect.Repeated:
+			maskMessage(v.Message(), sub)
+		}
+		return true
+	})
+
+	for _, fd := range clear {
+		m.Clear(fd)
+	}
+}
+
+// maskMap deletes the keys of m which are not selected by paths, nothing is
+// deleted without paths.
+func maskMap(m map[string]any, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	tree := maskTree(paths)
+	for key, value := range m {
+		sub, ok := tree[key]
+		if !ok {
+			delete(m, key)
+			continue
+		}
+		if nested, isMap := value.(map[string]any); isMap && sub != nil {
+			maskMap(nested, sub)
+		}
+	}
+}
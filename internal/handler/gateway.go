@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+)
+
+// gatewayHandler serves the google.api.http routes of user.proto by calling
+// the gRPC handler in process. The requests pass the HTTP middleware, so
+// they are authenticated and rate limited like the other routes.
+type gatewayHandler struct {
+	mux    *runtime.ServeMux
+	logger *zap.SugaredLogger
+}
+
+// GetMux registers every annotated method as a route of its own, public
+// methods with Public.
+func (g *gatewayHandler) GetMux(mux Router) {
+	for method, route := range gatewayRoutes(user_proto.File_user_v1_user_proto.Services().ByName("UserService")) {
+		if publicMethods[method] {
+			mux.Handle(route, Public(g.ServeHTTP))
+		} else {
+			mux.Handle(route, g)
+		}
+	}
+}
+
+func (g *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	g.mux.ServeHTTP(w, r)
+}
+
+// gatewayRoutes returns the mux patterns of the annotated methods of sd
+// keyed by the full method name.
+func gatewayRoutes(sd protoreflect.ServiceDescriptor) map[string]string {
+	routes := make(map[string]string)
+
+	methods := sd.Methods()
+	for i := range methods.Len() {
+		md := methods.Get(i)
+		if method, path, _, ok := httpRule(md); ok {
+			routes["/"+string(sd.FullName())+"/"+string(md.Name())] = method + " " + path
+		}
+	}
+
+	return routes
+}
+
+// httpRule returns the HTTP method, path template and body of the
+// google.api.http annotation of md.
+func httpRule(md protoreflect.MethodDescriptor) (method, path, body string, ok bool) {
+	rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return "", "", "", false
+	}
+
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, pattern.Get, rule.GetBody(), true
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, pattern.Put, rule.GetBody(), true
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, pattern.Post, rule.GetBody(), true
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, pattern.Delete, rule.GetBody(), true
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, pattern.Patch, rule.GetBody(), true
+	default:
+		return "", "", "", false
+	}
+}
+
+// serveError writes errors of the gateway like the errors of the other
+// routes.
+func (g *gatewayHandler) serveError(
+	ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error,
+) {
+	st := status.Convert(err)
+	code := runtime.HTTPStatusFromCode(st.Code())
+	if code >= http.StatusInternalServerError {
+		g.logger.Warnw("Error serving request", "path", r.URL.Path, "err", err)
+	}
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		if values := md.HeaderMD.Get("retry-after"); len(values) > 0 {
+			w.Header().Set("Retry-After", values[0])
+		}
+	}
+
+	serveErrorJSON(w, code, errors.New(st.Message()))
+}
+
+func NewGatewayHandler(grpcHandlers []GRPCHandler, logger *zap.Logger) (HTTPHandler, error) {
+	g := &gatewayHandler{logger: logger.Named("GatewayHandler").Sugar()}
+
+	// fields are named like in the proto files and the JSON of the other
+	// routes, unknown fields are refused
+	g.mux = runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{UseProtoNames: true},
+		}),
+		runtime.WithErrorHandler(g.serveError),
+	)
+
+	for _, grpcHandler := range grpcHandlers {
+		if server, ok := grpcHandler.(user_proto.UserServiceServer); ok {
+			if err := user_proto.RegisterUserServiceHandlerServer(context.Background(), g.mux, server); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return g, nil
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_gatewayRoutes(t *testing.T) {
+	sd := user_proto.File_user_v1_user_proto.Services().ByName("UserService")
+	routes := gatewayRoutes(sd)
+
+	assert.Equal(t, "GET /v1/users/{login}", routes[user_proto.UserService_GetByLogin_FullMethodName])
+
+	// streaming methods can't be called in process
+	methods := sd.Methods()
+	for i := range methods.Len() {
+		md := methods.Get(i)
+		method := "/" + string(sd.FullName()) + "/" + string(md.Name())
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			assert.NotContains(t, routes, method)
+		} else {
+			assert.Contains(t, routes, method, "google.api.http annotation missing")
+		}
+	}
+}
+
+func Test_gatewayHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{
+		AdminToken:  "admin-secret",
+		AllowSignup: true,
+	}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userService := service.NewUserService(repository.NewUserDB(sqlite), nil, nil, obs)
+	grpcHandler := NewGRPCUserHandler(userService, nil, nil, nil, nil, nil, zap.NewNop())
+	gateway, err := NewGatewayHandler([]GRPCHandler{grpcHandler}, zap.NewNop())
+	require.NoError(t, err)
+	mux := newTestMux(userService, nil, obs, gateway)
+
+	do := func(method, target, authorization, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("signup is public", func(t *testing.T) {
+		w := do(http.MethodPost, "/v1/users", "", `{"login": "alice", "name": "Alice", "password": "secret"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			User map[string]any `json:"user"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "alice", resp.User["login"])
+		assert.Contains(t, resp.User, "created_at", "proto field names")
+	})
+
+	t.Run("credentials required", func(t *testing.T) {
+		w := do(http.MethodGet, "/v1/users/alice", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		w := do(http.MethodGet, "/v1/users/alice?read_mask=login,name", "Bearer admin-secret", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"login": "alice", "name": "Alice"}`, w.Body.String())
+
+		w = do(http.MethodGet, "/v1/users/bob", "Bearer admin-secret", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"code": 404, "message": "user not found"}`, w.Body.String())
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		w := do(http.MethodPost, "/v1/login", "", `{"login": "alice", "password": "secret", "remember": true}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("create errors", func(t *testing.T) {
+		w := do(http.MethodPost, "/v1/users", "", `{"login": "alice", "name": "Alice", "password": "secret"}`)
+		assert.Equal(t, http.StatusConflict, w.Code, "as POST /user/")
+		assert.JSONEq(t, `{"code": 409, "message": "user with login already exists"}`, w.Body.String())
+
+		// messages of unexpected errors are not sent
+		require.NoError(t, sqlite.(io.Closer).Close())
+		w = do(http.MethodPost, "/v1/users", "", `{"login": "bob", "name": "Bob", "password": "secret"}`)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"code": 500, "message": "internal error"}`, w.Body.String())
+	})
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/audit"
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_userHandler_idempotency(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{
+		AdminToken:     "admin-secret",
+		AllowSignup:    true,
+		IdempotencyTTL: time.Hour,
+	}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userService := service.NewUserService(repository.NewUserDB(sqlite), repository.NewIdempotencyDB(sqlite), nil, obs)
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+	postFrom := func(clientIP, authorization, key, body string) (int, *domain.UserOut) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, "/user/", strings.NewReader(body))
+		r = r.WithContext(audit.NewContext(r.Context(), &audit.Request{ClientIP: clientIP}))
+		r.Header.Set("Authorization", authorization)
+		r.Header.Set("Idempotency-Key", key)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		var user domain.UserOut
+		if w.Code == http.StatusCreated {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+		}
+
+		return w.Code, &user
+	}
+
+	post := func(authorization, key, body string) (int, *domain.UserOut) {
+		t.Helper()
+
+		return postFrom("192.0.2.1", authorization, key, body)
+	}
+
+	admin := "Bearer admin-secret"
+	alice := `{"login": "alice", "name": "Alice", "password": "secret"}`
+
+	code, first := post(admin, "key-1", alice)
+	require.Equal(t, http.StatusCreated, code)
+
+	t.Run("retry replays the first response", func(t *testing.T) {
+		code, retried := post(admin, "key-1", alice)
+		require.Equal(t, http.StatusCreated, code)
+		assert.Equal(t, first, retried)
+
+		code, _ = post(admin, "", alice)
+		assert.Equal(t, http.StatusConflict, code, "without a key")
+	})
+
+	t.Run("different request", func(t *testing.T) {
+		code, _ := post(admin, "key-1", `{"login": "alice", "name": "Alicia", "password": "secret"}`)
+		assert.Equal(t, http.StatusConflict, code)
+
+		code, _ = post(admin, "key-1", `{"login": "alice", "name": "Alice", "password": "other"}`)
+		assert.Equal(t, http.StatusConflict, code)
+	})
+
+	t.Run("keys are per caller", func(t *testing.T) {
+		code, _ := post("", "key-1", alice)
+		assert.Equal(t, http.StatusConflict, code)
+	})
+
+	t.Run("anonymous keys are per client address", func(t *testing.T) {
+		dave := `{"login": "dave", "name": "Dave", "password": "secret"}`
+
+		code, _ := postFrom("192.0.2.1", "", "key-3", dave)
+		require.Equal(t, http.StatusCreated, code)
+		code, _ = postFrom("192.0.2.1", "", "key-3", dave)
+		assert.Equal(t, http.StatusCreated, code, "replayed")
+
+		code, _ = postFrom("198.51.100.1", "", "key-3", dave)
+		assert.Equal(t, http.StatusConflict, code, "not replayed to another client")
+
+		code, _ = postFrom("", "", "key-4", `{"login": "erin", "name": "Erin", "password": "secret"}`)
+		assert.Equal(t, http.StatusBadRequest, code, "unknown client address")
+	})
+
+	t.Run("failed requests release the key", func(t *testing.T) {
+		code, _ := post(admin, "key-2", alice)
+		assert.Equal(t, http.StatusConflict, code)
+
+		code, _ = post(admin, "key-2", `{"login": "bob", "name": "Bob", "password": "secret"}`)
+		assert.Equal(t, http.StatusCreated, code)
+	})
+
+	t.Run("oversized key", func(t *testing.T) {
+		code, _ := post(admin, strings.Repeat("k", 256), `{"login": "carol", "name": "Carol", "password": "secret"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/msgpack"
+)
+
+// Media types of responses, JSON unless Accept asks for another.
+const (
+	mediaTypeJSON     = "application/json"
+	mediaTypeProtobuf = "application/x-protobuf"
+	mediaTypeMsgpack  = "application/msgpack"
+)
+
+// maxBodySize limits the request bodies decoded by decodeJSON.
+const maxBodySize = 64 << 10
+
+var (
+	errNotAcceptable = errors.New("not acceptable, use application/json, application/x-protobuf or application/msgpack")
+	errEmptyBody     = errors.New("empty request body")
+	errTrailingData  = errors.New("unexpected data after the request body")
+)
+
+// mediaTypes maps the accepted names to the media type served.
+var mediaTypes = map[string]string{
+	"*/*":                             mediaTypeJSON,
+	"application/*":                   mediaTypeJSON,
+	"application/json":                mediaTypeJSON,
+	"application/protobuf":            mediaTypeProtobuf,
+	"application/x-protobuf":          mediaTypeProtobuf,
+	"application/vnd.google.protobuf": mediaTypeProtobuf,
+	"application/msgpack":             mediaTypeMsgpack,
+	"application/x-msgpack":           mediaTypeMsgpack,
+	"application/vnd.msgpack":         mediaTypeMsgpack,
+}
+
+// negotiate returns the media type with the highest quality in Accept,
+// explicit types win over wildcards of the same quality.
+func negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return mediaTypeJSON, true
+	}
+
+	var (
+		best     string
+		bestQ    float64
+		bestWild bool
+	)
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		mediaType, ok := mediaTypes[name]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		wild := strings.HasSuffix(name, "/*")
+		if q > bestQ || (q == bestQ && q > 0 && bestWild && !wild) {
+			best, bestQ, bestWild = mediaType, q, wild
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// serveUser writes the user in the media type asked for by Accept, only
+// with the fields of the fields query parameter when it is given. The
+// ETag is the one of the encoded body, GET requests with a matching
+// If-None-Match get 304.
+func serveUser(w http.ResponseWriter, r *http.Request, user *domain.UserOut, code int) {
+	paths, err := readMask(r)
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	mediaType, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		serveErrorJSON(w, http.StatusNotAcceptable, errNotAcceptable)
+		return
+	}
+
+	body, err := encodeUser(user, mediaType, paths)
+	if err != nil {
+		serveErrorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	if mediaType == mediaTypeJSON {
+		body = append(body, '\n')
+	}
+
+	tag := representationETag(user.Version, body)
+	w.Header().Set("ETag", tag)
+	if r.Method == http.MethodGet && etagMatch(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func encodeUser(user *domain.UserOut, mediaType string, paths []string) ([]byte, error) {
+	if mediaType == mediaTypeProtobuf {
+		resp, err := toUserProto(user)
+		if err != nil {
+			return nil, err
+		}
+		maskMessage(resp.ProtoReflect(), paths)
+
+		return proto.Marshal(resp)
+	}
+
+	if mediaType == mediaTypeJSON && len(paths) == 0 {
+		return json.Marshal(user)
+	}
+
+	// other encodings and masks work on the fields of the JSON response
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var value map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	maskMap(value, paths)
+
+	if mediaType == mediaTypeMsgpack {
+		return msgpack.Marshal(value)
+	}
+
+	return json.Marshal(value)
+}
+
+// decodeJSON decodes the request body into v, refusing unknown fields,
+// trailing data and bodies over maxBodySize. It serves the error and
+// returns false when the body is refused.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Body == nil {
+		serveErrorJSON(w, http.StatusBadRequest, errEmptyBody)
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil {
+		if _, errTrailing := decoder.Token(); !errors.Is(errTrailing, io.EOF) {
+			err = errTrailingData
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		serveErrorJSON(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, io.EOF):
+		serveErrorJSON(w, http.StatusBadRequest, errEmptyBody)
+	default:
+		serveErrorJSON(w, http.StatusBadRequest, err)
+	}
+
+	return false
+}
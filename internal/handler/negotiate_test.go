@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_negotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", mediaTypeJSON, true},
+		{"*/*", mediaTypeJSON, true},
+		{"application/x-protobuf", mediaTypeProtobuf, true},
+		{"text/html, application/msgpack;q=0.9, */*;q=0.1", mediaTypeMsgpack, true},
+		{"*/*, application/vnd.google.protobuf", mediaTypeProtobuf, true},
+		{"application/json;q=0.5, application/x-msgpack", mediaTypeMsgpack, true},
+		{"text/html", "", false},
+		{"application/json;q=0", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := negotiate(tt.accept)
+		assert.Equal(t, tt.ok, ok, tt.accept)
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+}
+
+func Test_userHandler_negotiation(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userService := service.NewUserService(repository.NewUserDB(sqlite), nil, nil, obs)
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+	do := func(method, target, accept, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer admin-secret")
+		r.Header.Set("Accept", accept)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("strict decoding", func(t *testing.T) {
+		w := do(http.MethodPost, "/user/", "", `{"login": "alice", "password": "secret", "nmae": "Alice"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "unknown field")
+
+		w = do(http.MethodPost, "/user/", "", `{"login": "alice", "password": "secret"} {}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "trailing data")
+
+		w = do(http.MethodPost, "/user/", "", `{"login": "alice", "name": "`+strings.Repeat("a", maxBodySize)+`"}`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		w = do(http.MethodPost, "/user/", "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, "empty body")
+	})
+
+	w := do(http.MethodPost, "/user/", "", `{"login": "alice", "name": "Alice", "password": "secret"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, mediaTypeJSON, w.Header().Get("Content-Type"))
+
+	t.Run("fields", func(t *testing.T) {
+		w := do(http.MethodGet, "/user/alice?fields=login,lockout.failed_attempts&fields=version", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"login": "alice", "version": 1, "lockout": {"failed_attempts": 0}}`, w.Body.String())
+
+		w = do(http.MethodGet, "/user/alice?fields=password", "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do(http.MethodGet, "/user/alice?fields=etag", "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, "etag is a header")
+	})
+
+	t.Run("entity tags", func(t *testing.T) {
+		tags := make(map[string]bool)
+		for _, target := range []struct{ url, accept string }{
+			{"/user/alice", ""},
+			{"/user/alice", "application/x-protobuf"},
+			{"/user/alice", "application/msgpack"},
+			{"/user/alice?fields=login", ""},
+		} {
+			w := do(http.MethodGet, target.url, target.accept, "")
+			require.Equal(t, http.StatusOK, w.Code)
+			tags[w.Header().Get("ETag")] = true
+		}
+		assert.Len(t, tags, 4, "every representation has its own tag")
+
+		r := httptest.NewRequest(http.MethodGet, "/user/alice", nil)
+		r.Header.Set("Authorization", "Bearer admin-secret")
+		r.Header.Set("Accept", "application/x-protobuf")
+		r.Header.Set("If-None-Match", `"1"`)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, "the JSON tag doesn't match protobuf")
+
+		r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		w := do(http.MethodGet, "/user/alice?fields=login,name", "application/x-protobuf", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, mediaTypeProtobuf, w.Header().Get("Content-Type"))
+
+		var user user_proto.GetUserResponse
+		require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &user))
+		assert.True(t, proto.Equal(&user_proto.GetUserResponse{Login: "alice", Name: "Alice"}, &user))
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		w := do(http.MethodGet, "/user/alice?fields=name", "application/msgpack", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, mediaTypeMsgpack, w.Header().Get("Content-Type"))
+		assert.Equal(t, []byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa5, 'A', 'l', 'i', 'c', 'e'}, w.Body.Bytes())
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		w := do(http.MethodGet, "/user/alice", "text/html", "")
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+		var body errorJSON
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, http.StatusNotAcceptable, body.Code)
+	})
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+)
+
+// pathParams matches the variables of google.api.http path templates.
+var pathParams = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// openAPISpec returns docs/openapi.json with the gateway and Connect routes
+// added from user.proto, so they can't drift from the proto file.
+func openAPISpec() ([]byte, error) {
+	data, err := docsFS.ReadFile("docs/openapi.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var spec map[string]any
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+
+	paths := spec["paths"].(map[string]any)
+	schemas := spec["components"].(map[string]any)["schemas"].(map[string]any)
+
+	sd := user_proto.File_user_v1_user_proto.Services().ByName("UserService")
+	addGatewayPaths(sd, paths, schemas)
+	addConnectPaths(sd, paths, schemas)
+
+	return json.Marshal(spec)
+}
+
+// addGatewayPaths adds an operation for every annotated method of sd to
+// paths and the schemas of its messages to schemas. Messages are named by
+// their full name, fields like in the proto file.
+func addGatewayPaths(sd protoreflect.ServiceDescriptor, paths, schemas map[string]any) {
+	methods := sd.Methods()
+	for i := range methods.Len() {
+		md := methods.Get(i)
+
+		method, path, body, ok := httpRule(md)
+		if !ok {
+			continue
+		}
+
+		input := md.Input()
+		inPath := make(map[string]bool)
+		var parameters []any
+		for _, match := range pathParams.FindAllStringSubmatch(path, -1) {
+			inPath[match[1]] = true
+			parameters = append(parameters, map[string]any{
+				"name": match[1], "in": "path", "required": true,
+				"schema": fieldSchema(input.Fields().ByName(protoreflect.Name(match[1])), schemas),
+			})
+		}
+
+		operation := map[string]any{
+			"operationId": string(sd.Name() + "_" + md.Name()),
+			"summary":     string(md.Name()),
+			"tags":        []any{"v1"},
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "OK",
+					"content":     jsonContent(messageSchema(md.Output(), schemas)),
+				},
+				"default": map[string]any{"$ref": "#/components/responses/Error"},
+			},
+		}
+
+		switch body {
+		case "":
+			// fields which are not in the path are read from the query
+			fields := input.Fields()
+			for j := range fields.Len() {
+				fd := fields.Get(j)
+				if inPath[string(fd.Name())] {
+					continue
+				}
+				parameters = append(parameters, map[string]any{
+					"name": string(fd.Name()), "in": "query", "schema": fieldSchema(fd, schemas),
+				})
+			}
+		case "*":
+			operation["requestBody"] = map[string]any{
+				"required": true, "content": jsonContent(messageSchema(input, schemas)),
+			}
+		default:
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(fieldSchema(input.Fields().ByName(protoreflect.Name(body)), schemas)),
+			}
+		}
+
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if publicMethods["/"+string(sd.FullName())+"/"+string(md.Name())] {
+			operation["security"] = publicSecurity
+		}
+
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(method)] = operation
+	}
+}
+
+// addConnectPaths adds the Connect procedures of sd to paths, unary calls
+// with JSON and binary bodies, streams as enveloped messages. gRPC-Web
+// calls use the same paths.
+func addConnectPaths(sd protoreflect.ServiceDescriptor, paths, schemas map[string]any) {
+	methods := sd.Methods()
+	for i := range methods.Len() {
+		md := methods.Get(i)
+		procedure := "/" + string(sd.FullName()) + "/" + string(md.Name())
+
+		operation := map[string]any{
+			"operationId": string(sd.FullName()) + "." + string(md.Name()),
+			"summary":     string(md.Name()),
+			"tags":        []any{"connect"},
+		}
+
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			operation["requestBody"] = map[string]any{
+				"required": true, "content": streamContent(messageSchema(md.Input(), schemas)),
+			}
+			operation["responses"] = map[string]any{
+				"200": map[string]any{
+					"description": "Messages followed by the end of the stream, errors included",
+					"content":     streamContent(messageSchema(md.Output(), schemas)),
+				},
+			}
+		} else {
+			operation["requestBody"] = map[string]any{
+				"required": true, "content": unaryContent(messageSchema(md.Input(), schemas)),
+			}
+			operation["responses"] = map[string]any{
+				"200": map[string]any{
+					"description": "OK",
+					"content":     unaryContent(messageSchema(md.Output(), schemas)),
+				},
+				"default": map[string]any{"$ref": "#/components/responses/ConnectError"},
+			}
+		}
+
+		if publicMethods[procedure] {
+			operation["security"] = publicSecurity
+		}
+
+		paths[procedure] = map[string]any{"post": operation}
+	}
+
+	paths["/"+string(sd.FullName())+"/"] = map[string]any{
+		"options": map[string]any{
+			"operationId": string(sd.FullName()) + ".Preflight",
+			"summary":     "CORS preflight of the Connect and gRPC-Web calls",
+			"tags":        []any{"connect"},
+			"security":    publicSecurity,
+			"responses": map[string]any{
+				"204": map[string]any{"description": "Allowed origins get the CORS headers"},
+			},
+		},
+	}
+}
+
+func unaryContent(schema any) map[string]any {
+	return map[string]any{
+		"application/json":           map[string]any{"schema": schema},
+		"application/proto":          map[string]any{"schema": map[string]any{"type": "string"}},
+		"application/grpc-web+proto": map[string]any{"schema": map[string]any{"type": "string"}},
+	}
+}
+
+func streamContent(schema any) map[string]any {
+	return map[string]any{
+		"application/connect+json":   map[string]any{"itemSchema": schema},
+		"application/connect+proto":  map[string]any{"schema": map[string]any{"type": "string"}},
+		"application/grpc-web+proto": map[string]any{"schema": map[string]any{"type": "string"}},
+	}
+}
+
+// publicSecurity makes credentials optional, like Public.
+var publicSecurity = []any{
+	map[string]any{},
+	map[string]any{"bearerAuth": []any{}},
+	map[string]any{"basicAuth": []any{}},
+	map[string]any{"apiKey": []any{}},
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// messageSchema adds the schema of md to schemas and returns a reference
+// to it, well known types are inlined the way protojson encodes them.
+func messageSchema(md protoreflect.MessageDescriptor, schemas map[string]any) map[string]any {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]any{"type": "string", "format": "date-time"}
+	case "google.protobuf.FieldMask":
+		return map[string]any{"type": "string", "description": "Comma separated field paths"}
+	}
+
+	name := string(md.FullName())
+	ref := map[string]any{"$ref": "#/components/schemas/" + name}
+	if _, ok := schemas[name]; ok {
+		return ref
+	}
+
+	properties := make(map[string]any)
+	schema := map[string]any{"type": "object", "properties": properties}
+	// registered before the fields, so recursive messages end
+	schemas[name] = schema
+
+	fields := md.Fields()
+	for i := range fields.Len() {
+		properties[string(fields.Get(i).Name())] = fieldSchema(fields.Get(i), schemas)
+	}
+
+	return ref
+}
+
+func fieldSchema(fd protoreflect.FieldDescriptor, schemas map[string]any) map[string]any {
+	if fd.IsMap() {
+		return map[string]any{"type": "object", "additionalProperties": kindSchema(fd.MapValue(), schemas)}
+	}
+	if fd.IsList() {
+		return map[string]any{"type": "array", "items": kindSchema(fd, schemas)}
+	}
+
+	return kindSchema(fd, schemas)
+}
+
+// kindSchema returns the schema of a single value of fd as encoded by
+// protojson, which encodes 64 bit integers as strings.
+func kindSchema(fd protoreflect.FieldDescriptor, schemas map[string]any) map[string]any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]any{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]any{"type": "integer", "format": "uint32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]any{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]any{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return map[string]any{"type": "number"}
+	case protoreflect.StringKind:
+		return map[string]any{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]any{"type": "string", "contentEncoding": "base64"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]any, 0, values.Len())
+		for i := range values.Len() {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return map[string]any{"type": "string", "enum": names}
+	default:
+		return messageSchema(fd.Message(), schemas)
+	}
+}
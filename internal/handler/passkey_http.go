@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+type passkeyHandler struct {
+	passkeyService service.PasskeyServiceInterface
+	logger         *zap.SugaredLogger
+}
+
+// passkeyJSON is a passkey with the credential ID encoded as base64url,
+// the same way browsers encode it.
+type passkeyJSON struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	SignCount  uint32     `json:"sign_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type passkeyLoginJSON struct {
+	Login string `json:"login"`
+}
+
+func toPasskeyJSON(passkey *domain.Passkey) passkeyJSON {
+	return passkeyJSON{
+		ID:         base64.RawURLEncoding.EncodeToString(passkey.ID),
+		Name:       passkey.Name,
+		SignCount:  passkey.SignCount,
+		CreatedAt:  passkey.CreatedAt,
+		LastUsedAt: passkey.LastUsedAt,
+	}
+}
+
+func (passkeyhandler *passkeyHandler) GetMux(mux Router) {
+	mux.Handle("POST /webauthn/register/begin", Public(passkeyhandler.beginRegistration))
+	mux.Handle("POST /webauthn/register/finish", Public(passkeyhandler.finishRegistration))
+	mux.Handle("POST /webauthn/login/begin", Public(passkeyhandler.beginLogin))
+	mux.Handle("POST /webauthn/login/finish", Public(passkeyhandler.finishLogin))
+	mux.HandleFunc("GET /admin/user/{login}/passkeys", passkeyhandler.listPasskeys)
+	mux.HandleFunc("DELETE /admin/user/{login}/passkeys/{id}", passkeyhandler.deletePasskey)
+}
+
+func (passkeyhandler *passkeyHandler) beginRegistration(w http.ResponseWriter, r *http.Request) {
+	var registration domain.PasskeyRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	started, err := passkeyhandler.passkeyService.BeginRegistration(r.Context(), &registration)
+	if err != nil {
+		serveAuthError(w, passkeyhandler.logger, registration.Login, err)
+		return
+	}
+
+	serveJSON(w, started, http.StatusOK)
+}
+
+// finishRegistration takes the PublicKeyCredential returned by
+// navigator.credentials.create() as the body.
+func (passkeyhandler *passkeyHandler) finishRegistration(w http.ResponseWriter, r *http.Request) {
+	passkey, err := passkeyhandler.passkeyService.FinishRegistration(r.Context(), r.URL.Query().Get("session_id"), r.Body)
+	if err != nil {
+		serveAuthError(w, passkeyhandler.logger, "", err)
+		return
+	}
+
+	passkeyhandler.logger.Infow("Passkey registered", "user_id", passkey.UserID, "name", passkey.Name)
+	serveJSON(w, toPasskeyJSON(passkey), http.StatusCreated)
+}
+
+// beginLogin starts a login with passkeys of the user, an empty body or
+// login starts a login with a discoverable passkey.
+func (passkeyhandler *passkeyHandler) beginLogin(w http.ResponseWriter, r *http.Request) {
+	var login passkeyLoginJSON
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+			serveErrorJSON(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	started, err := passkeyhandler.passkeyService.BeginLogin(r.Context(), login.Login)
+	if err != nil {
+		serveAuthError(w, passkeyhandler.logger, login.Login, err)
+		return
+	}
+
+	serveJSON(w, started, http.StatusOK)
+}
+
+// finishLogin takes the PublicKeyCredential returned by
+// navigator.credentials.get() as the body.
+func (passkeyhandler *passkeyHandler) finishLogin(w http.ResponseWriter, r *http.Request) {
+	result, err := passkeyhandler.passkeyService.FinishLogin(r.Context(), r.URL.Query().Get("session_id"), r.Body)
+	if err != nil {
+		serveAuthError(w, passkeyhandler.logger, "", err)
+		return
+	}
+
+	serveJSON(w, toLoginJSON(result), http.StatusOK)
+}
+
+func (passkeyhandler *passkeyHandler) listPasskeys(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	passkeys, err := passkeyhandler.passkeyService.ListPasskeys(r.Context(), login)
+	if err != nil {
+		serveAuthError(w, passkeyhandler.logger, login, err)
+		return
+	}
+
+	out := make([]passkeyJSON, 0, len(passkeys))
+	for i := range passkeys {
+		out = append(out, toPasskeyJSON(&passkeys[i]))
+	}
+
+	serveJSON(w, out, http.StatusOK)
+}
+
+func (passkeyhandler *passkeyHandler) deletePasskey(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	id, err := base64.RawURLEncoding.DecodeString(r.PathValue("id"))
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := passkeyhandler.passkeyService.DeletePasskey(r.Context(), login, id); err != nil {
+		serveAuthError(w, passkeyhandler.logger, login, err)
+		return
+	}
+
+	passkeyhandler.logger.Infow("Passkey deleted", "login", login)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func NewPasskeyHandler(passkeyService service.PasskeyServiceInterface, logger *zap.Logger) HTTPHandler {
+	return &passkeyHandler{
+		passkeyService,
+		logger.Named("PasskeyHandler").Sugar(),
+	}
+}
@@ -0,0 +1,381 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+const (
+	testRPID   = "login.example.com"
+	testOrigin = "https://login.example.com"
+)
+
+// softAuthenticator is a software passkey holding a P-256 key, it answers
+// ceremonies with "none" attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+type ceremonyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		User      struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		AllowCredentials []struct {
+			ID string `json:"id"`
+		} `json:"allowCredentials"`
+	} `json:"publicKey"`
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ string, options ceremonyOptions) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": options.PublicKey.Challenge,
+		"origin":    testOrigin,
+	})
+	require.NoError(t, err)
+
+	return data
+}
+
+// authData builds authenticator data with user present and verified flags.
+func (a *softAuthenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	flags := byte(0x05)
+	if attested != nil {
+		flags |= 0x40
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+
+	return append(data, attested...)
+}
+
+// create answers navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, ceremony domain.PasskeyCeremony) []byte {
+	t.Helper()
+
+	var options ceremonyOptions
+	require.NoError(t, json.Unmarshal(ceremony.Options, &options))
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.PublicKey.User.ID)
+	require.NoError(t, err)
+	a.userHandle = userHandle
+
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(attested),
+	})
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64(a.clientData(t, "webauthn.create", options)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get(), the counter grows with every
+// assertion.
+func (a *softAuthenticator) get(t *testing.T, ceremony domain.PasskeyCeremony) []byte {
+	t.Helper()
+
+	var options ceremonyOptions
+	require.NoError(t, json.Unmarshal(ceremony.Options, &options))
+
+	a.counter++
+	authData := a.authData(nil)
+	clientData := a.clientData(t, "webauthn.get", options)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	require.NoError(t, err)
+
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func Test_passkeyHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+
+	cfg := &config.Config{
+		AdminToken: "admin-secret",
+		Lockout:    config.LockoutConfig{MaxAttempts: 3, Duration: time.Hour},
+		WebAuthn: config.WebAuthnConfig{
+			RPID:          testRPID,
+			RPDisplayName: "test",
+			RPOrigins:     []string{testOrigin},
+			SessionTTL:    time.Minute,
+		},
+	}
+	obs := config.NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+
+	users := repository.NewUserDB(sqlite)
+	userService := service.NewUserService(users, nil, nil, obs)
+	passkeyService := service.NewPasskeyService(
+		users, service.NewLoginService(users, obs), repository.NewPasskeyDB(sqlite), obs, zap.NewNop())
+
+	mux := newTestMux(userService, nil, obs,
+		NewUserHandler(userService, zap.NewNop()), NewPasskeyHandler(passkeyService, zap.NewNop()))
+
+	do := func(method, url string, body []byte, v any) int {
+		t.Helper()
+
+		r := httptest.NewRequest(method, url, bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer admin-secret")
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+
+		return w.Code
+	}
+	post := func(url string, body any, v any) int {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		return do(http.MethodPost, url, data, v)
+	}
+
+	require.Equal(t, http.StatusCreated, post("/user/", map[string]string{"login": "alice", "password": "secret"}, nil))
+
+	register := func(authenticator *softAuthenticator, name string) passkeyJSON {
+		t.Helper()
+
+		var started domain.PasskeyCeremony
+		require.Equal(t, http.StatusOK, post("/webauthn/register/begin",
+			domain.PasskeyRegistration{Login: "alice", Password: "secret", Name: name}, &started))
+
+		var passkey passkeyJSON
+		require.Equal(t, http.StatusCreated,
+			do(http.MethodPost, "/webauthn/register/finish?session_id="+started.SessionID, authenticator.create(t, started), &passkey))
+
+		return passkey
+	}
+
+	laptop, phone := newSoftAuthenticator(t), newSoftAuthenticator(t)
+
+	passkey := register(laptop, "laptop")
+	assert.Equal(t, "laptop", passkey.Name)
+	assert.Equal(t, b64(laptop.credentialID), passkey.ID)
+	assert.Equal(t, "Passkey 2", register(phone, "").Name)
+
+	login := func(authenticator *softAuthenticator, login string) (int, string) {
+		t.Helper()
+
+		var started domain.PasskeyCeremony
+		require.Equal(t, http.StatusOK, post("/webauthn/login/begin", passkeyLoginJSON{Login: login}, &started))
+
+		var resp errorJSON
+		code := do(http.MethodPost, "/webauthn/login/finish?session_id="+started.SessionID, authenticator.get(t, started), &resp)
+
+		return code, resp.Message
+	}
+
+	t.Run("register with wrong password", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, post("/webauthn/register/begin",
+			domain.PasskeyRegistration{Login: "alice", Password: "wrong"}, nil))
+	})
+
+	t.Run("login", func(t *testing.T) {
+		var started domain.PasskeyCeremony
+		require.Equal(t, http.StatusOK, post("/webauthn/login/begin", passkeyLoginJSON{Login: "alice"}, &started))
+
+		var options ceremonyOptions
+		require.NoError(t, json.Unmarshal(started.Options, &options))
+		assert.Len(t, options.PublicKey.AllowCredentials, 2)
+
+		var user domain.UserOut
+		require.Equal(t, http.StatusOK,
+			do(http.MethodPost, "/webauthn/login/finish?session_id="+started.SessionID, laptop.get(t, started), &user))
+		assert.Equal(t, "alice", user.Login)
+
+		// sessions are single use
+		assert.Equal(t, http.StatusUnauthorized,
+			do(http.MethodPost, "/webauthn/login/finish?session_id="+started.SessionID, laptop.get(t, started), nil))
+	})
+
+	t.Run("discoverable login", func(t *testing.T) {
+		code, _ := login(phone, "")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("unknown passkey", func(t *testing.T) {
+		code, message := login(newSoftAuthenticator(t), "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Equal(t, service.ErrPasskeyRejected.Error(), message)
+	})
+
+	t.Run("cloned passkey", func(t *testing.T) {
+		clone := *laptop
+		clone.counter = 0
+
+		code, message := login(&clone, "alice")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Equal(t, service.ErrPasskeyCloned.Error(), message)
+
+		code, _ = login(laptop, "alice")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		var passkeys []passkeyJSON
+		require.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/user/alice/passkeys", nil, &passkeys))
+		require.Len(t, passkeys, 2)
+		assert.Equal(t, "laptop", passkeys[0].Name)
+		assert.Equal(t, laptop.counter, passkeys[0].SignCount)
+		assert.NotNil(t, passkeys[0].LastUsedAt)
+
+		require.Equal(t, http.StatusNoContent,
+			do(http.MethodDelete, "/admin/user/alice/passkeys/"+b64(phone.credentialID), nil, nil))
+		assert.Equal(t, http.StatusNotFound,
+			do(http.MethodDelete, "/admin/user/alice/passkeys/"+b64(phone.credentialID), nil, nil))
+
+		code, _ := login(phone, "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/user/alice/passkeys", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("own passkeys", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, post("/user/", map[string]string{"login": "bob", "password": "secret"}, nil))
+
+		as := func(login, method, url string, v any) int {
+			t.Helper()
+
+			r := httptest.NewRequest(method, url, nil)
+			r.Header.Set("Authorization", basicAuth(login, "secret"))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if v != nil {
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+			}
+
+			return w.Code
+		}
+
+		var passkeys []passkeyJSON
+		require.Equal(t, http.StatusOK, as("alice", http.MethodGet, "/admin/user/alice/passkeys", &passkeys))
+		assert.Len(t, passkeys, 1)
+		assert.Equal(t, http.StatusNotFound,
+			as("alice", http.MethodDelete, "/admin/user/alice/passkeys/"+b64(phone.credentialID), nil))
+
+		assert.Equal(t, http.StatusForbidden, as("bob", http.MethodGet, "/admin/user/alice/passkeys", nil))
+		assert.Equal(t, http.StatusForbidden,
+			as("bob", http.MethodDelete, "/admin/user/alice/passkeys/"+b64(laptop.credentialID), nil))
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		events := func(action string) []domain.AuditEvent {
+			t.Helper()
+
+			events, err := repository.NewAuditDB(sqlite).ListAuditEvents(t.Context(),
+				&domain.AuditFilter{Login: "alice", Action: action, Limit: 100})
+			require.NoError(t, err)
+
+			return events
+		}
+
+		added := events(domain.AuditAddPasskey)
+		require.Len(t, added, 2)
+		assert.Equal(t, domain.AuditChange{Before: float64(0), After: float64(1)}, added[0].Diff["passkeys"])
+
+		deleted := events(domain.AuditDeletePasskey)
+		require.Len(t, deleted, 1)
+		assert.Equal(t, domain.AuditChange{Before: float64(2), After: float64(1)}, deleted[0].Diff["passkeys"])
+
+		used := events(domain.AuditUsePasskey)
+		require.NotEmpty(t, used)
+		assert.Contains(t, used[0].Diff, "passkey_last_used_at")
+
+		failed := events(domain.AuditFailLogin)
+		require.NotEmpty(t, failed)
+		assert.Equal(t, domain.AuditChange{Before: float64(0), After: float64(1)}, failed[0].Diff["failed_attempts"])
+	})
+}
@@ -0,0 +1,76 @@
+package handler
+
+import "go.uber.org/fx"
+
+// HTTPRoutes provides every HTTP handler of the application to the
+// http_routes group. The gateway and Connect handlers serve the handlers
+// of the grpc_routes group.
+var HTTPRoutes = fx.Options(
+	fx.Provide(fx.Annotate(
+		NewUserHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewAdminHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewPasskeyHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewAPIKeyHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewAuditHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewWebhookHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewBulkHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewWatchHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewGatewayHandler,
+		fx.ParamTags(`group:"grpc_routes"`),
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewConnectHandler,
+		fx.ParamTags(`group:"grpc_routes"`),
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+
+	fx.Provide(fx.Annotate(
+		NewDocsHandler,
+		fx.ResultTags(`group:"http_routes"`),
+		fx.As(new(HTTPHandler)),
+	)),
+)
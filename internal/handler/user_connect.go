@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rs/cors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+	"github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1/user_protoconnect"
+)
+
+var (
+	// corsAllowedHeaders are the request headers of the Connect, gRPC-Web
+	// and gRPC protocols along with credentials.
+	corsAllowedHeaders = []string{
+		"Content-Type", "Connect-Protocol-Version", "Connect-Timeout-Ms", "Grpc-Timeout", "X-Grpc-Web",
+		"X-User-Agent", "Authorization", "X-Api-Key",
+	}
+	// corsExposedHeaders are the response headers browsers let clients read.
+	corsExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin", "Retry-After"}
+
+	errStreamMethod = errors.New("not supported over Connect")
+)
+
+// corsMaxAge is how long browsers may cache preflight results.
+const corsMaxAge = 2 * time.Hour
+
+// connectHandler serves UserService over the Connect, gRPC-Web and gRPC
+// protocols on the HTTP listener, so browsers can call it. The calls go to
+// the gRPC handler in process after the HTTP middleware, like those of the
+// gateway.
+type connectHandler struct {
+	handler http.Handler
+	cors    *cors.Cors
+	logger  *zap.SugaredLogger
+}
+
+// GetMux registers every procedure as a route of its own, public methods
+// with Public. Preflight requests carry no credentials, so they are public.
+func (c *connectHandler) GetMux(mux Router) {
+	sd := user_proto.File_user_v1_user_proto.Services().ByName("UserService")
+
+	methods := sd.Methods()
+	for i := range methods.Len() {
+		md := methods.Get(i)
+		procedure := "/" + string(sd.FullName()) + "/" + string(md.Name())
+
+		h := c.cors.Handler(c.handler)
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			h = withoutDeadlines(h)
+		}
+
+		if publicMethods[procedure] {
+			mux.Handle(http.MethodPost+" "+procedure, Public(h.ServeHTTP))
+		} else {
+			mux.Handle(http.MethodPost+" "+procedure, h)
+		}
+	}
+
+	mux.Handle(http.MethodOptions+" /"+string(sd.FullName())+"/", Public(c.cors.Handler(c.handler).ServeHTTP))
+}
+
+// withoutDeadlines lets streams outlive read_timeout and write_timeout.
+func withoutDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connectUserService adapts the gRPC handler to the Connect handler. Unary
+// methods have the same signatures, streams are wrapped.
+type connectUserService struct {
+	user_proto.UserServiceServer
+}
+
+func (s connectUserService) WatchUsers(
+	ctx context.Context, r *user_proto.WatchUsersRequest, stream *connect.ServerStream[user_proto.UserEvent],
+) error {
+	return s.UserServiceServer.WatchUsers(r, grpcServerStream[user_proto.UserEvent]{grpcStream{ctx}, stream})
+}
+
+func (s connectUserService) BulkCreate(
+	ctx context.Context, stream *connect.ClientStream[user_proto.BulkCreateRequest],
+) (*user_proto.BulkCreateResponse, error) {
+	clientStream := &grpcClientStream[user_proto.BulkCreateRequest, user_proto.BulkCreateResponse]{
+		grpcStream: grpcStream{ctx},
+		stream:     stream,
+	}
+	if err := s.UserServiceServer.BulkCreate(clientStream); err != nil {
+		return nil, err
+	}
+
+	return clientStream.response, nil
+}
+
+func (s connectUserService) Export(
+	ctx context.Context, r *user_proto.ExportRequest, stream *connect.ServerStream[user_proto.ExportedUser],
+) error {
+	return s.UserServiceServer.Export(r, grpcServerStream[user_proto.ExportedUser]{grpcStream{ctx}, stream})
+}
+
+// grpcStream implements grpc.ServerStream for the gRPC handler, headers
+// go to the Connect response through grpcInterceptor.
+type grpcStream struct {
+	ctx context.Context
+}
+
+func (s grpcStream) Context() context.Context { return s.ctx }
+
+func (s grpcStream) SetHeader(md metadata.MD) error { return grpc.SetHeader(s.ctx, md) }
+
+// SendHeader only sets the headers, Connect sends them with the first
+// message.
+func (s grpcStream) SendHeader(md metadata.MD) error { return grpc.SetHeader(s.ctx, md) }
+
+func (s grpcStream) SetTrailer(md metadata.MD) { _ = grpc.SetTrailer(s.ctx, md) }
+
+func (s grpcStream) SendMsg(any) error { return errStreamMethod }
+
+func (s grpcStream) RecvMsg(any) error { return errStreamMethod }
+
+type grpcServerStream[T any] struct {
+	grpcStream
+	stream *connect.ServerStream[T]
+}
+
+func (s grpcServerStream[T]) Send(m *T) error {
+	return s.stream.Send(m)
+}
+
+type grpcClientStream[Req, Res any] struct {
+	grpcStream
+	stream   *connect.ClientStream[Req]
+	response *Res
+}
+
+func (s *grpcClientStream[Req, Res]) Recv() (*Req, error) {
+	if s.stream.Receive() {
+		return s.stream.Msg(), nil
+	}
+	if err := s.stream.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+func (s *grpcClientStream[Req, Res]) SendAndClose(m *Res) error {
+	s.response = m
+	return nil
+}
+
+// grpcInterceptor runs the gRPC handler under Connect: headers set with
+// grpc.SetHeader go to the response and status errors keep their codes.
+type grpcInterceptor struct{}
+
+func (grpcInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		// the handler creates the call info before the interceptors run,
+		// its headers are sent on errors too
+		if info, ok := connect.CallInfoForHandlerContext(ctx); ok {
+			ctx = grpc.NewContextWithServerTransportStream(ctx, &transportStream{
+				method: req.Spec().Procedure, header: info.ResponseHeader(), trailer: info.ResponseTrailer(),
+			})
+		}
+
+		res, err := next(ctx, req)
+		return res, connectError(err)
+	}
+}
+
+func (grpcInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (grpcInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx = grpc.NewContextWithServerTransportStream(ctx, &transportStream{
+			method: conn.Spec().Procedure, header: conn.ResponseHeader(), trailer: conn.ResponseTrailer(),
+		})
+
+		return connectError(next(ctx, conn))
+	}
+}
+
+// transportStream collects headers and trailers set by the gRPC handler.
+type transportStream struct {
+	method  string
+	header  http.Header
+	trailer http.Header
+}
+
+func (s *transportStream) Method() string { return s.method }
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	addMetadata(s.header, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	addMetadata(s.header, md)
+	return nil
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	addMetadata(s.trailer, md)
+	return nil
+}
+
+func addMetadata(header http.Header, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+}
+
+// connectError converts gRPC status errors to Connect errors, the codes
+// are the same.
+func connectError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return err
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	return connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+}
+
+func NewConnectHandler(grpcHandlers []GRPCHandler, obs *config.Observer, logger *zap.Logger) HTTPHandler {
+	c := &connectHandler{
+		handler: http.NotFoundHandler(),
+		// origins are read on every request, so they can be reloaded
+		cors: cors.New(cors.Options{
+			AllowOriginFunc: func(origin string) bool { return obs.Current().CORS.AllowOrigin(origin) },
+			AllowedMethods:  []string{http.MethodPost},
+			AllowedHeaders:  corsAllowedHeaders,
+			ExposedHeaders:  corsExposedHeaders,
+			MaxAge:          int(corsMaxAge.Seconds()),
+		}),
+		logger: logger.Named("ConnectHandler").Sugar(),
+	}
+
+	for _, grpcHandler := range grpcHandlers {
+		if server, ok := grpcHandler.(user_proto.UserServiceServer); ok {
+			_, c.handler = user_protoconnect.NewUserServiceHandler(
+				connectUserService{server},
+				connect.WithInterceptors(grpcInterceptor{}),
+				connect.WithReadMaxBytes(maxBodySize),
+			)
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+	"github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1/user_protoconnect"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
+)
+
+// headerUserService sets headers and trailers from a stream, the way a
+// gRPC handler does.
+type headerUserService struct {
+	user_proto.UnimplementedUserServiceServer
+}
+
+func (headerUserService) RegisterGRPC(*grpc.Server) {}
+
+func (headerUserService) Export(_ *user_proto.ExportRequest, stream grpc.ServerStreamingServer[user_proto.ExportedUser]) error {
+	if err := stream.SetHeader(metadata.Pairs("x-export", "set")); err != nil {
+		return err
+	}
+	if err := stream.SendHeader(metadata.Pairs("x-export", "sent")); err != nil {
+		return err
+	}
+	stream.SetTrailer(metadata.Pairs("x-export-count", "1"))
+
+	return stream.Send(&user_proto.ExportedUser{Login: "alice"})
+}
+
+func Test_connectHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+	users := repository.NewUserDB(sqlite)
+	obs := config.NewObserver(&config.Config{
+		AdminToken:  "admin-secret",
+		AllowSignup: true,
+		CORS:        config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		Watch:       config.WatchConfig{PollInterval: 10 * time.Millisecond, Buffer: 16, Heartbeat: time.Minute},
+	}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	hub := watch.NewHub(repository.NewOutboxDB(sqlite), obs, fxtest.NewLifecycle(t), zap.NewNop())
+	require.NoError(t, hub.Start(t.Context()))
+	t.Cleanup(func() { _ = hub.Stop(context.Background()) })
+
+	userService := service.NewUserService(users, nil, nil, obs)
+	grpcHandler := NewGRPCUserHandler(userService, nil, nil, nil,
+		service.NewWatchService(hub), service.NewBulkService(users), zap.NewNop())
+	srv := httptest.NewServer(newTestMux(userService, nil, obs, NewConnectHandler([]GRPCHandler{grpcHandler}, obs, zap.NewNop())))
+	t.Cleanup(srv.Close)
+
+	admin := func(t *testing.T) context.Context {
+		ctx, info := connect.NewClientContext(t.Context())
+		info.RequestHeader().Set("Authorization", "Bearer admin-secret")
+		return ctx
+	}
+
+	client := user_protoconnect.NewUserServiceClient(srv.Client(), srv.URL, connect.WithProtoJSON())
+
+	t.Run("signup is public", func(t *testing.T) {
+		resp, err := client.Create(t.Context(), &user_proto.CreateRequest{Login: "alice", Name: "Alice", Password: "secret"})
+		require.NoError(t, err)
+		assert.Equal(t, "alice", resp.GetUser().GetLogin())
+	})
+
+	t.Run("credentials required", func(t *testing.T) {
+		_, err := client.GetByLogin(t.Context(), &user_proto.GetByLoginRequest{Login: "alice"})
+		assert.Equal(t, connect.CodeUnauthenticated, connect.CodeOf(err))
+	})
+
+	t.Run("status codes", func(t *testing.T) {
+		_, err := client.GetByLogin(admin(t), &user_proto.GetByLoginRequest{Login: "bob"})
+		assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+
+	t.Run("gRPC-Web", func(t *testing.T) {
+		client := user_protoconnect.NewUserServiceClient(srv.Client(), srv.URL, connect.WithGRPCWeb())
+
+		resp, err := client.GetByLogin(admin(t), &user_proto.GetByLoginRequest{Login: "alice"})
+		require.NoError(t, err)
+		assert.Equal(t, "Alice", resp.GetName())
+	})
+
+	t.Run("CORS", func(t *testing.T) {
+		preflight := func(origin string) *http.Response {
+			t.Helper()
+
+			r, err := http.NewRequestWithContext(t.Context(), http.MethodOptions,
+				srv.URL+user_protoconnect.UserServiceGetByLoginProcedure, nil)
+			require.NoError(t, err)
+			r.Header.Set("Origin", origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			r.Header.Set("Access-Control-Request-Headers", "authorization,connect-protocol-version,content-type")
+
+			resp, err := srv.Client().Do(r)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = resp.Body.Close() })
+
+			return resp
+		}
+
+		resp := preflight("https://app.example.com")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+
+		resp = preflight("https://evil.example.com")
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("WatchUsers", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(admin(t), 5*time.Second)
+		defer cancel()
+
+		stream, err := client.WatchUsers(ctx, &user_proto.WatchUsersRequest{Revision: new(int64)})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = stream.Close() })
+
+		require.True(t, stream.Receive(), stream.Err())
+		assert.Equal(t, int64(1), stream.Msg().GetRevision())
+		assert.Equal(t, domain.EventUserCreated, stream.Msg().GetType())
+		assert.Equal(t, "alice", stream.Msg().GetUser().GetLogin())
+
+		cancel()
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeCanceled, connect.CodeOf(stream.Err()))
+	})
+
+	t.Run("WatchUsers out of range", func(t *testing.T) {
+		revision := int64(10)
+		stream, err := client.WatchUsers(admin(t), &user_proto.WatchUsersRequest{Revision: &revision})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = stream.Close() })
+
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOutOfRange, connect.CodeOf(stream.Err()))
+	})
+
+	t.Run("BulkCreate", func(t *testing.T) {
+		stream, err := client.BulkCreate(admin(t))
+		require.NoError(t, err)
+
+		for _, login := range []string{"bob", "alice", "carol"} {
+			require.NoError(t, stream.Send(&user_proto.BulkCreateRequest{Login: login, Password: "secret", Name: login}))
+		}
+
+		// the rows are read until io.EOF once the client closes the stream
+		resp, err := stream.CloseAndReceive()
+		require.NoError(t, err)
+		require.Len(t, resp.GetResults(), 3)
+
+		statuses := make([]string, 0, len(resp.GetResults()))
+		for _, result := range resp.GetResults() {
+			statuses = append(statuses, result.GetStatus())
+		}
+		assert.Equal(t, []string{domain.ImportCreated, domain.ImportFailed, domain.ImportCreated}, statuses)
+		assert.Equal(t, int32(2), resp.GetResults()[1].GetRow())
+	})
+
+	t.Run("BulkCreate credentials required", func(t *testing.T) {
+		stream, err := client.BulkCreate(t.Context())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&user_proto.BulkCreateRequest{Login: "dave", Password: "secret", Name: "dave"}))
+
+		_, err = stream.CloseAndReceive()
+		assert.Equal(t, connect.CodeUnauthenticated, connect.CodeOf(err))
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		stream, err := client.Export(admin(t), &user_proto.ExportRequest{})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = stream.Close() })
+
+		var logins []string
+		for stream.Receive() {
+			assert.Empty(t, stream.Msg().GetPasswordHash())
+			logins = append(logins, stream.Msg().GetLogin())
+		}
+		require.NoError(t, stream.Err())
+		assert.Equal(t, []string{"alice", "bob", "carol"}, logins)
+	})
+
+	t.Run("stream headers", func(t *testing.T) {
+		srv := httptest.NewServer(newTestMux(userService, nil, obs,
+			NewConnectHandler([]GRPCHandler{headerUserService{}}, obs, zap.NewNop())))
+		t.Cleanup(srv.Close)
+
+		client := user_protoconnect.NewUserServiceClient(srv.Client(), srv.URL)
+
+		stream, err := client.Export(admin(t), &user_proto.ExportRequest{})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = stream.Close() })
+
+		require.True(t, stream.Receive(), stream.Err())
+		assert.Equal(t, []string{"set", "sent"}, stream.ResponseHeader().Values("x-export"))
+
+		assert.False(t, stream.Receive())
+		require.NoError(t, stream.Err())
+		assert.Equal(t, "1", stream.ResponseTrailer().Get("x-export-count"))
+	})
+}
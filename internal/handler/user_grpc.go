@@ -2,14 +2,24 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/iliadmitriev/go-user-test/internal/auth"
 	"github.com/iliadmitriev/go-user-test/internal/domain"
 	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
 	"github.com/iliadmitriev/go-user-test/internal/service"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
 )
 
 type GRPCHandler interface {
@@ -19,14 +29,32 @@ type GRPCHandler interface {
 type grpcUserHandler struct {
 	user_proto.UserServiceServer
 
-	userService service.UserServiceInterface
-	logger      *zap.SugaredLogger
+	userService    service.UserServiceInterface
+	apiKeyService  service.APIKeyServiceInterface
+	auditService   service.AuditServiceInterface
+	webhookService service.WebhookServiceInterface
+	watchService   service.WatchServiceInterface
+	bulkService    service.BulkServiceInterface
+	logger         *zap.SugaredLogger
 }
 
-func NewGRPCUserHandler(userService service.UserServiceInterface, logger *zap.Logger) GRPCHandler {
+func NewGRPCUserHandler(
+	userService service.UserServiceInterface,
+	apiKeyService service.APIKeyServiceInterface,
+	auditService service.AuditServiceInterface,
+	webhookService service.WebhookServiceInterface,
+	watchService service.WatchServiceInterface,
+	bulkService service.BulkServiceInterface,
+	logger *zap.Logger,
+) GRPCHandler {
 	return &grpcUserHandler{
-		userService: userService,
-		logger:      logger.Named("GRPCUserHandler").Sugar(),
+		userService:    userService,
+		apiKeyService:  apiKeyService,
+		auditService:   auditService,
+		webhookService: webhookService,
+		watchService:   watchService,
+		bulkService:    bulkService,
+		logger:         logger.Named("GRPCUserHandler").Sugar(),
 	}
 }
 
@@ -39,38 +67,727 @@ func (g *grpcUserHandler) Create(ctx context.Context, r *user_proto.CreateReques
 	userIn.Login = r.GetLogin()
 	userIn.Password = r.GetPassword()
 	userIn.Name = r.GetName()
+	userIn.Email = r.GetEmail()
+	userIn.IdempotencyKey = r.GetIdempotencyKey()
 
 	g.logger.Infow("Got grpc request", "login", userIn.Login, "name", userIn.Name)
-	_, err := g.userService.CreateUser(ctx, &userIn)
+
+	user, err := g.userService.CreateUser(ctx, &userIn)
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, userIn.Login, err)
+	}
+
+	resp, err := g.userResponse(user)
 	if err != nil {
-		g.logger.Warnw("Error creating user", "err", err)
 		return nil, err
 	}
 
+	// code and message are deprecated, the status carries the outcome
 	return &user_proto.CreateResponse{
 		Code:    200,
 		Message: "User created successfully",
+		User:    resp,
 	}, nil
 }
 
 func (g *grpcUserHandler) GetByLogin(ctx context.Context, r *user_proto.GetByLoginRequest) (*user_proto.GetUserResponse, error) {
+	if err := checkReadMask(r.GetReadMask()); err != nil {
+		return nil, err
+	}
+
 	user, err := g.userService.GetUser(ctx, r.GetLogin())
 	if err != nil {
-		g.logger.Warnw("Error getting user", "err", err)
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	return g.maskedUserResponse(user, r.GetReadMask())
+}
+
+func (g *grpcUserHandler) Update(ctx context.Context, r *user_proto.UpdateRequest) (*user_proto.GetUserResponse, error) {
+	if err := checkReadMask(r.GetReadMask()); err != nil {
 		return nil, err
 	}
 
-	id, err := user.ID.MarshalBinary()
+	update := domain.UserUpdate{Name: r.Name, Email: r.Email}
+
+	user, err := g.userService.UpdateUser(ctx, r.GetLogin(), &update, r.GetExpectedVersion())
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	return g.maskedUserResponse(user, r.GetReadMask())
+}
+
+// checkReadMask refuses read masks with fields GetUserResponse doesn't have.
+func checkReadMask(mask *fieldmaskpb.FieldMask) error {
+	if mask != nil && !mask.IsValid(&user_proto.GetUserResponse{}) {
+		return status.Error(codes.InvalidArgument, "read_mask has unknown fields")
+	}
+
+	return nil
+}
+
+func (g *grpcUserHandler) maskedUserResponse(user *domain.UserOut, mask *fieldmaskpb.FieldMask) (*user_proto.GetUserResponse, error) {
+	resp, err := g.userResponse(user)
+	if err != nil {
+		return nil, err
+	}
+	maskMessage(resp.ProtoReflect(), mask.GetPaths())
+
+	return resp, nil
+}
+
+func (g *grpcUserHandler) Login(ctx context.Context, r *user_proto.LoginRequest) (*user_proto.LoginResponse, error) {
+	result, err := g.userService.Login(ctx, &domain.Credentials{
+		Login:    r.GetLogin(),
+		Password: r.GetPassword(),
+	})
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	if result.MFAToken != "" {
+		return &user_proto.LoginResponse{MfaRequired: true, MfaToken: result.MFAToken}, nil
+	}
+
+	return g.loginResponse(result)
+}
+
+func (g *grpcUserHandler) LoginMFA(ctx context.Context, r *user_proto.LoginMFARequest) (*user_proto.LoginResponse, error) {
+	result, err := g.userService.LoginMFA(ctx, &domain.MFACode{
+		MFAToken:     r.GetMfaToken(),
+		Code:         r.GetCode(),
+		RecoveryCode: r.GetRecoveryCode(),
+	})
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	return g.loginResponse(result)
+}
+
+func (g *grpcUserHandler) loginResponse(result *domain.LoginResult) (*user_proto.LoginResponse, error) {
+	user, err := g.userResponse(result.User)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &user_proto.LoginResponse{User: user, AccessToken: result.AccessToken}
+	if result.ExpiresAt != nil {
+		resp.ExpiresAt = timestamppb.New(*result.ExpiresAt)
+	}
+
+	return resp, nil
+}
+
+func (g *grpcUserHandler) EnrollTOTP(ctx context.Context, r *user_proto.EnrollTOTPRequest) (*user_proto.EnrollTOTPResponse, error) {
+	enrollment, err := g.userService.EnrollTOTP(ctx, &domain.Credentials{
+		Login:    r.GetLogin(),
+		Password: r.GetPassword(),
+	})
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	return &user_proto.EnrollTOTPResponse{Uri: enrollment.URI, QrPng: enrollment.QRPNG}, nil
+}
+
+func (g *grpcUserHandler) ConfirmTOTP(ctx context.Context, r *user_proto.ConfirmTOTPRequest) (*user_proto.ConfirmTOTPResponse, error) {
+	recoveryCodes, err := g.userService.ConfirmTOTP(ctx, &domain.Credentials{
+		Login:    r.GetLogin(),
+		Password: r.GetPassword(),
+	}, r.GetCode())
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	g.logger.Infow("TOTP enabled", "login", r.GetLogin())
+
+	return &user_proto.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// errInternal replaces the messages of unexpected errors sent to clients.
+var errInternal = errors.New("internal error")
+
+// grpcAuthError maps authorization, login, two-factor and account recovery
+// errors to gRPC statuses.
+func grpcAuthError(ctx context.Context, logger *zap.SugaredLogger, login string, err error) error {
+	var locked *service.LockedError
+	switch {
+	case errors.As(err, &locked):
+		logger.Infow("Login to locked account", "login", login)
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(locked.Until)))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, service.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, service.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrMFARequired),
+		errors.Is(err, service.ErrInvalidAPIKey),
+		errors.Is(err, service.ErrInvalidCode),
+		errors.Is(err, service.ErrInvalidMFAToken),
+		errors.Is(err, service.ErrInvalidToken),
+		errors.Is(err, auth.ErrInvalidToken):
+		logger.Infow("Failed login", "login", login, "err", err)
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrTOTPNotEnrolled),
+		errors.Is(err, service.ErrNoEmail),
+		errors.Is(err, service.ErrEmailAlreadyVerified),
+		errors.Is(err, service.ErrVersionRequired),
+		errors.Is(err, service.ErrIdempotencyKeyReused),
+		errors.Is(err, service.ErrIdempotencyKeyInUse):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrUserAlreadyExists), errors.Is(err, service.ErrEmailAlreadyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrVersionMismatch):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrEmptyPassword),
+		errors.Is(err, service.ErrUnknownRole),
+		errors.Is(err, service.ErrInvalidScope),
+		errors.Is(err, service.ErrInvalidExpiry),
+		errors.Is(err, service.ErrInvalidWebhookURL),
+		errors.Is(err, service.ErrPrivateWebhookURL),
+		errors.Is(err, service.ErrInvalidEventType),
+		errors.Is(err, service.ErrInvalidStatus),
+		errors.Is(err, service.ErrInvalidIdempotencyKey),
+		errors.Is(err, service.ErrIdempotencyKeyCaller):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrAPIKeyNotFound),
+		errors.Is(err, service.ErrWebhookNotFound),
+		errors.Is(err, service.ErrDeliveryNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, watch.ErrRevisionUnavailable):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, watch.ErrLagging):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, watch.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, service.ErrMFADisabled), errors.Is(err, service.ErrMailDisabled), errors.Is(err, watch.ErrDisabled):
+		return status.Error(codes.Unimplemented, err.Error())
+	default:
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		// other errors are internal, their messages stay in the log
+		logger.Warnw("Error authenticating user", "err", err)
+		return status.Error(codes.Internal, errInternal.Error())
+	}
+}
+
+func (g *grpcUserHandler) SendVerification(
+	ctx context.Context, r *user_proto.SendVerificationRequest,
+) (*user_proto.SendVerificationResponse, error) {
+	if err := g.userService.SendVerification(ctx, r.GetLogin()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	return &user_proto.SendVerificationResponse{}, nil
+}
+
+func (g *grpcUserHandler) VerifyEmail(ctx context.Context, r *user_proto.VerifyEmailRequest) (*user_proto.GetUserResponse, error) {
+	user, err := g.userService.VerifyEmail(ctx, r.GetToken())
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	g.logger.Infow("Email verified", "login", user.Login)
+
+	return g.userResponse(user)
+}
+
+func (g *grpcUserHandler) RequestPasswordReset(
+	ctx context.Context, r *user_proto.RequestPasswordResetRequest,
+) (*user_proto.RequestPasswordResetResponse, error) {
+	if err := g.userService.RequestPasswordReset(ctx, r.GetEmail()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	return &user_proto.RequestPasswordResetResponse{}, nil
+}
+
+func (g *grpcUserHandler) ResetPassword(ctx context.Context, r *user_proto.ResetPasswordRequest) (*user_proto.ResetPasswordResponse, error) {
+	err := g.userService.ResetPassword(ctx, &domain.PasswordReset{Token: r.GetToken(), Password: r.GetPassword()})
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	return &user_proto.ResetPasswordResponse{}, nil
+}
+
+func (g *grpcUserHandler) Delete(ctx context.Context, r *user_proto.DeleteRequest) (*user_proto.DeleteResponse, error) {
+	if err := g.userService.DeleteUser(ctx, r.GetLogin()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	g.logger.Infow("User deleted", "login", r.GetLogin())
+
+	return &user_proto.DeleteResponse{}, nil
+}
+
+func (g *grpcUserHandler) Unlock(ctx context.Context, r *user_proto.UnlockRequest) (*user_proto.UnlockResponse, error) {
+	if err := g.userService.UnlockUser(ctx, r.GetLogin()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	g.logger.Infow("User unlocked", "login", r.GetLogin())
+
+	return &user_proto.UnlockResponse{}, nil
+}
+
+func (g *grpcUserHandler) GetRoles(ctx context.Context, r *user_proto.GetRolesRequest) (*user_proto.RolesResponse, error) {
+	roles, err := g.userService.GetRoles(ctx, r.GetLogin())
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	return &user_proto.RolesResponse{Roles: roles}, nil
+}
+
+func (g *grpcUserHandler) SetRoles(ctx context.Context, r *user_proto.SetRolesRequest) (*user_proto.RolesResponse, error) {
+	roles, err := g.userService.SetRoles(ctx, r.GetLogin(), r.GetRoles())
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, r.GetLogin(), err)
+	}
+
+	g.logger.Infow("Roles changed", "login", r.GetLogin(), "roles", roles)
+
+	return &user_proto.RolesResponse{Roles: roles}, nil
+}
+
+func (g *grpcUserHandler) CreateAPIKey(ctx context.Context, r *user_proto.CreateAPIKeyRequest) (*user_proto.APIKey, error) {
+	in := domain.APIKeyIn{Name: r.GetName(), Scopes: r.GetScopes()}
+	if r.GetExpiresAt() != nil {
+		expiresAt := r.GetExpiresAt().AsTime()
+		in.ExpiresAt = &expiresAt
+	}
+
+	key, err := g.apiKeyService.CreateAPIKey(ctx, &in)
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	g.logger.Infow("API key created", "id", key.ID, "scopes", key.Scopes)
+
+	return apiKeyResponse(key), nil
+}
+
+func (g *grpcUserHandler) ListAPIKeys(ctx context.Context, _ *user_proto.ListAPIKeysRequest) (*user_proto.ListAPIKeysResponse, error) {
+	keys, err := g.apiKeyService.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	resp := &user_proto.ListAPIKeysResponse{ApiKeys: make([]*user_proto.APIKey, 0, len(keys))}
+	for i := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyResponse(&keys[i]))
+	}
+
+	return resp, nil
+}
+
+func (g *grpcUserHandler) RevokeAPIKey(ctx context.Context, r *user_proto.RevokeAPIKeyRequest) (*user_proto.RevokeAPIKeyResponse, error) {
+	if err := g.apiKeyService.RevokeAPIKey(ctx, r.GetId()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	g.logger.Infow("API key revoked", "id", r.GetId())
+
+	return &user_proto.RevokeAPIKeyResponse{}, nil
+}
+
+func (g *grpcUserHandler) ListAuditEvents(
+	ctx context.Context, r *user_proto.ListAuditEventsRequest,
+) (*user_proto.ListAuditEventsResponse, error) {
+	filter := &domain.AuditFilter{
+		Actor:     r.GetActor(),
+		Action:    r.GetAction(),
+		Login:     r.GetLogin(),
+		RequestID: r.GetRequestId(),
+		AfterID:   r.GetAfterId(),
+		Limit:     int(r.GetLimit()),
+	}
+	if len(r.GetUserId()) > 0 {
+		userID, err := uuid.FromBytes(r.GetUserId())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid user_id")
+		}
+		filter.UserID = &userID
+	}
+	if r.GetSince() != nil {
+		since := r.GetSince().AsTime()
+		filter.Since = &since
+	}
+	if r.GetUntil() != nil {
+		until := r.GetUntil().AsTime()
+		filter.Until = &until
+	}
+
+	events, err := g.auditService.ListAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	resp := &user_proto.ListAuditEventsResponse{Events: make([]*user_proto.AuditEvent, 0, len(events))}
+	for i := range events {
+		event, err := auditEventResponse(&events[i])
+		if err != nil {
+			g.logger.Warnw("Error marshaling audit event", "err", err)
+			return nil, err
+		}
+		resp.Events = append(resp.Events, event)
+	}
+
+	return resp, nil
+}
+
+func auditEventResponse(event *domain.AuditEvent) (*user_proto.AuditEvent, error) {
+	userID, err := event.UserID.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := json.Marshal(event.Diff)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user_proto.AuditEvent{
+		Id:        event.ID,
+		CreatedAt: timestamppb.New(event.CreatedAt),
+		Actor:     event.Actor,
+		Action:    event.Action,
+		UserId:    userID,
+		Login:     event.Login,
+		RequestId: event.RequestID,
+		ClientIp:  event.ClientIP,
+		Diff:      string(diff),
+	}, nil
+}
+
+func (g *grpcUserHandler) CreateWebhook(ctx context.Context, r *user_proto.CreateWebhookRequest) (*user_proto.Webhook, error) {
+	webhook, err := g.webhookService.CreateWebhook(ctx, &domain.WebhookIn{URL: r.GetUrl(), Events: r.GetEvents()})
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	g.logger.Infow("Webhook created", "id", webhook.ID, "url", webhook.URL, "events", webhook.Events)
+
+	return webhookResponse(webhook), nil
+}
+
+func (g *grpcUserHandler) ListWebhooks(ctx context.Context, _ *user_proto.ListWebhooksRequest) (*user_proto.ListWebhooksResponse, error) {
+	webhooks, err := g.webhookService.ListWebhooks(ctx)
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	resp := &user_proto.ListWebhooksResponse{Webhooks: make([]*user_proto.Webhook, 0, len(webhooks))}
+	for i := range webhooks {
+		resp.Webhooks = append(resp.Webhooks, webhookResponse(&webhooks[i]))
+	}
+
+	return resp, nil
+}
+
+func (g *grpcUserHandler) DeleteWebhook(ctx context.Context, r *user_proto.DeleteWebhookRequest) (*user_proto.DeleteWebhookResponse, error) {
+	if err := g.webhookService.DeleteWebhook(ctx, r.GetId()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	g.logger.Infow("Webhook deleted", "id", r.GetId())
+
+	return &user_proto.DeleteWebhookResponse{}, nil
+}
+
+func (g *grpcUserHandler) ListWebhookDeliveries(
+	ctx context.Context, r *user_proto.ListWebhookDeliveriesRequest,
+) (*user_proto.ListWebhookDeliveriesResponse, error) {
+	deliveries, err := g.webhookService.ListDeliveries(ctx, r.GetWebhookId(), r.GetStatus(), int(r.GetLimit()))
+	if err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	resp := &user_proto.ListWebhookDeliveriesResponse{
+		Deliveries: make([]*user_proto.WebhookDelivery, 0, len(deliveries)),
+	}
+	for i := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, deliveryResponse(&deliveries[i]))
+	}
+
+	return resp, nil
+}
+
+func (g *grpcUserHandler) RetryWebhookDelivery(
+	ctx context.Context, r *user_proto.RetryWebhookDeliveryRequest,
+) (*user_proto.RetryWebhookDeliveryResponse, error) {
+	if err := g.webhookService.RetryDelivery(ctx, r.GetWebhookId(), r.GetId()); err != nil {
+		return nil, grpcAuthError(ctx, g.logger, "", err)
+	}
+
+	g.logger.Infow("Webhook delivery retried", "webhook_id", r.GetWebhookId(), "id", r.GetId())
+
+	return &user_proto.RetryWebhookDeliveryResponse{}, nil
+}
+
+func webhookResponse(webhook *domain.WebhookOut) *user_proto.Webhook {
+	return &user_proto.Webhook{
+		Id:        webhook.ID,
+		Url:       webhook.URL,
+		Events:    webhook.Events,
+		CreatedAt: timestamppb.New(webhook.CreatedAt),
+		Secret:    webhook.Secret,
+	}
+}
+
+func deliveryResponse(delivery *domain.WebhookDelivery) *user_proto.WebhookDelivery {
+	resp := &user_proto.WebhookDelivery{
+		Id:           delivery.ID,
+		WebhookId:    delivery.WebhookID,
+		EventId:      delivery.EventID,
+		EventType:    delivery.EventType,
+		Status:       delivery.Status,
+		Attempts:     int32(delivery.Attempts),
+		CreatedAt:    timestamppb.New(delivery.CreatedAt),
+		ResponseCode: int32(delivery.ResponseCode),
+		LastError:    delivery.LastError,
+	}
+
+	if delivery.NextAttemptAt != nil {
+		resp.NextAttemptAt = timestamppb.New(*delivery.NextAttemptAt)
+	}
+	if delivery.LastAttemptAt != nil {
+		resp.LastAttemptAt = timestamppb.New(*delivery.LastAttemptAt)
+	}
+	if delivery.DeliveredAt != nil {
+		resp.DeliveredAt = timestamppb.New(*delivery.DeliveredAt)
+	}
+
+	return resp
+}
+
+func apiKeyResponse(key *domain.APIKeyOut) *user_proto.APIKey {
+	resp := &user_proto.APIKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+		Key:       key.Key,
+	}
+
+	if key.ExpiresAt != nil {
+		resp.ExpiresAt = timestamppb.New(*key.ExpiresAt)
+	}
+	if key.LastUsedAt != nil {
+		resp.LastUsedAt = timestamppb.New(*key.LastUsedAt)
+	}
+	if key.RevokedAt != nil {
+		resp.RevokedAt = timestamppb.New(*key.RevokedAt)
+	}
+
+	return resp
+}
+
+func (g *grpcUserHandler) userResponse(user *domain.UserOut) (*user_proto.GetUserResponse, error) {
+	resp, err := toUserProto(user)
 	if err != nil {
 		g.logger.Warnw("Error marshaling user id", "err", err)
 		return nil, err
 	}
 
-	return &user_proto.GetUserResponse{
+	return resp, nil
+}
+
+func toUserProto(user *domain.UserOut) (*user_proto.GetUserResponse, error) {
+	id, err := user.ID.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &user_proto.GetUserResponse{
 		Id:        id,
 		Login:     user.Login,
 		Name:      user.Name,
 		CreatedAt: timestamppb.New(user.CreatedAt),
 		UpdatedAt: timestamppb.New(user.UpdatedAt),
-	}, nil
+		Email:     user.Email,
+		Roles:     user.Roles,
+		Version:   user.Version,
+		Etag:      etag(user.Version),
+	}
+
+	if user.EmailVerifiedAt != nil {
+		resp.EmailVerifiedAt = timestamppb.New(*user.EmailVerifiedAt)
+	}
+
+	if user.Lockout != nil {
+		resp.Lockout = &user_proto.Lockout{FailedAttempts: int32(user.Lockout.FailedAttempts)}
+		if user.Lockout.LockedUntil != nil {
+			resp.Lockout.LockedUntil = timestamppb.New(*user.Lockout.LockedUntil)
+		}
+	}
+
+	return resp, nil
+}
+
+// WatchUsers sends events until the client goes away or the watcher is
+// disconnected.
+func (g *grpcUserHandler) WatchUsers(r *user_proto.WatchUsersRequest, stream grpc.ServerStreamingServer[user_proto.UserEvent]) error {
+	ctx := stream.Context()
+
+	watcher, err := g.watchService.WatchUsers(ctx, r.Revision)
+	if err != nil {
+		return grpcAuthError(ctx, g.logger, "", err)
+	}
+	defer watcher.Close()
+
+	for {
+		event, err := watcher.Next(ctx)
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if err != nil {
+			g.logger.Infow("Watch ended", "err", err)
+			return grpcAuthError(ctx, g.logger, "", err)
+		}
+
+		if err := stream.Send(userEventResponse(event)); err != nil {
+			return err
+		}
+	}
+}
+
+func userEventResponse(event *domain.Event) *user_proto.UserEvent {
+	return &user_proto.UserEvent{
+		Revision:   event.ID,
+		Id:         event.Key,
+		Type:       event.Type,
+		OccurredAt: timestamppb.New(event.OccurredAt),
+		User: &user_proto.EventUser{
+			Id:            event.User.ID[:],
+			Login:         event.User.Login,
+			Name:          event.User.Name,
+			Email:         event.User.Email,
+			EmailVerified: event.User.EmailVerified,
+			Roles:         event.User.Roles,
+		},
+		Changed: event.Changed,
+	}
+}
+
+// BulkCreate imports rows as they arrive, a batch is created once it is
+// full.
+func (g *grpcUserHandler) BulkCreate(stream grpc.ClientStreamingServer[user_proto.BulkCreateRequest, user_proto.BulkCreateResponse]) error {
+	ctx := stream.Context()
+
+	var (
+		resp   user_proto.BulkCreateResponse
+		batch  = make([]domain.UserImport, 0, service.ImportBatchSize)
+		dryRun bool
+	)
+
+	flush := func() error {
+		results, err := g.bulkService.ImportUsers(ctx, batch, dryRun)
+		if err != nil {
+			return grpcAuthError(ctx, g.logger, "", err)
+		}
+
+		for i := range results {
+			resp.Results = append(resp.Results, importResultResponse(&results[i]))
+		}
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for row := 1; ; row++ {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if row == 1 {
+			dryRun = r.GetDryRun()
+		}
+		batch = append(batch, domain.UserImport{
+			Row:          row,
+			Login:        r.GetLogin(),
+			Password:     r.GetPassword(),
+			PasswordHash: r.GetPasswordHash(),
+			Name:         r.GetName(),
+			Email:        r.GetEmail(),
+		})
+
+		if len(batch) == service.ImportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&resp)
+}
+
+func importResultResponse(result *domain.ImportResult) *user_proto.ImportResult {
+	resp := &user_proto.ImportResult{
+		Row:    int32(result.Row),
+		Login:  result.Login,
+		Status: result.Status,
+		Error:  result.Error,
+	}
+	if result.ID != nil {
+		resp.Id = result.ID[:]
+	}
+
+	return resp
+}
+
+func (g *grpcUserHandler) Export(r *user_proto.ExportRequest, stream grpc.ServerStreamingServer[user_proto.ExportedUser]) error {
+	ctx := stream.Context()
+
+	var after string
+	for {
+		users, err := g.bulkService.ExportUsers(ctx, after, service.MaxExportUsers, r.GetPasswordHashes())
+		if err != nil {
+			return grpcAuthError(ctx, g.logger, "", err)
+		}
+
+		for i := range users {
+			if err := stream.Send(exportedUserResponse(&users[i])); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < service.MaxExportUsers {
+			return nil
+		}
+		after = users[len(users)-1].Login
+	}
+}
+
+func exportedUserResponse(user *domain.UserExport) *user_proto.ExportedUser {
+	resp := &user_proto.ExportedUser{
+		Id:           user.ID[:],
+		Login:        user.Login,
+		Name:         user.Name,
+		Email:        user.Email,
+		CreatedAt:    timestamppb.New(user.CreatedAt),
+		UpdatedAt:    timestamppb.New(user.UpdatedAt),
+		PasswordHash: user.PasswordHash,
+	}
+	if user.EmailVerifiedAt != nil {
+		resp.EmailVerifiedAt = timestamppb.New(*user.EmailVerifiedAt)
+	}
+
+	return resp
 }
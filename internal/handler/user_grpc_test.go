@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	user_proto "github.com/iliadmitriev/go-user-test/internal/server/grpc/user/v1"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+// newTestGRPCClient serves the handlers behind the authenticator the way
+// the gRPC server does and returns a client.
+func newTestGRPCClient(
+	t *testing.T,
+	userService service.UserServiceInterface,
+	apiKeyService service.APIKeyServiceInterface,
+	obs *config.Observer,
+	handlers ...GRPCHandler,
+) user_proto.UserServiceClient {
+	t.Helper()
+
+	authenticator := NewAuthenticator(userService, apiKeyService, obs, zap.NewNop())
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(authenticator.StreamInterceptor),
+	)
+	for _, handler := range handlers {
+		handler.RegisterGRPC(srv)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return user_proto.NewUserServiceClient(conn)
+}
+
+func Test_grpcUserHandler_Update(t *testing.T) {
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+	userService := service.NewUserService(repository.NewUserDB(dbtest.New(t)), nil, nil, obs)
+	client := newTestGRPCClient(t, userService, nil, obs,
+		NewGRPCUserHandler(userService, nil, nil, nil, nil, nil, zap.NewNop()))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer admin-secret")
+
+	_, err := client.Create(ctx, &user_proto.CreateRequest{Login: "alice", Name: "Alice", Password: "secret"})
+	require.NoError(t, err)
+
+	_, err = client.Update(ctx, &user_proto.UpdateRequest{Login: "alice", Name: proto.String("Alicia")})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "expected_version is required")
+
+	resp, err := client.Update(ctx, &user_proto.UpdateRequest{Login: "alice", Name: proto.String("Alicia"), ExpectedVersion: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "Alicia", resp.GetName())
+	assert.Equal(t, int64(2), resp.GetVersion())
+
+	_, err = client.Update(ctx, &user_proto.UpdateRequest{Login: "alice", Name: proto.String("Alice"), ExpectedVersion: 1})
+	assert.Equal(t, codes.Aborted, status.Code(err), "lost update")
+
+	_, err = client.Update(ctx, &user_proto.UpdateRequest{Login: "bob", Name: proto.String("Bob"), ExpectedVersion: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func Test_grpcUserHandler_Delete(t *testing.T) {
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret", AllowSignup: true}, fxtest.NewLifecycle(t), zap.NewNop())
+	userService := service.NewUserService(repository.NewUserDB(dbtest.New(t)), nil, nil, obs)
+	client := newTestGRPCClient(t, userService, nil, obs,
+		NewGRPCUserHandler(userService, nil, nil, nil, nil, nil, zap.NewNop()))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer admin-secret")
+
+	_, err := client.Create(t.Context(), &user_proto.CreateRequest{Login: "alice", Name: "Alice", Password: "secret"})
+	require.NoError(t, err)
+
+	alice := metadata.AppendToOutgoingContext(t.Context(), "authorization", basicAuth("alice", "secret"))
+	_, err = client.Delete(alice, &user_proto.DeleteRequest{Login: "alice"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "admin only")
+
+	_, err = client.Delete(ctx, &user_proto.DeleteRequest{Login: "alice"})
+	require.NoError(t, err)
+
+	_, err = client.GetByLogin(ctx, &user_proto.GetByLoginRequest{Login: "alice"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Delete(ctx, &user_proto.DeleteRequest{Login: "alice"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func Test_grpcUserHandler_apiKey(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+	users := repository.NewUserDB(sqlite)
+	userService := service.NewUserService(users, nil, nil, obs)
+	apiKeyService := service.NewAPIKeyService(repository.NewAPIKeyDB(sqlite), zap.NewNop())
+	client := newTestGRPCClient(t, userService, apiKeyService, obs,
+		NewGRPCUserHandler(userService, apiKeyService, nil, nil, nil, service.NewBulkService(users), zap.NewNop()))
+
+	admin := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer admin-secret")
+
+	_, err := client.Create(admin, &user_proto.CreateRequest{Login: "alice", Name: "Alice", Password: "secret"})
+	require.NoError(t, err)
+
+	key, err := client.CreateAPIKey(admin, &user_proto.CreateAPIKeyRequest{Name: "billing", Scopes: []string{"user:read"}})
+	require.NoError(t, err)
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(t.Context(), "x-api-key", key)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		resp, err := client.GetByLogin(withKey(key.GetKey()), &user_proto.GetByLoginRequest{Login: "alice"})
+		require.NoError(t, err)
+		assert.Equal(t, "Alice", resp.GetName())
+
+		_, err = client.Create(withKey(key.GetKey()), &user_proto.CreateRequest{Login: "bob", Name: "Bob", Password: "secret"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "out of scope")
+
+		// streams are authenticated by the stream interceptor
+		stream, err := client.Export(withKey(key.GetKey()), &user_proto.ExportRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "out of scope")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, invalid := range []string{"gu_" + key.GetId() + "_WRONG", "gu_unknown_secret", "not-a-key"} {
+			_, err := client.GetByLogin(withKey(invalid), &user_proto.GetByLoginRequest{Login: "alice"})
+			assert.Equal(t, codes.Unauthenticated, status.Code(err), invalid)
+		}
+
+		stream, err := client.Export(withKey("gu_"+key.GetId()+"_WRONG"), &user_proto.ExportRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		_, err := client.RevokeAPIKey(admin, &user_proto.RevokeAPIKeyRequest{Id: key.GetId()})
+		require.NoError(t, err)
+
+		_, err = client.GetByLogin(withKey(key.GetKey()), &user_proto.GetByLoginRequest{Login: "alice"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
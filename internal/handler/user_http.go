@@ -3,17 +3,28 @@ package handler
 import (
 	"encoding/json"
 	"errors"
-	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/iliadmitriev/go-user-test/internal/auth"
 	"github.com/iliadmitriev/go-user-test/internal/domain"
 	"github.com/iliadmitriev/go-user-test/internal/service"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
 )
 
 type HTTPHandler interface {
-	GetMux(mux *http.ServeMux)
+	GetMux(mux Router)
+}
+
+// Router is the part of *http.ServeMux the handlers register their routes
+// with.
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
 }
 
 type userHandler struct {
@@ -26,70 +37,361 @@ type errorJSON struct {
 	Code    int    `json:"code"`
 }
 
-func (userhandler *userHandler) GetMux(mux *http.ServeMux) {
-	mux.HandleFunc("/user/", userhandler.postUser)
+func (userhandler *userHandler) GetMux(mux Router) {
+	mux.Handle("/user/", Public(userhandler.postUser))
 	mux.HandleFunc("/user/{login}", userhandler.getUser)
+	mux.HandleFunc("PATCH /user/{login}", userhandler.updateUser)
+	mux.Handle("POST /login", Public(userhandler.login))
+	mux.Handle("POST /login/mfa", Public(userhandler.loginMFA))
+	mux.Handle("POST /user/{login}/totp", Public(userhandler.enrollTOTP))
+	mux.Handle("POST /user/{login}/totp/confirm", Public(userhandler.confirmTOTP))
+	mux.HandleFunc("POST /user/{login}/email/verify", userhandler.sendVerification)
+	mux.Handle("POST /email/verify", Public(userhandler.verifyEmail))
+	mux.Handle("POST /password/reset", Public(userhandler.requestPasswordReset))
+	mux.Handle("POST /password/reset/confirm", Public(userhandler.resetPassword))
 }
 
 func (userhandler *userHandler) postUser(w http.ResponseWriter, r *http.Request) {
 	var userIn domain.UserIn
-	if r.Body == nil {
-		serveErrorJSON(w, http.StatusBadRequest, errors.New("empty request body"))
+	if !decodeJSON(w, r, &userIn) {
+		return
+	}
+
+	userhandler.logger.Infow("Got request", "login", userIn.Login, "name", userIn.Name)
+
+	userIn.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	user, err := userhandler.userService.CreateUser(r.Context(), &userIn)
+	if err != nil {
+		serveAuthError(w, userhandler.logger, userIn.Login, err)
+		return
+	}
+
+	serveUser(w, r, user, http.StatusCreated)
+}
+
+func (userhandler *userHandler) getUser(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	ctx := r.Context()
+
+	user, err := userhandler.userService.GetUser(ctx, login)
+
+	userhandler.logger.Infow("Got request", "login", login)
+
+	if err != nil {
+		serveAuthError(w, userhandler.logger, login, err)
 		return
 	}
-	body, errRead := io.ReadAll(r.Body)
-	if errRead != nil {
-		serveErrorJSON(w, http.StatusBadRequest, errRead)
+
+	serveUser(w, r, user, http.StatusOK)
+}
+
+func (userhandler *userHandler) updateUser(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	var update domain.UserUpdate
+	if !decodeJSON(w, r, &update) {
 		return
 	}
 
-	userhandler.logger.Infow("Got request", "body", string(body))
+	version, ok := userhandler.ifMatchVersion(w, r, login)
+	if !ok {
+		return
+	}
 
-	if err := json.Unmarshal(body, &userIn); err != nil {
+	user, err := userhandler.userService.UpdateUser(r.Context(), login, &update, version)
+	if err != nil {
+		serveAuthError(w, userhandler.logger, login, err)
+		return
+	}
+
+	serveUser(w, r, user, http.StatusOK)
+}
+
+// ifMatchVersion returns the version the update is based on, 0 without
+// If-Match. A single tag is passed on as is and checked along with the
+// update, "*" and lists are evaluated against the current version.
+func (userhandler *userHandler) ifMatchVersion(w http.ResponseWriter, r *http.Request, login string) (int64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	if version, ok := parseETag(header); ok {
+		return version, true
+	}
+
+	user, err := userhandler.userService.GetUser(r.Context(), login)
+	if err != nil {
+		serveAuthError(w, userhandler.logger, login, err)
+		return 0, false
+	}
+
+	if !ifMatch(header, user.Version) {
+		serveErrorJSON(w, http.StatusPreconditionFailed, service.ErrVersionMismatch)
+		return 0, false
+	}
+
+	return user.Version, true
+}
+
+// loginJSON is the logged in user along with the access token.
+type loginJSON struct {
+	*domain.UserOut
+
+	AccessToken string     `json:"access_token,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func toLoginJSON(result *domain.LoginResult) loginJSON {
+	return loginJSON{UserOut: result.User, AccessToken: result.AccessToken, ExpiresAt: result.ExpiresAt}
+}
+
+type mfaChallengeJSON struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type totpConfirmJSON struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type recoveryCodesJSON struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (userhandler *userHandler) login(w http.ResponseWriter, r *http.Request) {
+	var credentials domain.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
 		serveErrorJSON(w, http.StatusBadRequest, err)
 		return
 	}
 
-	user, err := userhandler.userService.CreateUser(r.Context(), &userIn)
+	result, err := userhandler.userService.Login(r.Context(), &credentials)
+	if err != nil {
+		serveAuthError(w, userhandler.logger, credentials.Login, err)
+		return
+	}
+
+	if result.MFAToken != "" {
+		serveJSON(w, mfaChallengeJSON{MFARequired: true, MFAToken: result.MFAToken}, http.StatusOK)
+		return
+	}
+
+	serveJSON(w, toLoginJSON(result), http.StatusOK)
+}
+
+func (userhandler *userHandler) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var code domain.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := userhandler.userService.LoginMFA(r.Context(), &code)
 	if err != nil {
+		serveAuthError(w, userhandler.logger, "", err)
+		return
+	}
+
+	serveJSON(w, toLoginJSON(result), http.StatusOK)
+}
+
+func (userhandler *userHandler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	var credentials domain.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
 		serveErrorJSON(w, http.StatusBadRequest, err)
 		return
 	}
+	credentials.Login = r.PathValue("login")
+
+	enrollment, err := userhandler.userService.EnrollTOTP(r.Context(), &credentials)
+	if err != nil {
+		serveAuthError(w, userhandler.logger, credentials.Login, err)
+		return
+	}
 
-	serveJSON(w, user, http.StatusCreated)
+	serveJSON(w, enrollment, http.StatusCreated)
 }
 
-func (userhandler *userHandler) getUser(w http.ResponseWriter, r *http.Request) {
-	login := r.PathValue("login")
+func (userhandler *userHandler) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var confirm totpConfirmJSON
+	if err := json.NewDecoder(r.Body).Decode(&confirm); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
 
-	ctx := r.Context()
+	credentials := domain.Credentials{Login: r.PathValue("login"), Password: confirm.Password}
 
-	user, err := userhandler.userService.GetUser(ctx, login)
-	if errors.Is(err, service.ErrUserNotFound) {
-		serveErrorJSON(w, http.StatusNotFound, err)
+	codes, err := userhandler.userService.ConfirmTOTP(r.Context(), &credentials, confirm.Code)
+	if err != nil {
+		serveAuthError(w, userhandler.logger, credentials.Login, err)
 		return
 	}
 
-	userhandler.logger.Infow("Got request", "login", login)
+	userhandler.logger.Infow("TOTP enabled", "login", credentials.Login)
+	serveJSON(w, recoveryCodesJSON{RecoveryCodes: codes}, http.StatusOK)
+}
+
+type tokenJSON struct {
+	Token string `json:"token"`
+}
+
+type emailJSON struct {
+	Email string `json:"email"`
+}
+
+func (userhandler *userHandler) sendVerification(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+
+	if err := userhandler.userService.SendVerification(r.Context(), login); err != nil {
+		serveAuthError(w, userhandler.logger, login, err)
+		return
+	}
 
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (userhandler *userHandler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var token tokenJSON
+	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := userhandler.userService.VerifyEmail(r.Context(), token.Token)
 	if err != nil {
-		serveErrorJSON(w, http.StatusInternalServerError, err)
+		serveAuthError(w, userhandler.logger, "", err)
 		return
 	}
 
+	userhandler.logger.Infow("Email verified", "login", user.Login)
 	serveJSON(w, user, http.StatusOK)
 }
 
+// requestPasswordReset answers 202 whether the address is registered or not.
+func (userhandler *userHandler) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var email emailJSON
+	if err := json.NewDecoder(r.Body).Decode(&email); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := userhandler.userService.RequestPasswordReset(r.Context(), email.Email); err != nil {
+		serveAuthError(w, userhandler.logger, "", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (userhandler *userHandler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var reset domain.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := userhandler.userService.ResetPassword(r.Context(), &reset); err != nil {
+		serveAuthError(w, userhandler.logger, "", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAuthError maps authorization, login, two-factor, passkey and
+// account recovery errors to responses.
+func serveAuthError(w http.ResponseWriter, logger *zap.SugaredLogger, login string, err error) {
+	var locked *service.LockedError
+	switch {
+	case errors.As(err, &locked):
+		logger.Infow("Login to locked account", "login", login)
+		w.Header().Set("Retry-After", retryAfter(locked.Until))
+		serveErrorJSON(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, service.ErrPasskeyRejected):
+		// details of failed ceremonies are logged only
+		logger.Infow("Failed login", "login", login, "err", err)
+		serveErrorJSON(w, http.StatusUnauthorized, service.ErrPasskeyRejected)
+	case errors.Is(err, service.ErrUnauthenticated):
+		w.Header().Add("WWW-Authenticate", `Bearer realm="go-user"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="go-user"`)
+		serveErrorJSON(w, http.StatusUnauthorized, err)
+	case errors.Is(err, service.ErrForbidden):
+		serveErrorJSON(w, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrMFARequired),
+		errors.Is(err, service.ErrInvalidAPIKey),
+		errors.Is(err, service.ErrInvalidCode),
+		errors.Is(err, service.ErrInvalidMFAToken),
+		errors.Is(err, service.ErrInvalidCeremony),
+		errors.Is(err, service.ErrPasskeyCloned),
+		errors.Is(err, service.ErrInvalidToken),
+		errors.Is(err, auth.ErrInvalidToken):
+		logger.Infow("Failed login", "login", login, "err", err)
+		serveErrorJSON(w, http.StatusUnauthorized, err)
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrTOTPNotEnrolled),
+		errors.Is(err, service.ErrPasskeyAlreadyExists),
+		errors.Is(err, service.ErrNoEmail),
+		errors.Is(err, service.ErrEmailAlreadyVerified),
+		errors.Is(err, service.ErrEmailAlreadyExists),
+		errors.Is(err, service.ErrUserAlreadyExists),
+		errors.Is(err, service.ErrIdempotencyKeyReused),
+		errors.Is(err, service.ErrIdempotencyKeyInUse):
+		serveErrorJSON(w, http.StatusConflict, err)
+	case errors.Is(err, service.ErrVersionMismatch):
+		serveErrorJSON(w, http.StatusPreconditionFailed, err)
+	case errors.Is(err, service.ErrVersionRequired):
+		serveErrorJSON(w, http.StatusPreconditionRequired, err)
+	case errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrEmptyPassword),
+		errors.Is(err, service.ErrUnknownRole),
+		errors.Is(err, service.ErrInvalidScope),
+		errors.Is(err, service.ErrInvalidExpiry),
+		errors.Is(err, service.ErrInvalidWebhookURL),
+		errors.Is(err, service.ErrPrivateWebhookURL),
+		errors.Is(err, service.ErrInvalidEventType),
+		errors.Is(err, service.ErrInvalidStatus),
+		errors.Is(err, service.ErrInvalidIdempotencyKey),
+		errors.Is(err, service.ErrIdempotencyKeyCaller):
+		serveErrorJSON(w, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrPasskeyNotFound),
+		errors.Is(err, service.ErrAPIKeyNotFound),
+		errors.Is(err, service.ErrWebhookNotFound),
+		errors.Is(err, service.ErrDeliveryNotFound):
+		serveErrorJSON(w, http.StatusNotFound, err)
+	case errors.Is(err, watch.ErrRevisionUnavailable):
+		serveErrorJSON(w, http.StatusGone, err)
+	case errors.Is(err, watch.ErrClosed):
+		serveErrorJSON(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, service.ErrMFADisabled),
+		errors.Is(err, service.ErrPasskeysDisabled),
+		errors.Is(err, service.ErrMailDisabled),
+		errors.Is(err, watch.ErrDisabled):
+		serveErrorJSON(w, http.StatusNotImplemented, err)
+	default:
+		serveErrorJSON(w, http.StatusInternalServerError, err)
+	}
+}
+
+// retryAfter formats the number of seconds until t for Retry-After.
+func retryAfter(t time.Time) string {
+	return strconv.Itoa(max(1, int(math.Ceil(time.Until(t).Seconds()))))
+}
+
 func serveJSON(w http.ResponseWriter, v any, code int) {
+	w.Header().Set("Content-Type", mediaTypeJSON)
 	w.WriteHeader(code)
-	w.Header().Set("Content-Type", "application/json")
 	encoder := json.NewEncoder(w)
 	_ = encoder.Encode(v)
 }
 
 func serveErrorJSON(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", mediaTypeJSON)
 	w.WriteHeader(code)
-	w.Header().Set("Content-Type", "application/json")
 	encoder := json.NewEncoder(w)
 	_ = encoder.Encode(errorJSON{Message: err.Error(), Code: code})
 }
@@ -1,23 +1,42 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/brianvoe/gofakeit/v7"
 	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
 	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/mailer"
 	"github.com/iliadmitriev/go-user-test/internal/mocks"
 	"github.com/iliadmitriev/go-user-test/internal/repository"
 	"github.com/iliadmitriev/go-user-test/internal/service"
@@ -30,6 +49,7 @@ type fakeUser struct {
 	Name      string    `fake:"{firstname}" json:"name"`
 	CreatedAt time.Time `fake:"{date}" json:"created_at"`
 	UpdatedAt time.Time `fake:"{date}" json:"updated_at"`
+	Version   int64     `fake:"{number:1,100}" json:"version"`
 }
 
 func (u *fakeUser) toJSON(t *testing.T) string {
@@ -54,6 +74,43 @@ func newFakeUser(t *testing.T) *fakeUser {
 	return &u
 }
 
+func newTestObserver(t *testing.T) *config.Observer {
+	t.Helper()
+
+	cfg := &config.Config{
+		AdminToken: "admin-secret",
+		Lockout: config.LockoutConfig{
+			MaxAttempts: 3,
+			Duration:    time.Hour,
+			Delay:       time.Second,
+			MaxDelay:    10 * time.Second,
+		},
+	}
+
+	return config.NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+}
+
+func basicAuth(login, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(login+":"+password))
+}
+
+// newServiceAccount returns a user with the service role, it is allowed to
+// read any user.
+func newServiceAccount(t *testing.T) (*domain.User, string) {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("svc-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	user := &domain.User{
+		ID:       uuid.MustParse("0bd4cd1e-5a3c-4c55-9d7c-43a1e1e6a0b1"),
+		Login:    "svc",
+		Password: string(hash),
+	}
+
+	return user, basicAuth("svc", "svc-secret")
+}
+
 func Test_userHandler_getUser_SQL_level(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -91,10 +148,20 @@ func Test_userHandler_getUser_SQL_level(t *testing.T) {
 			}
 			logger := zap.NewNop()
 			userRepository := repository.NewUserDB(db)
-			userService := service.NewUserService(userRepository)
-			userHandler := NewUserHandler(userService, logger)
-			mux := http.NewServeMux()
-			userHandler.GetMux(mux)
+			obs := newTestObserver(t)
+			userService := service.NewUserService(userRepository, nil, nil, obs)
+			mux := newTestMux(userService, nil, obs, NewUserHandler(userService, logger))
+
+			account, authorization := newServiceAccount(t)
+			dbMock.ExpectQuery(regexp.QuoteMeta(repository.SQLGetUserAuth)).WithArgs(account.Login).WillReturnRows(
+				sqlmock.NewRows([]string{
+					"id", "login", "password", "name", "created_at", "updated_at",
+					"failed_attempts", "locked_until", "email", "email_verified_at", "version",
+				}).AddRow(account.ID, account.Login, account.Password, "", time.Now(), time.Now(), 0, nil, nil, nil, 1))
+			dbMock.ExpectQuery(regexp.QuoteMeta(repository.SQLGetTOTP)).WithArgs(account.ID).WillReturnRows(
+				sqlmock.NewRows([]string{"user_id", "secret", "confirmed_at", "last_step", "created_at"}))
+			dbMock.ExpectQuery(regexp.QuoteMeta(repository.SQLGetRoles)).WithArgs(account.ID).WillReturnRows(
+				sqlmock.NewRows([]string{"role"}).AddRow(service.RoleService))
 
 			user := newFakeUser(t)
 			if user.Login == "" {
@@ -107,12 +174,12 @@ func Test_userHandler_getUser_SQL_level(t *testing.T) {
 			if tt.closeError != nil {
 				dbMock.ExpectQuery(repository.SQLGetUser).WillReturnError(tt.closeError)
 			} else {
-				rows := sqlmock.NewRows([]string{"id", "loging", "name", "created_at", "updated_at"})
+				rows := sqlmock.NewRows([]string{"id", "loging", "name", "created_at", "updated_at", "version"})
 				if tt.rowError != nil {
 					// add data to return rows
 					rows = rows.CloseError(tt.rowError)
 				} else {
-					rows = rows.AddRow(user.ID, user.Login, user.Name, user.CreatedAt, user.UpdatedAt)
+					rows = rows.AddRow(user.ID, user.Login, user.Name, user.CreatedAt, user.UpdatedAt, user.Version)
 				}
 				// set rows reding errors sql.ErrNoRows
 				dbMock.ExpectQuery(repository.SQLGetUser).WithArgs(user.Login).WillReturnRows(rows)
@@ -123,6 +190,7 @@ func Test_userHandler_getUser_SQL_level(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			r.Header.Set("Authorization", authorization)
 
 			// create test response writer
 			w := httptest.NewRecorder()
@@ -166,11 +234,12 @@ func Test_userHandler_getUser_Repo_level(t *testing.T) {
 				Name:      "b",
 				CreatedAt: time.Date(2024, 11, 29, 18, 33, 55, 100, time.UTC),
 				UpdatedAt: time.Date(2024, 11, 29, 18, 33, 55, 100, time.UTC),
+				Version:   3,
 			},
 			dataError: nil,
 			wantCode:  http.StatusOK,
 			wantResp: `{"id":"70868a75-adbb-4b4d-b482-93915ee11777","login":"b","name":"b",` +
-				`"created_at":"2024-11-29T18:33:55.0000001Z","updated_at":"2024-11-29T18:33:55.0000001Z"}`,
+				`"created_at":"2024-11-29T18:33:55.0000001Z","updated_at":"2024-11-29T18:33:55.0000001Z","version":3}`,
 		},
 		{
 			name:      "get user by login not found",
@@ -197,10 +266,14 @@ func Test_userHandler_getUser_Repo_level(t *testing.T) {
 			// build whole stack mockRepo -> userService -> userHandler
 			logger := zap.NewNop()
 			mockUserRepo := mocks.NewUserRepository(t)
-			userService := service.NewUserService(mockUserRepo)
-			userHandler := NewUserHandler(userService, logger)
-			mux := http.NewServeMux()
-			userHandler.GetMux(mux)
+			obs := newTestObserver(t)
+			userService := service.NewUserService(mockUserRepo, nil, nil, obs)
+			mux := newTestMux(userService, nil, obs, NewUserHandler(userService, logger))
+
+			account, authorization := newServiceAccount(t)
+			mockUserRepo.On("GetUserAuth", mock.Anything, account.Login).Return(account, nil).Once()
+			mockUserRepo.On("GetTOTP", mock.Anything, account.ID).Return(nil, repository.ErrTOTPNotFound).Once()
+			mockUserRepo.On("GetRoles", mock.Anything, account.ID).Return([]string{service.RoleService}, nil).Once()
 
 			// set user repo mock
 			// response once on method `getUser`
@@ -211,6 +284,7 @@ func Test_userHandler_getUser_Repo_level(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			r.Header.Set("Authorization", authorization)
 
 			// create test response writer
 			w := httptest.NewRecorder()
@@ -224,3 +298,600 @@ func Test_userHandler_getUser_Repo_level(t *testing.T) {
 		})
 	}
 }
+
+// lockedFor matches a lockout of d from a time between the call of
+// lockedFor and the match, however long the login takes.
+func lockedFor(d time.Duration) func(until *time.Time) bool {
+	start := time.Now()
+
+	return func(until *time.Time) bool {
+		return until != nil && !until.Before(start.Add(d)) && !until.After(time.Now().Add(d))
+	}
+}
+
+func Test_userHandler_login(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	id := uuid.MustParse("70868a75-adbb-4b4d-b482-93915ee11777")
+	lockedUntil := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		password string
+		user     *domain.User
+		userErr  error
+		setup    func(repo *mocks.UserRepository)
+		wantCode int
+	}{
+		{
+			name:     "login OK resets failed attempts",
+			password: "secret",
+			user:     &domain.User{ID: id, Login: "b", Password: string(hash), FailedAttempts: 2},
+			setup: func(repo *mocks.UserRepository) {
+				repo.On("GetTOTP", mock.Anything, id).Return(nil, repository.ErrTOTPNotFound).Once()
+				repo.On("SetLockout", mock.Anything, id, 0, (*time.Time)(nil)).Return(nil).Once()
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "wrong password delays next attempt",
+			password: "wrong",
+			user:     &domain.User{ID: id, Login: "b", Password: string(hash), FailedAttempts: 1},
+			setup: func(repo *mocks.UserRepository) {
+				repo.On("AddFailedAttempt", mock.Anything, id).Return(2, nil).Once()
+				repo.On("SetLockout", mock.Anything, id, 2, mock.MatchedBy(lockedFor(2*time.Second))).Return(nil).Once()
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong password locks account after max attempts",
+			password: "wrong",
+			user:     &domain.User{ID: id, Login: "b", Password: string(hash), FailedAttempts: 2},
+			setup: func(repo *mocks.UserRepository) {
+				repo.On("AddFailedAttempt", mock.Anything, id).Return(3, nil).Once()
+				repo.On("SetLockout", mock.Anything, id, 0, mock.MatchedBy(lockedFor(time.Hour))).Return(nil).Once()
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "locked account rejects correct password",
+			password: "secret",
+			user:     &domain.User{ID: id, Login: "b", Password: string(hash), LockedUntil: &lockedUntil},
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name:     "unknown login",
+			password: "secret",
+			userErr:  repository.ErrUserNotFound,
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockUserRepo := mocks.NewUserRepository(t)
+			obs := newTestObserver(t)
+			userService := service.NewUserService(mockUserRepo, nil, nil, obs)
+			mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+			mockUserRepo.On("GetUserAuth", mock.Anything, "b").Return(tt.user, tt.userErr).Once()
+			if tt.setup != nil {
+				tt.setup(mockUserRepo)
+			}
+
+			body := fmt.Sprintf(`{"login":"b","password":%q}`, tt.password)
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, r)
+
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode == http.StatusTooManyRequests {
+				seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+				require.NoError(t, err)
+				assert.InDelta(t, time.Until(lockedUntil).Seconds(), seconds, 1)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_unlockUser(t *testing.T) {
+	id := uuid.MustParse("70868a75-adbb-4b4d-b482-93915ee11777")
+
+	mockUserRepo := mocks.NewUserRepository(t)
+	obs := newTestObserver(t)
+	userService := service.NewUserService(mockUserRepo, nil, nil, obs)
+	mux := newTestMux(userService, nil, obs, NewAdminHandler(userService, zap.NewNop()))
+
+	unlock := func(authorization string) int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/user/b/unlock", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, unlock(""))
+	assert.Equal(t, http.StatusUnauthorized, unlock("Bearer wrong"))
+
+	mockUserRepo.On("GetUserAuth", mock.Anything, "b").Return(&domain.User{ID: id, Login: "b"}, nil).Once()
+	mockUserRepo.On("SetLockout", mock.Anything, id, 0, (*time.Time)(nil)).Return(nil).Once()
+
+	assert.Equal(t, http.StatusNoContent, unlock("Bearer admin-secret"))
+}
+
+// newTestMux registers handlers behind the authenticator the way the HTTP
+// server does.
+func newTestMux(
+	userService service.UserServiceInterface,
+	apiKeyService service.APIKeyServiceInterface,
+	obs *config.Observer,
+	handlers ...HTTPHandler,
+) http.Handler {
+	mux := http.NewServeMux()
+	for _, handler := range handlers {
+		handler.GetMux(mux)
+	}
+
+	return NewAuthenticator(userService, apiKeyService, obs, zap.NewNop()).Handler(mux, mux)
+}
+
+// Test_userHandler_TOTP goes through enrolment and two step login against
+// a real database.
+func Test_userHandler_TOTP(t *testing.T) {
+	sqlite := dbtest.New(t)
+
+	cfg := &config.Config{
+		AllowSignup: true,
+		Lockout:     config.LockoutConfig{MaxAttempts: 3, Duration: time.Hour},
+		MFA: config.MFAConfig{
+			Issuer:        "test",
+			EncryptionKey: base64.StdEncoding.EncodeToString(make([]byte, 32)),
+			ChallengeTTL:  time.Minute,
+		},
+	}
+	obs := config.NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userService := service.NewUserService(repository.NewUserDB(sqlite), nil, nil, obs)
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+	post := func(url string, body any, v any) int {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, bytes.NewReader(data)))
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+
+		return w.Code
+	}
+
+	credentials := map[string]string{"login": "admin", "password": "secret"}
+	require.Equal(t, http.StatusCreated, post("/user/", map[string]string{"login": "admin", "password": "secret"}, nil))
+
+	var enrollment domain.TOTPEnrollment
+	require.Equal(t, http.StatusCreated, post("/user/admin/totp", credentials, &enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/test:admin?"))
+	assert.True(t, bytes.HasPrefix(enrollment.QRPNG, []byte("\x89PNG")))
+
+	key, err := otp.NewKeyFromURL(enrollment.URI)
+	require.NoError(t, err)
+
+	// codes from the previous time step are accepted once
+	code := func(step int) string {
+		code, err := totp.GenerateCode(key.Secret(), time.Now().Add(time.Duration(step)*30*time.Second))
+		require.NoError(t, err)
+		return code
+	}
+
+	var recovery recoveryCodesJSON
+	require.Equal(t, http.StatusOK, post("/user/admin/totp/confirm",
+		map[string]string{"password": "secret", "code": code(-1)}, &recovery))
+	require.Len(t, recovery.RecoveryCodes, 10)
+
+	require.Equal(t, http.StatusConflict, post("/user/admin/totp", credentials, nil))
+
+	login := func() string {
+		var challenge mfaChallengeJSON
+		require.Equal(t, http.StatusOK, post("/login", credentials, &challenge))
+		require.True(t, challenge.MFARequired)
+		return challenge.MFAToken
+	}
+
+	t.Run("wrong code", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", domain.MFACode{MFAToken: login(), Code: "000000"}, nil))
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", domain.MFACode{MFAToken: "forged.token", Code: code(0)}, nil))
+	})
+
+	t.Run("totp code", func(t *testing.T) {
+		token := login()
+		var user domain.UserOut
+		require.Equal(t, http.StatusOK, post("/login/mfa", domain.MFACode{MFAToken: token, Code: code(0)}, &user))
+		assert.Equal(t, "admin", user.Login)
+
+		// replay of the same code
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", domain.MFACode{MFAToken: token, Code: code(0)}, nil))
+		// the token completed a login
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", domain.MFACode{MFAToken: token, Code: code(1)}, nil))
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		token := login()
+		recoveryCode := strings.ToUpper(recovery.RecoveryCodes[0])
+		assert.Equal(t, http.StatusOK, post("/login/mfa", domain.MFACode{MFAToken: token, RecoveryCode: recoveryCode}, nil))
+		assert.Equal(t, http.StatusUnauthorized, post("/login/mfa", domain.MFACode{MFAToken: login(), RecoveryCode: recoveryCode}, nil))
+		assert.Equal(t, http.StatusUnauthorized,
+			post("/login/mfa", domain.MFACode{MFAToken: token, RecoveryCode: recovery.RecoveryCodes[1]}, nil))
+	})
+
+	t.Run("wrong confirmation codes lock the account", func(t *testing.T) {
+		bob := map[string]string{"login": "bob", "password": "secret"}
+		require.Equal(t, http.StatusCreated, post("/user/", bob, nil))
+		require.Equal(t, http.StatusCreated, post("/user/bob/totp", bob, &enrollment))
+
+		key, err := otp.NewKeyFromURL(enrollment.URI)
+		require.NoError(t, err)
+		valid, err := totp.GenerateCode(key.Secret(), time.Now())
+		require.NoError(t, err)
+
+		confirm := map[string]string{"password": "secret", "code": "000000"}
+		for range cfg.Lockout.MaxAttempts {
+			assert.Equal(t, http.StatusUnauthorized, post("/user/bob/totp/confirm", confirm, nil))
+		}
+
+		confirm["code"] = valid
+		assert.Equal(t, http.StatusTooManyRequests, post("/user/bob/totp/confirm", confirm, nil))
+	})
+}
+
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []*mailer.Message
+	// hold blocks sending until it is closed
+	hold chan struct{}
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg *mailer.Message) error {
+	m.mu.Lock()
+	hold := m.hold
+	m.mu.Unlock()
+
+	if hold != nil {
+		<-hold
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// received waits until n messages are sent and returns them.
+func (m *recordingMailer) received(t *testing.T, n int) []*mailer.Message {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.messages) >= n
+	}, time.Second, time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	require.Len(t, m.messages, n)
+	return slices.Clone(m.messages)
+}
+
+// token returns the token from the link in the last of n messages.
+func (m *recordingMailer) token(t *testing.T, n int) string {
+	t.Helper()
+
+	messages := m.received(t, n)
+	link := regexp.MustCompile(`https://example\.com/\S+`).FindString(messages[n-1].Body)
+
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+
+	return u.Query().Get("token")
+}
+
+func Test_userHandler_EmailVerificationAndPasswordReset(t *testing.T) {
+	cfg := &config.Config{
+		AdminToken:  "admin-secret",
+		AllowSignup: true,
+		Mail: config.MailConfig{
+			BaseURL:   "https://example.com",
+			TokenKey:  base64.StdEncoding.EncodeToString(make([]byte, 32)),
+			VerifyTTL: time.Hour,
+			ResetTTL:  time.Hour,
+		},
+	}
+	obs := config.NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+	mail := &recordingMailer{}
+
+	userService := service.NewUserService(repository.NewUserDB(dbtest.New(t)), nil, mail, obs)
+
+	mux := newTestMux(userService, nil, obs,
+		NewUserHandler(userService, zap.NewNop()), NewAdminHandler(userService, zap.NewNop()))
+
+	postAs := func(authorization, url string, body any) int {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w.Code
+	}
+	post := func(url string, body any) int {
+		t.Helper()
+		return postAs("", url, body)
+	}
+
+	require.Equal(t, http.StatusCreated,
+		post("/user/", domain.UserIn{Login: "alice", Password: "secret", Email: "Alice@Example.com"}))
+	assert.Equal(t, "alice@example.com", mail.received(t, 1)[0].To)
+	verifyToken := mail.token(t, 1)
+
+	assert.Equal(t, http.StatusConflict,
+		post("/user/", domain.UserIn{Login: "bob", Password: "secret", Email: "alice@example.com"}))
+	assert.Equal(t, http.StatusBadRequest,
+		post("/user/", domain.UserIn{Login: "bob", Password: "secret", Email: "Bob <bob@example.com>"}))
+
+	t.Run("reset before verification", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, post("/password/reset", emailJSON{Email: "alice@example.com"}))
+		mail.received(t, 1)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, post("/email/verify", tokenJSON{Token: verifyToken + "x"}))
+		assert.Equal(t, http.StatusOK, post("/email/verify", tokenJSON{Token: verifyToken}))
+		assert.Equal(t, http.StatusUnauthorized, post("/email/verify", tokenJSON{Token: verifyToken}))
+		assert.Equal(t, http.StatusUnauthorized, post("/user/alice/email/verify", nil))
+		assert.Equal(t, http.StatusConflict, postAs(basicAuth("alice", "secret"), "/user/alice/email/verify", nil))
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/user/alice", nil)
+		r.Header.Set("Authorization", "Bearer admin-secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		var user domain.UserOut
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+		assert.Equal(t, "alice@example.com", user.Email)
+		assert.NotNil(t, user.EmailVerifiedAt)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, post("/password/reset", emailJSON{Email: "nobody@example.com"}))
+
+		// the reply doesn't wait for the mail of a registered address
+		hold := make(chan struct{})
+		mail.mu.Lock()
+		mail.hold = hold
+		mail.mu.Unlock()
+
+		assert.Equal(t, http.StatusAccepted, post("/password/reset", emailJSON{Email: "ALICE@example.com"}))
+		close(hold)
+
+		assert.Equal(t, "alice@example.com", mail.received(t, 2)[1].To)
+		resetToken := mail.token(t, 2)
+
+		// a verification token can't reset the password
+		assert.Equal(t, http.StatusUnauthorized,
+			post("/password/reset/confirm", domain.PasswordReset{Token: verifyToken, Password: "changed"}))
+
+		assert.Equal(t, http.StatusNoContent,
+			post("/password/reset/confirm", domain.PasswordReset{Token: resetToken, Password: "changed"}))
+		assert.Equal(t, http.StatusUnauthorized,
+			post("/password/reset/confirm", domain.PasswordReset{Token: resetToken, Password: "again"}))
+
+		assert.Equal(t, http.StatusUnauthorized, post("/login", domain.Credentials{Login: "alice", Password: "secret"}))
+		assert.Equal(t, http.StatusOK, post("/login", domain.Credentials{Login: "alice", Password: "changed"}))
+	})
+}
+
+func Test_userHandler_RBAC(t *testing.T) {
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+	userService := service.NewUserService(repository.NewUserDB(dbtest.New(t)), nil, nil, obs)
+
+	mux := newTestMux(userService, nil, obs,
+		NewUserHandler(userService, zap.NewNop()), NewAdminHandler(userService, zap.NewNop()))
+
+	do := func(authorization, method, url string, body any, v any) int {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(method, url, bytes.NewReader(data))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+
+		return w.Code
+	}
+
+	admin := "Bearer admin-secret"
+	alice := basicAuth("alice", "secret")
+
+	assert.Equal(t, http.StatusUnauthorized,
+		do("", http.MethodPost, "/user/", domain.UserIn{Login: "alice", Password: "secret"}, nil))
+	require.Equal(t, http.StatusCreated,
+		do(admin, http.MethodPost, "/user/", domain.UserIn{Login: "alice", Password: "secret"}, nil))
+	require.Equal(t, http.StatusCreated,
+		do(admin, http.MethodPost, "/user/", domain.UserIn{Login: "bob", Password: "secret"}, nil))
+
+	t.Run("anonymous", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, do("", http.MethodGet, "/user/alice", nil, nil))
+		assert.Equal(t, http.StatusUnauthorized, do(basicAuth("alice", "wrong"), http.MethodGet, "/user/alice", nil, nil))
+	})
+
+	t.Run("user reads only self", func(t *testing.T) {
+		var user domain.UserOut
+		require.Equal(t, http.StatusOK, do(alice, http.MethodGet, "/user/alice", nil, &user))
+		assert.Equal(t, "alice", user.Login)
+		assert.Nil(t, user.Lockout)
+
+		assert.Equal(t, http.StatusForbidden, do(alice, http.MethodGet, "/user/bob", nil, nil))
+		assert.Equal(t, http.StatusForbidden,
+			do(alice, http.MethodPost, "/user/", domain.UserIn{Login: "carol", Password: "secret"}, nil))
+		assert.Equal(t, http.StatusForbidden, do(alice, http.MethodPost, "/admin/user/bob/unlock", nil, nil))
+		assert.Equal(t, http.StatusForbidden,
+			do(alice, http.MethodPut, "/admin/user/alice/roles", rolesJSON{Roles: []string{service.RoleAdmin}}, nil))
+	})
+
+	t.Run("admin manages roles", func(t *testing.T) {
+		var roles rolesJSON
+		require.Equal(t, http.StatusOK, do(admin, http.MethodGet, "/admin/user/alice/roles", nil, &roles))
+		assert.Equal(t, []string{service.RoleUser}, roles.Roles)
+
+		assert.Equal(t, http.StatusBadRequest,
+			do(admin, http.MethodPut, "/admin/user/alice/roles", rolesJSON{Roles: []string{"root"}}, nil))
+
+		require.Equal(t, http.StatusOK, do(admin, http.MethodPut, "/admin/user/alice/roles",
+			rolesJSON{Roles: []string{service.RoleUser, service.RoleService, service.RoleUser}}, &roles))
+		assert.Equal(t, []string{service.RoleService, service.RoleUser}, roles.Roles)
+
+		var user domain.UserOut
+		require.Equal(t, http.StatusOK, do(admin, http.MethodGet, "/user/alice", nil, &user))
+		assert.Equal(t, roles.Roles, user.Roles)
+		assert.NotNil(t, user.Lockout)
+	})
+
+	t.Run("service reads and creates anyone", func(t *testing.T) {
+		var user domain.UserOut
+		require.Equal(t, http.StatusOK, do(alice, http.MethodGet, "/user/bob", nil, &user))
+		assert.Nil(t, user.Lockout)
+
+		assert.Equal(t, http.StatusCreated,
+			do(alice, http.MethodPost, "/user/", domain.UserIn{Login: "carol", Password: "secret"}, nil))
+		assert.Equal(t, http.StatusForbidden, do(alice, http.MethodPost, "/admin/user/bob/unlock", nil, nil))
+	})
+}
+
+func Test_userHandler_accessToken(t *testing.T) {
+	cfg := &config.Config{
+		AdminToken: "admin-secret",
+		Auth: config.AuthConfig{
+			JWTKey:   base64.StdEncoding.EncodeToString(make([]byte, 32)),
+			Issuer:   "go-user",
+			TokenTTL: time.Minute,
+		},
+		Lockout: config.LockoutConfig{MaxAttempts: 5, Duration: time.Hour, Delay: time.Second, MaxDelay: time.Minute},
+	}
+	obs := config.NewObserver(cfg, fxtest.NewLifecycle(t), zap.NewNop())
+	userService := service.NewUserService(repository.NewUserDB(dbtest.New(t)), nil, nil, obs)
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+	do := func(authorization, method, url string, body any, v any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(method, url, bytes.NewReader(data))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated,
+		do("Bearer admin-secret", http.MethodPost, "/user/", domain.UserIn{Login: "alice", Password: "secret"}, nil).Code)
+
+	anonymous := do("", http.MethodGet, "/user/alice", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, anonymous.Code)
+	assert.Contains(t, anonymous.Header().Values("WWW-Authenticate"), `Bearer realm="go-user"`)
+
+	var login loginJSON
+	require.Equal(t, http.StatusOK,
+		do("", http.MethodPost, "/login", domain.Credentials{Login: "alice", Password: "secret"}, &login).Code)
+	assert.Equal(t, "alice", login.Login)
+	require.NotEmpty(t, login.AccessToken)
+	require.NotNil(t, login.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *login.ExpiresAt, 2*time.Second)
+
+	bearer := "Bearer " + login.AccessToken
+
+	var user domain.UserOut
+	require.Equal(t, http.StatusOK, do(bearer, http.MethodGet, "/user/alice", nil, &user).Code)
+	assert.Equal(t, "alice", user.Login)
+	assert.Equal(t, http.StatusForbidden, do(bearer, http.MethodGet, "/user/bob", nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(bearer+"x", http.MethodGet, "/user/alice", nil, nil).Code)
+
+	// credentials on public routes are checked as well
+	assert.Equal(t, http.StatusUnauthorized,
+		do(bearer+"x", http.MethodPost, "/login", domain.Credentials{Login: "alice", Password: "secret"}, nil).Code)
+
+	// wrong passwords sent by anyone don't end the sessions of the user
+	assert.Equal(t, http.StatusUnauthorized,
+		do("", http.MethodPost, "/login", domain.Credentials{Login: "alice", Password: "wrong"}, nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests,
+		do("", http.MethodPost, "/login", domain.Credentials{Login: "alice", Password: "secret"}, nil).Code)
+	assert.Equal(t, http.StatusOK, do(bearer, http.MethodGet, "/user/alice", nil, nil).Code)
+}
+
+func Test_userHandler_clientCert(t *testing.T) {
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+	userService := service.NewUserService(repository.NewUserDB(dbtest.New(t)), nil, nil, obs)
+	mux := newTestMux(userService, nil, obs, NewUserHandler(userService, zap.NewNop()))
+
+	data, err := json.Marshal(domain.UserIn{Login: "alice", Password: "secret"})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/user/", bytes.NewReader(data))
+	r.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	get := func(commonName, authorization, url string) int {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("alice", "", "/user/alice"))
+	assert.Equal(t, http.StatusForbidden, get("alice", "", "/user/bob"))
+	// certificates of unknown users are anonymous
+	assert.Equal(t, http.StatusUnauthorized, get("bob", "", "/user/alice"))
+	// credentials take precedence over the certificate
+	assert.Equal(t, http.StatusUnauthorized, get("alice", basicAuth("alice", "wrong"), "/user/alice"))
+}
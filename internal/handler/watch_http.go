@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
+)
+
+type watchHandler struct {
+	watchService service.WatchServiceInterface
+	obs          *config.Observer
+	logger       *zap.SugaredLogger
+}
+
+func (watchhandler *watchHandler) GetMux(mux Router) {
+	mux.HandleFunc("GET /users/watch", watchhandler.watchUsers)
+}
+
+// watchUsers streams changes of users as server-sent events with the
+// revision as the event id. EventSource resumes by sending it back in
+// Last-Event-ID, other clients may pass the revision query parameter.
+func (watchhandler *watchHandler) watchUsers(w http.ResponseWriter, r *http.Request) {
+	revision, err := watchRevision(r)
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	watcher, err := watchhandler.watchService.WatchUsers(r.Context(), revision)
+	if err != nil {
+		serveAuthError(w, watchhandler.logger, "", err)
+		return
+	}
+	defer watcher.Close()
+
+	// the stream outlives write_timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	for {
+		ctx, cancel := context.WithTimeout(r.Context(), watchhandler.obs.Current().Watch.Heartbeat)
+		event, err := watcher.Next(ctx)
+		cancel()
+
+		switch {
+		case r.Context().Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case err != nil:
+			watchhandler.logger.Infow("Watch ended", "err", err)
+			data, _ := json.Marshal(errorJSON{Message: err.Error(), Code: watchErrorCode(err)})
+			_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			_ = rc.Flush()
+			return
+		default:
+			data, err := json.Marshal(event)
+			if err != nil {
+				watchhandler.logger.Warnw("Error marshaling event", "err", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		_ = rc.Flush()
+	}
+}
+
+// watchRevision reads the revision to resume from, Last-Event-ID takes
+// precedence over the revision query parameter.
+func watchRevision(r *http.Request) (*int64, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("revision")
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("revision: %w", err)
+	}
+
+	return &revision, nil
+}
+
+// watchErrorCode is the status reported in the error event ending a
+// stream.
+func watchErrorCode(err error) int {
+	switch {
+	case errors.Is(err, watch.ErrLagging):
+		return http.StatusConflict
+	case errors.Is(err, watch.ErrClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func NewWatchHandler(watchService service.WatchServiceInterface, obs *config.Observer, logger *zap.Logger) HTTPHandler {
+	return &watchHandler{
+		watchService,
+		obs,
+		logger.Named("WatchHandler").Sugar(),
+	}
+}
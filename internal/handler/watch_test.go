@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
+)
+
+func Test_watchHandler(t *testing.T) {
+	ctx := context.Background()
+	sqlite := dbtest.New(t)
+	users := repository.NewUserDB(sqlite)
+	obs := config.NewObserver(&config.Config{
+		AdminToken: "admin-secret",
+		Watch:      config.WatchConfig{PollInterval: 10 * time.Millisecond, Buffer: 16, Heartbeat: time.Minute},
+	}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	hub := watch.NewHub(repository.NewOutboxDB(sqlite), obs, fxtest.NewLifecycle(t), zap.NewNop())
+	require.NoError(t, hub.Start(ctx))
+	t.Cleanup(func() { _ = hub.Stop(ctx) })
+
+	userService := service.NewUserService(users, nil, nil, obs)
+	srv := httptest.NewServer(newTestMux(userService, nil, obs,
+		NewWatchHandler(service.NewWatchService(hub), obs, zap.NewNop())))
+	t.Cleanup(srv.Close)
+
+	createUser := func(login string) {
+		t.Helper()
+
+		now := time.Now()
+		require.NoError(t, users.CreateUser(ctx, &domain.User{
+			ID: uuid.New(), Login: login, Password: "hash", Name: login, CreatedAt: now, UpdatedAt: now,
+		}))
+	}
+
+	get := func(url string, header http.Header) *http.Response {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		t.Cleanup(cancel)
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+url, nil)
+		require.NoError(t, err)
+		for name := range header {
+			r.Header.Set(name, header.Get(name))
+		}
+
+		resp, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+
+		return resp
+	}
+
+	// readEvent returns the fields of the next event.
+	readEvent := func(events *bufio.Scanner) map[string]string {
+		t.Helper()
+
+		fields := map[string]string{}
+		for events.Scan() && events.Text() != "" {
+			name, value, _ := strings.Cut(events.Text(), ": ")
+			fields[name] = value
+		}
+		require.NoError(t, events.Err())
+
+		return fields
+	}
+
+	admin := http.Header{"Authorization": {"Bearer admin-secret"}}
+
+	createUser("alice")
+
+	t.Run("errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get("/users/watch", nil).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, get("/users/watch?revision=latest", admin).StatusCode)
+		assert.Equal(t, http.StatusGone, get("/users/watch?revision=10", admin).StatusCode)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		resp := get("/users/watch?revision=0", admin)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		events := bufio.NewScanner(resp.Body)
+		event := readEvent(events)
+		assert.Equal(t, "1", event["id"])
+		assert.Equal(t, domain.EventUserCreated, event["event"])
+
+		var data domain.Event
+		require.NoError(t, json.Unmarshal([]byte(event["data"]), &data))
+		assert.Equal(t, "alice", data.User.Login)
+
+		createUser("bob")
+		event = readEvent(events)
+		assert.Equal(t, "2", event["id"])
+	})
+
+	t.Run("last event id", func(t *testing.T) {
+		header := admin.Clone()
+		header.Set("Last-Event-ID", "1")
+
+		event := readEvent(bufio.NewScanner(get("/users/watch?revision=0", header).Body))
+		assert.Equal(t, "2", event["id"])
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		events := bufio.NewScanner(get("/users/watch", admin).Body)
+		hub.Close()
+
+		event := readEvent(events)
+		assert.Equal(t, "error", event["event"])
+		assert.Contains(t, event["data"], `"code":503`)
+	})
+}
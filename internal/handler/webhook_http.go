@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+type webhookHandler struct {
+	webhookService service.WebhookServiceInterface
+	logger         *zap.SugaredLogger
+}
+
+func (webhookhandler *webhookHandler) GetMux(mux Router) {
+	mux.HandleFunc("POST /admin/webhooks", webhookhandler.createWebhook)
+	mux.HandleFunc("GET /admin/webhooks", webhookhandler.listWebhooks)
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", webhookhandler.deleteWebhook)
+	mux.HandleFunc("GET /admin/webhooks/{id}/deliveries", webhookhandler.listDeliveries)
+	mux.HandleFunc("POST /admin/webhooks/{id}/deliveries/{delivery}/retry", webhookhandler.retryDelivery)
+}
+
+func (webhookhandler *webhookHandler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var in domain.WebhookIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	webhook, err := webhookhandler.webhookService.CreateWebhook(r.Context(), &in)
+	if err != nil {
+		serveAuthError(w, webhookhandler.logger, "", err)
+		return
+	}
+
+	webhookhandler.logger.Infow("Webhook created", "id", webhook.ID, "url", webhook.URL, "events", webhook.Events)
+	serveJSON(w, webhook, http.StatusCreated)
+}
+
+func (webhookhandler *webhookHandler) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := webhookhandler.webhookService.ListWebhooks(r.Context())
+	if err != nil {
+		serveAuthError(w, webhookhandler.logger, "", err)
+		return
+	}
+
+	serveJSON(w, webhooks, http.StatusOK)
+}
+
+func (webhookhandler *webhookHandler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := webhookhandler.webhookService.DeleteWebhook(r.Context(), id); err != nil {
+		serveAuthError(w, webhookhandler.logger, "", err)
+		return
+	}
+
+	webhookhandler.logger.Infow("Webhook deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeliveries returns the delivery history, status and limit query
+// parameters filter it.
+func (webhookhandler *webhookHandler) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			serveErrorJSON(w, http.StatusBadRequest, fmt.Errorf("limit: %w", err))
+			return
+		}
+	}
+
+	deliveries, err := webhookhandler.webhookService.ListDeliveries(
+		r.Context(), r.PathValue("id"), r.URL.Query().Get("status"), limit,
+	)
+	if err != nil {
+		serveAuthError(w, webhookhandler.logger, "", err)
+		return
+	}
+
+	serveJSON(w, deliveries, http.StatusOK)
+}
+
+func (webhookhandler *webhookHandler) retryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil {
+		serveErrorJSON(w, http.StatusNotFound, service.ErrDeliveryNotFound)
+		return
+	}
+
+	if err := webhookhandler.webhookService.RetryDelivery(r.Context(), r.PathValue("id"), id); err != nil {
+		serveAuthError(w, webhookhandler.logger, "", err)
+		return
+	}
+
+	webhookhandler.logger.Infow("Webhook delivery retried", "webhook_id", r.PathValue("id"), "id", id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func NewWebhookHandler(webhookService service.WebhookServiceInterface, logger *zap.Logger) HTTPHandler {
+	return &webhookHandler{
+		webhookService,
+		logger.Named("WebhookHandler").Sugar(),
+	}
+}
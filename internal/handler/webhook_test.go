@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+func Test_webhookHandler(t *testing.T) {
+	sqlite := dbtest.New(t)
+	obs := config.NewObserver(&config.Config{AdminToken: "admin-secret"}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	userService := service.NewUserService(repository.NewUserDB(sqlite), nil, nil, obs)
+	webhookService := service.NewWebhookService(repository.NewWebhookDB(sqlite), obs, zap.NewNop())
+
+	mux := newTestMux(userService, nil, obs,
+		NewUserHandler(userService, zap.NewNop()), NewWebhookHandler(webhookService, zap.NewNop()))
+
+	do := func(authorization, method, url string, body any, v any) int {
+		t.Helper()
+
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(method, url, bytes.NewReader(data))
+		r.Header.Set("Authorization", authorization)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if v != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+		}
+
+		return w.Code
+	}
+
+	admin := "Bearer admin-secret"
+
+	var webhook domain.WebhookOut
+	require.Equal(t, http.StatusCreated, do(admin, http.MethodPost, "/admin/webhooks", domain.WebhookIn{
+		URL:    "https://partner.example.com/hooks",
+		Events: []string{domain.EventUserDeleted, domain.EventUserCreated, domain.EventUserCreated},
+	}, &webhook))
+	assert.True(t, strings.HasPrefix(webhook.Secret, "whsec_"), webhook.Secret)
+	assert.Equal(t, []string{domain.EventUserCreated, domain.EventUserDeleted}, webhook.Events)
+
+	t.Run("validation", func(t *testing.T) {
+		for _, in := range []domain.WebhookIn{
+			{URL: "partner.example.com/hooks"},
+			{URL: "ftp://partner.example.com/hooks"},
+			{URL: "https://partner.example.com/hooks", Events: []string{"user.renamed"}},
+			{URL: "http://127.0.0.1:8080/hooks"},
+			{URL: "http://[::1]/hooks"},
+			{URL: "http://169.254.169.254/latest/meta-data"},
+			{URL: "http://10.0.0.1/hooks"},
+			{URL: "http://localhost/hooks"},
+		} {
+			assert.Equal(t, http.StatusBadRequest, do(admin, http.MethodPost, "/admin/webhooks", in, nil), in)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var webhooks []domain.WebhookOut
+		require.Equal(t, http.StatusOK, do(admin, http.MethodGet, "/admin/webhooks", nil, &webhooks))
+		require.Len(t, webhooks, 1)
+		assert.Equal(t, webhook.ID, webhooks[0].ID)
+		assert.Empty(t, webhooks[0].Secret)
+	})
+
+	t.Run("deliveries", func(t *testing.T) {
+		var deliveries []domain.WebhookDelivery
+		require.Equal(t, http.StatusOK,
+			do(admin, http.MethodGet, "/admin/webhooks/"+webhook.ID+"/deliveries?status=dead", nil, &deliveries))
+		assert.Empty(t, deliveries)
+
+		assert.Equal(t, http.StatusBadRequest,
+			do(admin, http.MethodGet, "/admin/webhooks/"+webhook.ID+"/deliveries?status=lost", nil, nil))
+		assert.Equal(t, http.StatusNotFound, do(admin, http.MethodGet, "/admin/webhooks/unknown/deliveries", nil, nil))
+		assert.Equal(t, http.StatusNotFound,
+			do(admin, http.MethodPost, "/admin/webhooks/"+webhook.ID+"/deliveries/1/retry", nil, nil))
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated,
+			do(admin, http.MethodPost, "/user/", domain.UserIn{Login: "alice", Password: "secret"}, nil))
+		assert.Equal(t, http.StatusForbidden, do(basicAuth("alice", "secret"), http.MethodGet, "/admin/webhooks", nil, nil))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, do(admin, http.MethodDelete, "/admin/webhooks/"+webhook.ID, nil, nil))
+		assert.Equal(t, http.StatusNotFound, do(admin, http.MethodDelete, "/admin/webhooks/"+webhook.ID, nil, nil))
+	})
+}
@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+// NewLogger builds the production logger with the level taken from the
+// config. The level is changed on the fly when the config is reloaded.
+func NewLogger(cfg *config.Config) (*zap.Logger, zap.AtomicLevel, error) {
+	level, err := zap.ParseAtomicLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, level, err
+	}
+
+	zapCfg := zap.NewProductionConfig()
+	zapCfg.Level = level
+
+	logger, err := zapCfg.Build()
+	if err != nil {
+		return nil, level, err
+	}
+
+	return logger, level, nil
+}
+
+// WatchLevel subscribes the logger level to config reloads.
+func WatchLevel(obs *config.Observer, level zap.AtomicLevel, logger *zap.Logger) {
+	log := logger.Sugar().Named("Logger")
+
+	obs.Subscribe(func(cfg *config.Config) {
+		newLevel, err := zap.ParseAtomicLevel(cfg.LogLevel)
+		if err != nil {
+			return
+		}
+
+		if newLevel.Level() != level.Level() {
+			log.Infow("Changing log level", "from", level.Level(), "to", newLevel.Level())
+			level.SetLevel(newLevel.Level())
+		}
+	})
+}
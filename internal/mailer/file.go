@@ -0,0 +1,20 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeFile stores the message in dir as a .eml file for local testing.
+func writeFile(dir string, data []byte) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + strings.ToLower(rand.Text()[:8]) + ".eml"
+
+	return os.WriteFile(filepath.Join(dir, name), data, 0o600)
+}
@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// NewMailer returns a mailer sending with the transport from the current
+// mail config: smtp, file (one .eml file per message) or log.
+func NewMailer(obs *config.Observer, logger *zap.Logger) Mailer {
+	return &mailer{
+		obs:    obs,
+		logger: logger.Sugar().Named("Mailer"),
+	}
+}
+
+type mailer struct {
+	obs    *config.Observer
+	logger *zap.SugaredLogger
+}
+
+func (m *mailer) Send(ctx context.Context, msg *Message) error {
+	cfg := m.obs.Current().Mail
+
+	data, err := msg.bytes(cfg.From, time.Now())
+	if err != nil {
+		return err
+	}
+
+	switch cfg.Transport {
+	case config.MailTransportSMTP:
+		err = sendSMTP(ctx, cfg.SMTP, cfg.From, msg.To, data)
+	case config.MailTransportFile:
+		err = writeFile(cfg.Dir, data)
+	case config.MailTransportLog:
+		m.logger.Infow("Mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	default:
+		err = fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+
+	if err != nil {
+		m.logger.Errorw("Error sending mail", "transport", cfg.Transport, "to", msg.To, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// bytes formats the message with headers, lines end with CRLF.
+func (msg *Message) bytes(from string, date time.Time) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	header("From", sender.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", "<"+strings.ToLower(rand.Text())+"@"+domain(sender.Address)+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+
+	for line := range strings.Lines(msg.Body) {
+		buf.WriteString(strings.TrimRight(line, "\r\n") + "\r\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func domain(address string) string {
+	_, host, _ := strings.Cut(address, "@")
+	return host
+}
+
+// envelopeAddress returns the bare address for SMTP commands.
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	return parsed.Address, nil
+}
@@ -0,0 +1,145 @@
+package mailer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+func newTestMailer(t *testing.T, cfg config.MailConfig) Mailer {
+	t.Helper()
+
+	obs := config.NewObserver(&config.Config{Mail: cfg}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	return NewMailer(obs, zap.NewNop())
+}
+
+var testMessage = &Message{
+	To:      "Alice <alice@example.com>",
+	Subject: "Привет",
+	Body:    "first line\n.second line\n",
+}
+
+func TestMailer_File(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := newTestMailer(t, config.MailConfig{Transport: config.MailTransportFile, From: "go-user@example.com", Dir: dir})
+
+	require.NoError(t, m.Send(context.Background(), testMessage))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	msg := string(data)
+	assert.Contains(t, msg, "From: <go-user@example.com>\r\n")
+	assert.Contains(t, msg, "To: \"Alice\" <alice@example.com>\r\n")
+	assert.Contains(t, msg, "Subject: =?utf-8?q?")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nfirst line\r\n.second line\r\n"), msg)
+}
+
+func TestMailer_NoTransport(t *testing.T) {
+	m := newTestMailer(t, config.MailConfig{From: "go-user@example.com"})
+
+	assert.ErrorContains(t, m.Send(context.Background(), testMessage), "unknown transport", "messages are not logged by default")
+}
+
+// serveSMTP answers a single SMTP session without extensions and returns
+// the received envelope and data.
+func serveSMTP(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	received := make(chan []string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		_ = text.PrintfLine("220 localhost ESMTP")
+
+		var session []string
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				_ = text.PrintfLine("250 localhost")
+			case "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				data, _ := io.ReadAll(text.DotReader())
+				session = append(session, string(data))
+				_ = text.PrintfLine("250 queued")
+			case "QUIT":
+				_ = text.PrintfLine("221 bye")
+				received <- session
+				return
+			default:
+				session = append(session, line)
+				_ = text.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestMailer_SMTP(t *testing.T) {
+	addr, received := serveSMTP(t)
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	cfg := config.MailConfig{Transport: config.MailTransportSMTP, From: "Go User <go-user@example.com>"}
+	cfg.SMTP.Host = host
+	cfg.SMTP.Port = port
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, newTestMailer(t, cfg).Send(ctx, testMessage))
+
+	session := <-received
+	require.Len(t, session, 3)
+	assert.Equal(t, "MAIL FROM:<go-user@example.com>", session[0])
+	assert.Equal(t, "RCPT TO:<alice@example.com>", session[1])
+	assert.True(t, strings.HasSuffix(session[2], "\n\nfirst line\n.second line\n"), session[2])
+}
+
+func TestMailer_SMTPCredentialsWithoutTLS(t *testing.T) {
+	addr, _ := serveSMTP(t)
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	cfg := config.MailConfig{Transport: config.MailTransportSMTP, From: "go-user@example.com"}
+	cfg.SMTP = config.SMTPConfig{Host: host, Port: port, Username: "user", Password: "secret"}
+
+	require.ErrorIs(t, newTestMailer(t, cfg).Send(context.Background(), testMessage), errNoTLS)
+}
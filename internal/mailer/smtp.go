@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+var errNoTLS = errors.New("smtp server does not support STARTTLS, refusing to send credentials")
+
+// sendSMTP delivers the message honouring ctx deadline. STARTTLS is used
+// when offered, credentials are never sent in plain text.
+func sendSMTP(ctx context.Context, cfg config.SMTPConfig, from, to string, data []byte) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: cfg.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Username != "" {
+		if _, ok := client.TLSConnectionState(); !ok {
+			return errNoTLS
+		}
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	sender, err := envelopeAddress(from)
+	if err != nil {
+		return err
+	}
+	recipient, err := envelopeAddress(to)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Mail(sender); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
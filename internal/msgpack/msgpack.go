@@ -0,0 +1,108 @@
+// Package msgpack encodes values decoded from JSON as MessagePack.
+package msgpack
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v any) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Append appends the MessagePack encoding of a value decoded from JSON,
+// one of nil, bool, json.Number, float64, string, []any and map[string]any.
+// Map keys are sorted.
+func Append(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendInt(buf, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendFloat(buf, f), nil
+	case float64:
+		return appendFloat(buf, v), nil
+	case string:
+		return append(appendLength(buf, len(v), 0xa0, 0x1f, 0xd9, 0xda, 0xdb), v...), nil
+	case []any:
+		buf = appendLength(buf, len(v), 0x90, 0x0f, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			if buf, err = Append(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		buf = appendLength(buf, len(v), 0x80, 0x0f, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			var err error
+			if buf, err = Append(buf, key); err != nil {
+				return nil, err
+			}
+			if buf, err = Append(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+}
+
+// appendLength appends the header of a string, array or map, fix is the
+// tag of the short form holding up to fixMax items, the others are the tags of
+// the 8, 16 and 32 bit lengths, 0 when there is no 8 bit form.
+func appendLength(buf []byte, n int, fix byte, fixMax int, tag8, tag16, tag32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(buf, fix|byte(n))
+	case tag8 != 0 && n <= math.MaxUint8:
+		return append(buf, tag8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, tag16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, tag32), uint32(n))
+	}
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+	}
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+}
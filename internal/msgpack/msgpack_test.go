@@ -0,0 +1,53 @@
+package msgpack
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"bool", []any{true, false}, []byte{0x92, 0xc3, 0xc2}},
+		{"fixint", json.Number("5"), []byte{0x05}},
+		{"negative fixint", json.Number("-3"), []byte{0xfd}},
+		{"int16", json.Number("1000"), []byte{0xd1, 0x03, 0xe8}},
+		{"int64", json.Number("-4294967296"), []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00}},
+		{"float", json.Number("1.5"), []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", "abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{
+			"sorted map",
+			map[string]any{"b": json.Number("2"), "a": json.Number("1")},
+			[]byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.v)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMarshal_lengths(t *testing.T) {
+	got, err := Marshal(strings.Repeat("x", 40))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xd9, 40}, got[:2])
+
+	got, err = Marshal(make([]any, 16))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xdc, 0x00, 0x10}, got[:3])
+
+	_, err = Marshal(struct{}{})
+	assert.Error(t, err)
+}
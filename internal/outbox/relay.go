@@ -0,0 +1,192 @@
+// Package outbox delivers domain events written to the outbox table along
+// with the changes they describe.
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+const (
+	// pausedInterval is how often the config is checked while delivery
+	// is paused.
+	pausedInterval = time.Second
+	// cleanupInterval is how often delivered events are removed.
+	cleanupInterval = time.Minute
+)
+
+// Relay polls the outbox and delivers pending events to the sinks.
+type Relay struct {
+	repo   repository.OutboxRepository
+	sinks  []Sink
+	obs    *config.Observer
+	logger *zap.SugaredLogger
+	now    func() time.Time
+
+	lastCleanup time.Time
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+func NewRelay(
+	sinks []Sink,
+	repo repository.OutboxRepository,
+	obs *config.Observer,
+	lc fx.Lifecycle,
+	logger *zap.Logger,
+) *Relay {
+	relay := &Relay{
+		repo:   repo,
+		sinks:  sinks,
+		obs:    obs,
+		logger: logger.Named("OutboxRelay").Sugar(),
+		now:    time.Now,
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: relay.Start,
+		OnStop:  relay.Stop,
+	})
+
+	return relay
+}
+
+func (r *Relay) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go r.loop(ctx)
+
+	names := make([]string, 0, len(r.sinks))
+	for _, sink := range r.sinks {
+		names = append(names, sink.Name())
+	}
+	r.logger.Infow("Relaying events", "sinks", names)
+
+	return nil
+}
+
+// Stop cancels deliveries in flight, they are retried after restart.
+func (r *Relay) Stop(context.Context) error {
+	r.cancel()
+	r.wg.Wait()
+
+	return nil
+}
+
+func (r *Relay) loop(ctx context.Context) {
+	defer r.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		cfg := r.obs.Current().Outbox
+		if !cfg.Enabled() {
+			timer.Reset(pausedInterval)
+			continue
+		}
+
+		for r.relay(ctx, cfg) {
+		}
+		r.cleanup(ctx, cfg)
+
+		timer.Reset(cfg.PollInterval)
+	}
+}
+
+// relay delivers a batch of due events and reports whether more may be
+// waiting.
+func (r *Relay) relay(ctx context.Context, cfg config.OutboxConfig) bool {
+	events, err := r.repo.PendingEvents(ctx, r.now(), cfg.BatchSize)
+	if err != nil {
+		r.logger.Warnw("Error reading outbox", "err", err)
+		return false
+	}
+
+	for i := range events {
+		if !r.deliver(ctx, cfg, &events[i]) {
+			return false
+		}
+	}
+
+	return len(events) == cfg.BatchSize
+}
+
+// deliver sends the event to every sink and records the outcome, it
+// returns false when the outcome could not be recorded.
+func (r *Relay) deliver(ctx context.Context, cfg config.OutboxConfig, event *domain.Event) bool {
+	for _, sink := range r.sinks {
+		sinkCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		err := sink.Deliver(sinkCtx, event)
+		cancel()
+
+		if ctx.Err() != nil {
+			return false
+		}
+		if err == nil {
+			continue
+		}
+
+		attempts := event.Attempts + 1
+		if attempts >= cfg.MaxAttempts {
+			r.logger.Errorw("Event is dead", "id", event.Key, "type", event.Type, "sink", sink.Name(),
+				"attempts", attempts, "err", err)
+
+			if err := r.repo.MarkDead(ctx, event.ID, r.now(), sink.Name()+": "+err.Error()); err != nil {
+				r.logger.Warnw("Error recording dead event", "id", event.Key, "err", err)
+				return false
+			}
+			return true
+		}
+
+		retryAfter := cfg.RetryAfter(attempts)
+		r.logger.Warnw("Error delivering event", "id", event.Key, "type", event.Type, "sink", sink.Name(),
+			"attempts", attempts, "retry_after", retryAfter, "err", err)
+
+		if err := r.repo.MarkFailed(ctx, event.ID, r.now().Add(retryAfter), sink.Name()+": "+err.Error()); err != nil {
+			r.logger.Warnw("Error recording failed delivery", "id", event.Key, "err", err)
+			return false
+		}
+		return true
+	}
+
+	if err := r.repo.MarkDelivered(ctx, event.ID, r.now()); err != nil {
+		r.logger.Warnw("Error recording delivery", "id", event.Key, "err", err)
+		return false
+	}
+
+	return true
+}
+
+func (r *Relay) cleanup(ctx context.Context, cfg config.OutboxConfig) {
+	now := r.now()
+	if cfg.Retention == 0 || now.Sub(r.lastCleanup) < cleanupInterval {
+		return
+	}
+	r.lastCleanup = now
+
+	deleted, err := r.repo.DeleteDelivered(ctx, now.Add(-cfg.Retention))
+	if err != nil {
+		r.logger.Warnw("Error removing delivered events", "err", err)
+		return
+	}
+	if deleted > 0 {
+		r.logger.Debugw("Removed delivered events", "count", deleted)
+	}
+}
@@ -0,0 +1,153 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+type recordingSink struct {
+	events []domain.Event
+	err    error
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Deliver(_ context.Context, event *domain.Event) error {
+	s.events = append(s.events, *event)
+	return s.err
+}
+
+func Test_Relay(t *testing.T) {
+	ctx := context.Background()
+	sqlite := dbtest.New(t)
+	users := repository.NewUserDB(sqlite)
+	outbox := repository.NewOutboxDB(sqlite)
+
+	cfg := config.OutboxConfig{
+		BatchSize:     1,
+		Timeout:       time.Second,
+		MaxAttempts:   3,
+		RetryDelay:    time.Minute,
+		MaxRetryDelay: time.Hour,
+		Retention:     time.Hour,
+	}
+	obs := config.NewObserver(&config.Config{Outbox: cfg}, fxtest.NewLifecycle(t), zap.NewNop())
+
+	first, flaky := &recordingSink{}, &recordingSink{err: errors.New("unavailable")}
+	relay := NewRelay([]Sink{first, flaky}, outbox, obs, fxtest.NewLifecycle(t), zap.NewNop())
+	now := time.Now()
+	relay.now = func() time.Time { return now }
+
+	user := &domain.User{ID: uuid.New(), Login: "alice", Password: "hash", Name: "Alice", CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, users.CreateUser(ctx, user))
+	require.NoError(t, users.SetPassword(ctx, user.ID, "new-hash", now))
+	require.NoError(t, users.SetPassword(ctx, user.ID, "new-hash", now), "unchanged users publish nothing")
+
+	// failed logins are audited only
+	_, err := users.AddFailedAttempt(ctx, user.ID)
+	require.NoError(t, err)
+	lockedUntil := now.Add(time.Minute)
+	require.NoError(t, users.SetLockout(ctx, user.ID, 1, &lockedUntil))
+	now = time.Now()
+
+	t.Run("failed delivery is retried", func(t *testing.T) {
+		assert.True(t, relay.relay(ctx, cfg), "a full batch")
+		for relay.relay(ctx, cfg) {
+		}
+		require.Len(t, first.events, 2)
+
+		events, err := outbox.PendingEvents(ctx, now, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		now = now.Add(time.Minute)
+		flaky.err = nil
+		for relay.relay(ctx, cfg) {
+		}
+
+		require.Len(t, flaky.events, 4)
+		assert.Equal(t, first.events, flaky.events)
+		assert.Equal(t, first.events[0].Key, flaky.events[2].Key)
+		assert.Equal(t, 1, flaky.events[2].Attempts)
+
+		events, err = outbox.PendingEvents(ctx, now.Add(time.Hour), 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("events", func(t *testing.T) {
+		created, changed := flaky.events[2], flaky.events[3]
+		assert.NotEqual(t, created.Key, changed.Key)
+
+		assert.Equal(t, domain.EventUserCreated, created.Type)
+		assert.Equal(t, domain.EventUser{ID: user.ID, Login: "alice", Name: "Alice", Roles: []string{}}, created.User)
+		assert.Empty(t, created.Changed)
+
+		assert.Equal(t, domain.EventPasswordChanged, changed.Type)
+		assert.Equal(t, []string{"password"}, changed.Changed)
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		count := func() (n int) {
+			t.Helper()
+
+			rows, err := sqlite.QueryContext(ctx, "SELECT count(*) FROM outbox")
+			require.NoError(t, err)
+			defer rows.Close()
+			require.True(t, rows.Next())
+			require.NoError(t, rows.Scan(&n))
+
+			return n
+		}
+
+		relay.cleanup(ctx, cfg)
+		assert.Equal(t, 2, count(), "events are kept for the retention")
+
+		now = now.Add(2 * time.Hour)
+		relay.cleanup(ctx, cfg)
+		assert.Zero(t, count())
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		require.NoError(t, users.SetPassword(ctx, user.ID, "another-hash", now))
+
+		flaky.err = errors.New("unavailable")
+		for range cfg.MaxAttempts {
+			for relay.relay(ctx, cfg) {
+			}
+			now = now.Add(time.Hour)
+		}
+		require.Len(t, flaky.events, 4+cfg.MaxAttempts)
+
+		events, err := outbox.PendingEvents(ctx, now.Add(time.Hour), 10)
+		require.NoError(t, err)
+		assert.Empty(t, events, "dead events are not delivered")
+
+		var (
+			attempts  int
+			lastError string
+		)
+		rows, err := sqlite.QueryContext(ctx, "SELECT attempts, last_error FROM outbox WHERE dead_at IS NOT NULL")
+		require.NoError(t, err)
+		defer rows.Close()
+		require.True(t, rows.Next())
+		require.NoError(t, rows.Scan(&attempts, &lastError))
+		assert.Equal(t, cfg.MaxAttempts, attempts)
+		assert.Equal(t, "recording: unavailable", lastError)
+	})
+}
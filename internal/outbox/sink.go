@@ -0,0 +1,36 @@
+package outbox
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+// Sink receives events from the relay. Events are delivered at least once:
+// when any sink fails the event is delivered to every sink again, so sinks
+// should drop duplicates by event.Key.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event *domain.Event) error
+}
+
+type logSink struct {
+	logger *zap.SugaredLogger
+}
+
+// NewLogSink returns a sink writing events to the log.
+func NewLogSink(logger *zap.Logger) Sink {
+	return &logSink{logger.Named("EventLog").Sugar()}
+}
+
+func (s *logSink) Name() string {
+	return "log"
+}
+
+func (s *logSink) Deliver(_ context.Context, event *domain.Event) error {
+	s.logger.Infow("User event", "id", event.Key, "type", event.Type,
+		"user_id", event.User.ID, "login", event.User.Login, "changed", event.Changed)
+	return nil
+}
@@ -0,0 +1,364 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// maxLoginBody limits how much of a request body is read to find the login.
+const maxLoginBody = 1 << 20
+
+// Limiter applies rate limit rules from the current config to HTTP
+// requests and gRPC calls.
+type Limiter struct {
+	obs    *config.Observer
+	store  Store
+	logger *zap.SugaredLogger
+	// keys limits the rules applied to those with one of the keys, all
+	// rules apply when empty
+	keys []string
+}
+
+func NewLimiter(obs *config.Observer, store Store, logger *zap.Logger) *Limiter {
+	return &Limiter{
+		obs:    obs,
+		store:  store,
+		logger: logger.Sugar().Named("RateLimiter"),
+	}
+}
+
+// BeforeAuth returns a limiter applying the rules keyed by client IP and
+// login. It runs in front of authentication, so requests with wrong
+// credentials are limited too.
+func (l *Limiter) BeforeAuth() *Limiter {
+	return l.withKeys(config.RateLimitKeyIP, config.RateLimitKeyLogin)
+}
+
+// AfterAuth returns a limiter applying the rules keyed by user, which need
+// the authenticated caller.
+func (l *Limiter) AfterAuth() *Limiter {
+	return l.withKeys(config.RateLimitKeyUser)
+}
+
+func (l *Limiter) withKeys(keys ...string) *Limiter {
+	limiter := *l
+	limiter.keys = keys
+
+	return &limiter
+}
+
+func (l *Limiter) applies(rule config.RateLimitRule) bool {
+	return len(l.keys) == 0 || slices.Contains(l.keys, rule.Key)
+}
+
+type userKey struct{}
+
+// WithUser marks the request as made by an authenticated user, rules with
+// the "user" key then count requests per user instead of per client IP.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// request holds what rules are keyed by, login is looked up only when
+// a rule needs it.
+type request struct {
+	ip    string
+	user  string
+	login func() string
+}
+
+// allow takes a token for every rule and reports the longest wait if any
+// of the buckets is empty. Store errors let the request through.
+func (l *Limiter) allow(ctx context.Context, rules []config.RateLimitRule, req request) (bool, time.Duration) {
+	allowed, wait := true, time.Duration(0)
+
+	for _, rule := range rules {
+		value := l.keyValue(rule.Key, req)
+		if value == "" {
+			continue
+		}
+
+		ok, retryAfter, err := l.store.Take(ctx, rule.Match+"|"+value, Limit{Rate: rule.Rate(), Burst: rule.BurstSize()})
+		if err != nil {
+			l.logger.Warnw("Error checking rate limit", "rule", rule.Match, "error", err)
+			continue
+		}
+
+		if !ok {
+			l.logger.Infow("Rate limit exceeded", "rule", rule.Match, "key", rule.Key, "value", value)
+			allowed, wait = false, max(wait, retryAfter)
+		}
+	}
+
+	return allowed, wait
+}
+
+func (l *Limiter) keyValue(key string, req request) string {
+	switch key {
+	case config.RateLimitKeyUser:
+		if req.user != "" {
+			return "user:" + req.user
+		}
+		// anonymous requests are limited by address
+		return "ip:" + req.ip
+	case config.RateLimitKeyLogin:
+		if login := req.login(); login != "" {
+			return "login:" + login
+		}
+		return ""
+	default:
+		return "ip:" + req.ip
+	}
+}
+
+// Handler limits requests to routes of mux before passing them to next,
+// rules are matched against the pattern of the route serving the request.
+func (l *Limiter) Handler(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := l.obs.Current().RateLimit
+		if !cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, pattern := mux.Handler(r)
+		_, pattern = splitPattern(pattern)
+
+		var rules []config.RateLimitRule
+		for _, rule := range cfg.Rules {
+			ruleMethod, rulePattern := rule.Route()
+			if rulePattern == pattern && (ruleMethod == "" || ruleMethod == r.Method) && l.applies(rule) {
+				rules = append(rules, rule)
+			}
+		}
+
+		if len(rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		req := request{
+			ip:    ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"), cfg.Proxies()),
+			user:  userFromContext(r.Context()),
+			login: func() string { return httpLogin(r, pattern) },
+		}
+
+		if ok, wait := l.allow(r.Context(), rules, req); !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			}{ErrRateLimited.Error(), http.StatusTooManyRequests})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) UnaryInterceptor(
+	ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	login := func() string {
+		if r, ok := req.(interface{ GetLogin() string }); ok {
+			return r.GetLogin()
+		}
+		return ""
+	}
+
+	if err := l.checkGRPC(ctx, info.FullMethod, login); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor limits streams when they are opened, the login is not
+// known at that point so "login" rules don't apply.
+func (l *Limiter) StreamInterceptor(
+	srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	if err := l.checkGRPC(ss.Context(), info.FullMethod, func() string { return "" }); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+func (l *Limiter) checkGRPC(ctx context.Context, fullMethod string, login func() string) error {
+	cfg := l.obs.Current().RateLimit
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var rules []config.RateLimitRule
+	for _, rule := range cfg.Rules {
+		if rule.Match == fullMethod && l.applies(rule) {
+			rules = append(rules, rule)
+		}
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	req := request{
+		ip:    ClientIP(remoteAddr, md.Get("x-forwarded-for"), cfg.Proxies()),
+		user:  userFromContext(ctx),
+		login: login,
+	}
+
+	if ok, wait := l.allow(ctx, rules, req); !ok {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfterSeconds(wait)))
+		return status.Error(codes.ResourceExhausted, ErrRateLimited.Error())
+	}
+
+	return nil
+}
+
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is only
+// trusted when the peer is a trusted proxy, it is walked from the right
+// and the first address which is not a trusted proxy is the client.
+func ClientIP(remoteAddr string, forwardedFor []string, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trusted(addr, proxies) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range forwardedFor {
+		for hop := range strings.SplitSeq(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		client = hop
+		if !trusted(hop, proxies) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitPattern splits a mux pattern like "GET example.com/user/{login}"
+// into the method and the path part.
+func splitPattern(pattern string) (method, path string) {
+	if m, rest, ok := strings.Cut(pattern, " "); ok {
+		method, pattern = m, strings.TrimSpace(rest)
+	}
+
+	return method, pattern
+}
+
+// httpLogin returns the login from the {login} path wildcard or from the
+// "login" field of a JSON body. The body is restored for the handler.
+func httpLogin(r *http.Request, pattern string) string {
+	if login := pathValue(pattern, r.URL.Path, "login"); login != "" {
+		return login
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLoginBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	var fields struct {
+		Login string `json:"login"`
+	}
+	_ = json.Unmarshal(body, &fields)
+
+	return fields.Login
+}
+
+// pathValue matches path against the pattern segments and returns the
+// segment in place of the {name} wildcard.
+func pathValue(pattern, path, name string) string {
+	if i := strings.Index(pattern, "/"); i > 0 {
+		// host part
+		pattern = pattern[i:]
+	}
+
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+
+	for i, segment := range patternSegments {
+		if i >= len(pathSegments) {
+			break
+		}
+		if segment == "{"+name+"}" {
+			return pathSegments[i]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+func Test_memoryStore_Take(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time { return now }
+
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for range 2 {
+		ok, _, err := store.Take(context.Background(), "k", limit)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, wait, err := store.Take(context.Background(), "k", limit)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// other keys have their own buckets
+	ok, _, _ = store.Take(context.Background(), "other", limit)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	ok, _, _ = store.Take(context.Background(), "k", limit)
+	assert.True(t, ok)
+
+	// refilled buckets are forgotten
+	now = now.Add(time.Hour)
+	store.sweep(now)
+	assert.Empty(t, store.buckets)
+}
+
+func newTestLimiter(t *testing.T, rules ...config.RateLimitRule) *Limiter {
+	t.Helper()
+
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{
+		Enabled:        true,
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Rules:          rules,
+	}}
+	logger := zap.NewNop()
+
+	return NewLimiter(config.NewObserver(cfg, fxtest.NewLifecycle(t), logger), NewMemoryStore(), logger)
+}
+
+func TestLimiter_Handler(t *testing.T) {
+	limiter := newTestLimiter(t,
+		config.RateLimitRule{Match: "POST /user/", Key: config.RateLimitKeyLogin, Requests: 1, Period: time.Minute},
+		config.RateLimitRule{Match: "/user/{login}", Key: config.RateLimitKeyIP, Requests: 2, Period: time.Minute},
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/user/{login}", func(http.ResponseWriter, *http.Request) {})
+	handler := limiter.Handler(mux, mux)
+
+	serve := func(method, path, body, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("login from body", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("POST", "/user/", `{"login":"bob"}`, "1.1.1.1:1").Code)
+		assert.Equal(t, http.StatusOK, serve("POST", "/user/", `{"login":"alice"}`, "1.1.1.1:1").Code)
+
+		w := serve("POST", "/user/", `{"login":"bob"}`, "2.2.2.2:1")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+		assert.JSONEq(t, `{"code":429,"message":"rate limit exceeded"}`, w.Body.String())
+
+		// the rule is bound to the method
+		assert.Equal(t, http.StatusOK, serve("GET", "/user/", "", "2.2.2.2:1").Code)
+	})
+
+	t.Run("client ip", func(t *testing.T) {
+		for range 2 {
+			assert.Equal(t, http.StatusOK, serve("GET", "/user/bob", "", "3.3.3.3:1").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, serve("GET", "/user/bob", "", "3.3.3.3:2").Code)
+		assert.Equal(t, http.StatusOK, serve("GET", "/user/bob", "", "4.4.4.4:1").Code)
+	})
+}
+
+func TestLimiter_Interceptors(t *testing.T) {
+	limiter := newTestLimiter(t,
+		config.RateLimitRule{Match: healthpb.Health_Check_FullMethodName, Key: config.RateLimitKeyIP, Requests: 2, Period: time.Minute},
+		config.RateLimitRule{Match: healthpb.Health_Watch_FullMethodName, Key: config.RateLimitKeyIP, Requests: 1, Period: time.Minute},
+	)
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(limiter.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(limiter.StreamInterceptor),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	client := healthpb.NewHealthClient(conn)
+
+	t.Run("unary", func(t *testing.T) {
+		for range 2 {
+			_, err := client.Check(t.Context(), &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+		}
+
+		var header metadata.MD
+		_, err := client.Check(t.Context(), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, []string{"30"}, header.Get("retry-after"))
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		cancel()
+
+		stream, err = client.Watch(t.Context(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		header, err := stream.Header()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"60"}, header.Get("retry-after"))
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct", "1.2.3.4:5000", nil, "1.2.3.4"},
+		{"untrusted peer", "1.2.3.4:5000", []string{"5.5.5.5"}, "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:5000", []string{"5.5.5.5"}, "5.5.5.5"},
+		{"spoofed header", "10.0.0.1:5000", []string{"6.6.6.6, 5.5.5.5, 10.0.0.2"}, "5.5.5.5"},
+		{"only proxies", "10.0.0.1:5000", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"unix socket", "@", []string{"5.5.5.5"}, "@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClientIP(tt.remoteAddr, tt.forwardedFor, proxies))
+		})
+	}
+}
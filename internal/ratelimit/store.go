@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket refilled with Rate tokens per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Store keeps token buckets. The in-process MemoryStore is enough for a
+// single instance, replicas need a shared implementation to enforce a
+// common limit.
+type Store interface {
+	// Take takes a token from the bucket stored under key. When the bucket
+	// is empty it returns false and the time until the next token.
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// sweepInterval is how often full buckets are dropped from MemoryStore.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket refills completely and can be forgotten
+	full time.Time
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *memoryStore) Take(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	now := s.now()
+	burst := float64(limit.Burst)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst}
+		s.buckets[key] = b
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	}
+	b.updated = now
+
+	allowed, wait := true, time.Duration(0)
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		allowed = false
+		wait = seconds((1 - b.tokens) / limit.Rate)
+	}
+
+	b.full = now.Add(seconds((burst - b.tokens) / limit.Rate))
+
+	return allowed, wait, nil
+}
+
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
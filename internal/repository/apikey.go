@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*domain.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+func NewAPIKeyDB(db db.DB) APIKeyRepository {
+	return &APIKeyDB{db}
+}
+
+type APIKeyDB struct {
+	db db.DB
+}
+
+var _ APIKeyRepository = (*APIKeyDB)(nil)
+
+const (
+	sqlSelectAPIKey = `SELECT id, name, secret_hash, scopes, created_at, expires_at, last_used_at, revoked_at FROM api_keys `
+	SQLGetAPIKey    = sqlSelectAPIKey + `WHERE id = ?`
+	SQLListAPIKeys  = sqlSelectAPIKey + `ORDER BY created_at`
+	SQLCreateAPIKey = `INSERT INTO api_keys (id, name, secret_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`
+	SQLRevokeAPIKey = `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	SQLTouchAPIKey  = `UPDATE api_keys SET last_used_at = ? WHERE id = ?`
+)
+
+func (a *APIKeyDB) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	_, err := a.db.ExecContext(ctx, SQLCreateAPIKey,
+		key.ID, key.Name, key.SecretHash, strings.Join(key.Scopes, " "), key.CreatedAt, key.ExpiresAt,
+	)
+	return err
+}
+
+func (a *APIKeyDB) GetAPIKey(ctx context.Context, id string) (*domain.APIKey, error) {
+	rows, err := a.db.QueryContext(ctx, SQLGetAPIKey, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return scanAPIKey(rows)
+}
+
+func (a *APIKeyDB) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	rows, err := a.db.QueryContext(ctx, SQLListAPIKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func scanAPIKey(rows *sql.Rows) (*domain.APIKey, error) {
+	var (
+		key                            domain.APIKey
+		scopes                         string
+		expiresAt, lastUsedAt, revoked sql.NullTime
+	)
+	if err := rows.Scan(
+		&key.ID, &key.Name, &key.SecretHash, &scopes, &key.CreatedAt, &expiresAt, &lastUsedAt, &revoked,
+	); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revoked.Valid {
+		key.RevokedAt = &revoked.Time
+	}
+
+	return &key, nil
+}
+
+// RevokeAPIKey disables the key, revoked keys are kept for the record.
+func (a *APIKeyDB) RevokeAPIKey(ctx context.Context, id string, revokedAt time.Time) error {
+	revoked, err := updated(a.db.ExecContext(ctx, SQLRevokeAPIKey, revokedAt, id))
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (a *APIKeyDB) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := a.db.ExecContext(ctx, SQLTouchAPIKey, usedAt, id)
+	return err
+}
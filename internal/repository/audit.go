@@ -0,0 +1,288 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/audit"
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+type AuditRepository interface {
+	ListAuditEvents(ctx context.Context, filter *domain.AuditFilter) ([]domain.AuditEvent, error)
+}
+
+func NewAuditDB(db db.DB) AuditRepository {
+	return &AuditDB{db}
+}
+
+type AuditDB struct {
+	db db.DB
+}
+
+var _ AuditRepository = (*AuditDB)(nil)
+
+// redacted replaces values of secret fields in diffs.
+const redacted = "[redacted]"
+
+const (
+	// SQLGetAuditState reads what audit events compare, TOTP secrets,
+	// recovery codes and passkeys are only summarized.
+	SQLGetAuditState = `SELECT login, password, name, email, email_verified_at, failed_attempts, locked_until, ` +
+		`(SELECT group_concat(role, ' ') FROM (SELECT role FROM user_roles WHERE user_id = users.id ORDER BY role)), ` +
+		`(SELECT confirmed_at IS NOT NULL FROM user_totp WHERE user_id = users.id), ` +
+		`(SELECT created_at FROM user_totp WHERE user_id = users.id), ` +
+		`(SELECT count(*) FROM user_recovery_codes WHERE user_id = users.id AND used_at IS NULL), ` +
+		`(SELECT count(*) FROM user_passkeys WHERE user_id = users.id), ` +
+		`(SELECT max(last_used_at) FROM user_passkeys WHERE user_id = users.id) ` +
+		`FROM users WHERE id = ?`
+	SQLInsertAuditEvent = `INSERT INTO audit_events ` +
+		`(created_at, actor, action, user_id, login, request_id, client_ip, diff) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlSelectAuditEvents = `SELECT id, created_at, actor, action, user_id, login, request_id, client_ip, diff ` +
+		`FROM audit_events WHERE id > ?`
+)
+
+// audited records a change made through UserDB, see audited.
+func (u *UserDB) audited(ctx context.Context, action string, userID uuid.UUID, change func(q db.Querier) error) error {
+	return audited(ctx, u.db, action, userID, change)
+}
+
+// audited runs change in a transaction along with its audit event and the
+// domain event published through the outbox. Changes which leave the user
+// as it was are not recorded.
+func audited(ctx context.Context, conn db.DB, action string, userID uuid.UUID, change func(q db.Querier) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := recordChange(ctx, tx, action, userID, change); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// recordChange is audited for a change which is a part of a larger
+// transaction.
+func recordChange(ctx context.Context, q db.Querier, action string, userID uuid.UUID, change func(q db.Querier) error) error {
+	before, err := auditState(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := change(q); err != nil {
+		return err
+	}
+
+	after, err := auditState(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+
+	diff := auditDiff(before, after)
+	if len(diff) == 0 {
+		return nil
+	}
+
+	if event := userEvent(userID, before, after, diff); event != nil {
+		if err := insertEvent(ctx, q, event); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+
+	login, _ := after["login"].(string)
+	if login == "" {
+		login, _ = before["login"].(string)
+	}
+
+	var requestID, clientIP sql.NullString
+	if request, ok := audit.FromContext(ctx); ok {
+		requestID = sql.NullString{String: request.ID, Valid: request.ID != ""}
+		clientIP = sql.NullString{String: request.ClientIP, Valid: request.ClientIP != ""}
+	}
+
+	_, err = q.ExecContext(ctx, SQLInsertAuditEvent,
+		time.Now().UTC(), audit.Actor(ctx), action, userID, login, requestID, clientIP, string(encoded),
+	)
+
+	return err
+}
+
+// auditState returns the fields of the user compared by audit events, nil
+// when the user doesn't exist. totp_created_at tells apart a new secret
+// replacing one which was not confirmed yet, passkey_last_used_at records
+// passkey logins.
+func auditState(ctx context.Context, q db.Querier, userID uuid.UUID) (map[string]any, error) {
+	rows, err := q.QueryContext(ctx, SQLGetAuditState, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var (
+		login, password, name        string
+		email, roles                 sql.NullString
+		emailVerifiedAt, lockedUntil sql.NullTime
+		failedAttempts               int
+		totp                         sql.NullBool
+		totpCreatedAt                sql.NullString
+		recoveryCodes, passkeys      int
+		passkeyLastUsedAt            sql.NullString
+	)
+	if err := rows.Scan(
+		&login, &password, &name, &email, &emailVerifiedAt, &failedAttempts, &lockedUntil,
+		&roles, &totp, &totpCreatedAt, &recoveryCodes, &passkeys, &passkeyLastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	totpState := "disabled"
+	switch {
+	case totp.Valid && totp.Bool:
+		totpState = "enabled"
+	case totp.Valid:
+		totpState = "enrolled"
+	}
+
+	return map[string]any{
+		"login":                login,
+		"password":             password,
+		"name":                 name,
+		"email":                email.String,
+		"email_verified_at":    auditTime(emailVerifiedAt),
+		"failed_attempts":      failedAttempts,
+		"locked_until":         auditTime(lockedUntil),
+		"roles":                strings.Fields(roles.String),
+		"totp":                 totpState,
+		"totp_created_at":      totpCreatedAt.String,
+		"recovery_codes":       recoveryCodes,
+		"passkeys":             passkeys,
+		"passkey_last_used_at": passkeyLastUsedAt.String,
+	}, nil
+}
+
+func auditTime(t sql.NullTime) any {
+	if !t.Valid {
+		return nil
+	}
+
+	return t.Time.UTC().Format(time.RFC3339Nano)
+}
+
+// auditDiff returns the fields which differ, password hashes are redacted.
+// Unset fields of new and deleted users are left out.
+func auditDiff(before, after map[string]any) map[string]domain.AuditChange {
+	diff := map[string]domain.AuditChange{}
+
+	if after == nil {
+		for field, previous := range before {
+			if previous == nil {
+				continue
+			}
+			if field == "password" {
+				previous = redacted
+			}
+			diff[field] = domain.AuditChange{Before: previous}
+		}
+		return diff
+	}
+
+	for field, value := range after {
+		previous, ok := before[field]
+		if ok && reflect.DeepEqual(previous, value) || !ok && value == nil {
+			continue
+		}
+
+		if field == "password" {
+			if ok {
+				previous = redacted
+			}
+			value = redacted
+		}
+
+		diff[field] = domain.AuditChange{Before: previous, After: value}
+	}
+
+	return diff
+}
+
+func (a *AuditDB) ListAuditEvents(ctx context.Context, filter *domain.AuditFilter) ([]domain.AuditEvent, error) {
+	query, args := sqlSelectAuditEvents, []any{filter.AfterID}
+
+	where := func(condition string, arg any) {
+		query += " AND " + condition
+		args = append(args, arg)
+	}
+	if filter.Actor != "" {
+		where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.UserID != nil {
+		where("user_id = ?", *filter.UserID)
+	}
+	if filter.Login != "" {
+		where("login = ?", filter.Login)
+	}
+	if filter.RequestID != "" {
+		where("request_id = ?", filter.RequestID)
+	}
+	if filter.Since != nil {
+		where("created_at >= ?", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		where("created_at < ?", filter.Until.UTC())
+	}
+
+	query += " ORDER BY id LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.AuditEvent
+	for rows.Next() {
+		var (
+			event               domain.AuditEvent
+			requestID, clientIP sql.NullString
+			diff                string
+		)
+		if err := rows.Scan(
+			&event.ID, &event.CreatedAt, &event.Actor, &event.Action, &event.UserID, &event.Login,
+			&requestID, &clientIP, &diff,
+		); err != nil {
+			return nil, err
+		}
+
+		event.RequestID, event.ClientIP = requestID.String, clientIP.String
+		if err := json.Unmarshal([]byte(diff), &event.Diff); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+type IdempotencyRepository interface {
+	// Reserve claims the key of the record for a new request. When the
+	// caller already used the key the earlier record is returned instead.
+	// Expired records and those still pending since abandonedBefore are
+	// dropped first.
+	Reserve(ctx context.Context, record *domain.IdempotencyRecord, abandonedBefore time.Time) (*domain.IdempotencyRecord, error)
+	// Complete stores the response of the request.
+	Complete(ctx context.Context, caller, key string, response []byte) error
+	// Release frees the key of a failed request, so that it can be retried.
+	Release(ctx context.Context, caller, key string) error
+}
+
+func NewIdempotencyDB(db db.DB) IdempotencyRepository {
+	return &IdempotencyDB{db}
+}
+
+type IdempotencyDB struct {
+	db db.DB
+}
+
+var _ IdempotencyRepository = (*IdempotencyDB)(nil)
+
+const (
+	SQLDeleteIdempotencyKeys = `DELETE FROM idempotency_keys WHERE expires_at < ? OR (response IS NULL AND created_at < ?)`
+	SQLReserveIdempotencyKey = `INSERT INTO idempotency_keys (caller, key, fingerprint, created_at, expires_at) ` +
+		`VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`
+	SQLGetIdempotencyKey = `SELECT caller, key, fingerprint, response, created_at, expires_at ` +
+		`FROM idempotency_keys WHERE caller = ? AND key = ?`
+	SQLCompleteIdempotencyKey = `UPDATE idempotency_keys SET response = ? WHERE caller = ? AND key = ?`
+	SQLReleaseIdempotencyKey  = `DELETE FROM idempotency_keys WHERE caller = ? AND key = ?`
+)
+
+func (i *IdempotencyDB) Reserve(
+	ctx context.Context, record *domain.IdempotencyRecord, abandonedBefore time.Time,
+) (*domain.IdempotencyRecord, error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, SQLDeleteIdempotencyKeys, record.CreatedAt, abandonedBefore); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, SQLReserveIdempotencyKey,
+		record.Caller, record.Key, record.Fingerprint, record.CreatedAt, record.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 1 {
+		return nil, tx.Commit()
+	}
+
+	earlier, err := getIdempotencyRecord(ctx, tx, record.Caller, record.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return earlier, tx.Commit()
+}
+
+func getIdempotencyRecord(ctx context.Context, q db.Querier, caller, key string) (*domain.IdempotencyRecord, error) {
+	rows, err := q.QueryContext(ctx, SQLGetIdempotencyKey, caller, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var record domain.IdempotencyRecord
+	if err := rows.Scan(
+		&record.Caller, &record.Key, &record.Fingerprint, &record.Response, &record.CreatedAt, &record.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (i *IdempotencyDB) Complete(ctx context.Context, caller, key string, response []byte) error {
+	_, err := i.db.ExecContext(ctx, SQLCompleteIdempotencyKey, response, caller, key)
+	return err
+}
+
+func (i *IdempotencyDB) Release(ctx context.Context, caller, key string) error {
+	_, err := i.db.ExecContext(ctx, SQLReleaseIdempotencyKey, caller, key)
+	return err
+}
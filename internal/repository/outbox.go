@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+type OutboxRepository interface {
+	// PendingEvents returns undelivered events due at now, oldest first.
+	PendingEvents(ctx context.Context, now time.Time, limit int) ([]domain.Event, error)
+	MarkDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+	MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastErr string) error
+	// MarkDead stops delivery of an event out of attempts, it stays in the
+	// outbox with the last error.
+	MarkDead(ctx context.Context, id int64, deadAt time.Time, lastErr string) error
+	// DeleteDelivered removes events delivered before the given time.
+	DeleteDelivered(ctx context.Context, before time.Time) (int64, error)
+	// EventsAfter returns events with revision (ID) greater than the given
+	// one in order.
+	EventsAfter(ctx context.Context, revision int64, limit int) ([]domain.Event, error)
+	// Revisions returns the oldest revision kept and the latest one, the
+	// oldest is latest+1 when the outbox is empty.
+	Revisions(ctx context.Context) (oldest, latest int64, err error)
+}
+
+func NewOutboxDB(db db.DB) OutboxRepository {
+	return &OutboxDB{db}
+}
+
+type OutboxDB struct {
+	db db.DB
+}
+
+var _ OutboxRepository = (*OutboxDB)(nil)
+
+const (
+	SQLInsertEvent   = `INSERT INTO outbox (key, type, payload, created_at, next_attempt_at) VALUES (?, ?, ?, ?, ?)`
+	SQLPendingEvents = `SELECT id, payload, attempts FROM outbox ` +
+		`WHERE delivered_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ? ORDER BY id LIMIT ?`
+	SQLMarkDelivered   = `UPDATE outbox SET delivered_at = ?, last_error = NULL WHERE id = ?`
+	SQLMarkFailed      = `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?`
+	SQLMarkDead        = `UPDATE outbox SET attempts = attempts + 1, dead_at = ?, last_error = ? WHERE id = ?`
+	SQLDeleteDelivered = `DELETE FROM outbox WHERE delivered_at < ?`
+	SQLEventsAfter     = `SELECT id, payload, attempts FROM outbox WHERE id > ? ORDER BY id LIMIT ?`
+	SQLRevisions       = `SELECT (SELECT min(id) FROM outbox), ` +
+		`coalesce((SELECT seq FROM sqlite_sequence WHERE name = 'outbox'), 0)`
+)
+
+// loginFields are kept by logins. They are audited but not published:
+// anyone can change them with a wrong password and partners don't need to
+// learn about logins.
+var loginFields = []string{"failed_attempts", "locked_until", "passkey_last_used_at"}
+
+// userEvent describes the change between two states returned by
+// auditState, nil when only loginFields changed.
+func userEvent(userID uuid.UUID, before, after map[string]any, diff map[string]domain.AuditChange) *domain.Event {
+	event := &domain.Event{
+		Key:        uuid.NewString(),
+		Type:       domain.EventUserUpdated,
+		OccurredAt: time.Now().UTC(),
+	}
+
+	state := after
+	_, passwordChanged := diff["password"]
+	switch {
+	case before == nil:
+		event.Type = domain.EventUserCreated
+	case after == nil:
+		event.Type = domain.EventUserDeleted
+		state = before
+	case passwordChanged:
+		event.Type = domain.EventPasswordChanged
+	}
+
+	if event.Type != domain.EventUserCreated && event.Type != domain.EventUserDeleted {
+		for field := range diff {
+			if slices.Contains(loginFields, field) {
+				continue
+			}
+			// a new secret replacing an unconfirmed one is a change of totp
+			if field == "totp_created_at" {
+				field = "totp"
+			}
+			if !slices.Contains(event.Changed, field) {
+				event.Changed = append(event.Changed, field)
+			}
+		}
+		slices.Sort(event.Changed)
+
+		if len(event.Changed) == 0 {
+			return nil
+		}
+	}
+
+	event.User = domain.EventUser{ID: userID}
+	event.User.Login, _ = state["login"].(string)
+	event.User.Name, _ = state["name"].(string)
+	event.User.Email, _ = state["email"].(string)
+	event.User.EmailVerified = state["email_verified_at"] != nil
+	event.User.Roles, _ = state["roles"].([]string)
+
+	return event
+}
+
+func insertEvent(ctx context.Context, q db.Querier, event *domain.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, SQLInsertEvent, event.Key, event.Type, string(payload), event.OccurredAt, event.OccurredAt)
+	return err
+}
+
+func (o *OutboxDB) PendingEvents(ctx context.Context, now time.Time, limit int) ([]domain.Event, error) {
+	return o.events(ctx, SQLPendingEvents, now.UTC(), limit)
+}
+
+func (o *OutboxDB) EventsAfter(ctx context.Context, revision int64, limit int) ([]domain.Event, error) {
+	return o.events(ctx, SQLEventsAfter, revision, limit)
+}
+
+func (o *OutboxDB) Revisions(ctx context.Context) (int64, int64, error) {
+	rows, err := o.db.QueryContext(ctx, SQLRevisions)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, 0, rows.Err()
+	}
+
+	var (
+		oldest sql.NullInt64
+		latest int64
+	)
+	if err := rows.Scan(&oldest, &latest); err != nil {
+		return 0, 0, err
+	}
+
+	if !oldest.Valid {
+		return latest + 1, latest, nil
+	}
+
+	return oldest.Int64, latest, nil
+}
+
+func (o *OutboxDB) events(ctx context.Context, query string, args ...any) ([]domain.Event, error) {
+	rows, err := o.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.Event
+	for rows.Next() {
+		var (
+			event    domain.Event
+			id       int64
+			payload  string
+			attempts int
+		)
+		if err := rows.Scan(&id, &payload, &attempts); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			return nil, err
+		}
+		event.ID, event.Attempts = id, attempts
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (o *OutboxDB) MarkDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	_, err := o.db.ExecContext(ctx, SQLMarkDelivered, deliveredAt.UTC(), id)
+	return err
+}
+
+func (o *OutboxDB) MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastErr string) error {
+	_, err := o.db.ExecContext(ctx, SQLMarkFailed, nextAttemptAt.UTC(), lastErr, id)
+	return err
+}
+
+func (o *OutboxDB) MarkDead(ctx context.Context, id int64, deadAt time.Time, lastErr string) error {
+	_, err := o.db.ExecContext(ctx, SQLMarkDead, deadAt.UTC(), lastErr, id)
+	return err
+}
+
+func (o *OutboxDB) DeleteDelivered(ctx context.Context, before time.Time) (int64, error) {
+	result, err := o.db.ExecContext(ctx, SQLDeleteDelivered, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+var (
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	ErrPasskeyExists   = errors.New("passkey is already registered")
+)
+
+type PasskeyRepository interface {
+	ListPasskeys(ctx context.Context, userID uuid.UUID) ([]domain.Passkey, error)
+	GetPasskeyOwner(ctx context.Context, id []byte) (string, error)
+	CreatePasskey(ctx context.Context, passkey *domain.Passkey) error
+	UpdatePasskey(ctx context.Context, passkey *domain.Passkey) error
+	DeletePasskey(ctx context.Context, userID uuid.UUID, id []byte) error
+}
+
+func NewPasskeyDB(db db.DB) PasskeyRepository {
+	return &PasskeyDB{db}
+}
+
+type PasskeyDB struct {
+	db db.DB
+}
+
+var _ PasskeyRepository = (*PasskeyDB)(nil)
+
+const (
+	SQLListPasskeys = `SELECT id, user_id, name, credential, sign_count, created_at, last_used_at ` +
+		`FROM user_passkeys WHERE user_id = ? ORDER BY created_at`
+	SQLGetPasskeyOwner = `SELECT users.login FROM user_passkeys JOIN users ON users.id = user_passkeys.user_id ` +
+		`WHERE user_passkeys.id = ?`
+	SQLCreatePasskey = `INSERT INTO user_passkeys (id, user_id, name, credential, sign_count, created_at) ` +
+		`VALUES (?, ?, ?, ?, ?, ?)`
+	SQLUpdatePasskey = `UPDATE user_passkeys SET credential = ?, sign_count = ?, last_used_at = ? WHERE id = ?`
+	SQLDeletePasskey = `DELETE FROM user_passkeys WHERE user_id = ? AND id = ?`
+)
+
+func (p *PasskeyDB) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]domain.Passkey, error) {
+	rows, err := p.db.QueryContext(ctx, SQLListPasskeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []domain.Passkey
+	for rows.Next() {
+		var (
+			passkey    domain.Passkey
+			lastUsedAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.Credential, &passkey.SignCount,
+			&passkey.CreatedAt, &lastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if lastUsedAt.Valid {
+			passkey.LastUsedAt = &lastUsedAt.Time
+		}
+
+		passkeys = append(passkeys, passkey)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// GetPasskeyOwner returns the login of the user the passkey belongs to.
+func (p *PasskeyDB) GetPasskeyOwner(ctx context.Context, id []byte) (string, error) {
+	return passkeyOwner(ctx, p.db, id)
+}
+
+func passkeyOwner(ctx context.Context, q db.Querier, id []byte) (string, error) {
+	rows, err := q.QueryContext(ctx, SQLGetPasskeyOwner, id)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", ErrPasskeyNotFound
+	}
+
+	var login string
+	if err := rows.Scan(&login); err != nil {
+		return "", err
+	}
+
+	return login, nil
+}
+
+func (p *PasskeyDB) CreatePasskey(ctx context.Context, passkey *domain.Passkey) error {
+	return audited(ctx, p.db, domain.AuditAddPasskey, passkey.UserID, func(q db.Querier) error {
+		_, err := passkeyOwner(ctx, q, passkey.ID)
+		if err == nil {
+			return ErrPasskeyExists
+		}
+		if !errors.Is(err, ErrPasskeyNotFound) {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, SQLCreatePasskey,
+			passkey.ID, passkey.UserID, passkey.Name, passkey.Credential, passkey.SignCount, passkey.CreatedAt,
+		)
+		return err
+	})
+}
+
+// UpdatePasskey stores the credential record and the counter after a login.
+func (p *PasskeyDB) UpdatePasskey(ctx context.Context, passkey *domain.Passkey) error {
+	return audited(ctx, p.db, domain.AuditUsePasskey, passkey.UserID, func(q db.Querier) error {
+		_, err := q.ExecContext(ctx, SQLUpdatePasskey, passkey.Credential, passkey.SignCount, passkey.LastUsedAt, passkey.ID)
+		return err
+	})
+}
+
+func (p *PasskeyDB) DeletePasskey(ctx context.Context, userID uuid.UUID, id []byte) error {
+	return audited(ctx, p.db, domain.AuditDeletePasskey, userID, func(q db.Querier) error {
+		deleted, err := updated(q.ExecContext(ctx, SQLDeletePasskey, userID, id))
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return ErrPasskeyNotFound
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+const (
+	SQLGetRoles    = `SELECT role FROM user_roles WHERE user_id = ? ORDER BY role`
+	SQLDeleteRoles = `DELETE FROM user_roles WHERE user_id = ?`
+	SQLInsertRoles = `INSERT INTO user_roles (user_id, role) VALUES `
+)
+
+func (u *UserDB) GetRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := u.db.QueryContext(ctx, SQLGetRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// SetRoles replaces roles of the user.
+func (u *UserDB) SetRoles(ctx context.Context, userID uuid.UUID, roles []string) error {
+	return u.audited(ctx, domain.AuditSetRoles, userID, func(q db.Querier) error {
+		if _, err := q.ExecContext(ctx, SQLDeleteRoles, userID); err != nil {
+			return err
+		}
+
+		if len(roles) == 0 {
+			return nil
+		}
+
+		values := make([]string, 0, len(roles))
+		args := make([]any, 0, 2*len(roles))
+		for _, role := range roles {
+			values = append(values, "(?, ?)")
+			args = append(args, userID, role)
+		}
+
+		_, err := q.ExecContext(ctx, SQLInsertRoles+strings.Join(values, ", "), args...)
+		return err
+	})
+}
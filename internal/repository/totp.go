@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+const (
+	SQLGetTOTP = `SELECT user_id, secret, confirmed_at, last_step, created_at, ` +
+		`(SELECT count(*) FROM user_recovery_codes WHERE user_id = user_totp.user_id AND used_at IS NULL) ` +
+		`FROM user_totp WHERE user_id = ?`
+	SQLSaveTOTP = `INSERT OR REPLACE INTO user_totp (user_id, secret, confirmed_at, last_step, created_at) ` +
+		`VALUES (?, ?, ?, ?, ?)`
+	SQLConfirmTOTP         = `UPDATE user_totp SET confirmed_at = ? WHERE user_id = ?`
+	SQLUseTOTPStep         = `UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`
+	SQLDeleteRecoveryCodes = `DELETE FROM user_recovery_codes WHERE user_id = ?`
+	SQLInsertRecoveryCodes = `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES `
+	SQLUseRecoveryCode     = `UPDATE user_recovery_codes SET used_at = ? ` +
+		`WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+)
+
+func (u *UserDB) GetTOTP(ctx context.Context, userID uuid.UUID) (*domain.TOTP, error) {
+	rows, err := u.db.QueryContext(ctx, SQLGetTOTP, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrTOTPNotFound
+	}
+
+	var (
+		totp        domain.TOTP
+		confirmedAt sql.NullTime
+	)
+	if err := rows.Scan(
+		&totp.UserID, &totp.Secret, &confirmedAt, &totp.LastStep, &totp.CreatedAt, &totp.RecoveryCodes,
+	); err != nil {
+		return nil, err
+	}
+
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = &confirmedAt.Time
+	}
+
+	return &totp, nil
+}
+
+// SaveTOTP stores the secret replacing the previous one of the user.
+func (u *UserDB) SaveTOTP(ctx context.Context, totp *domain.TOTP) error {
+	return u.audited(ctx, domain.AuditEnrollTOTP, totp.UserID, func(q db.Querier) error {
+		_, err := q.ExecContext(ctx, SQLSaveTOTP, totp.UserID, totp.Secret, totp.ConfirmedAt, totp.LastStep, totp.CreatedAt)
+		return err
+	})
+}
+
+// ConfirmTOTP enables the secret and replaces recovery codes of the user.
+func (u *UserDB) ConfirmTOTP(ctx context.Context, userID uuid.UUID, confirmedAt time.Time, recoveryCodeHashes []string) error {
+	return u.audited(ctx, domain.AuditConfirmTOTP, userID, func(q db.Querier) error {
+		if _, err := q.ExecContext(ctx, SQLDeleteRecoveryCodes, userID); err != nil {
+			return err
+		}
+
+		if len(recoveryCodeHashes) > 0 {
+			values := make([]string, 0, len(recoveryCodeHashes))
+			args := make([]any, 0, 2*len(recoveryCodeHashes))
+			for _, hash := range recoveryCodeHashes {
+				values = append(values, "(?, ?)")
+				args = append(args, userID, hash)
+			}
+
+			if _, err := q.ExecContext(ctx, SQLInsertRecoveryCodes+strings.Join(values, ", "), args...); err != nil {
+				return err
+			}
+		}
+
+		_, err := q.ExecContext(ctx, SQLConfirmTOTP, confirmedAt, userID)
+		return err
+	})
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false
+// when the step or a later one was already used, so a code can't be
+// replayed.
+func (u *UserDB) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	return updated(u.db.ExecContext(ctx, SQLUseTOTPStep, step, userID, step))
+}
+
+// UseRecoveryCode marks the code as used, it returns false for unknown or
+// already used codes.
+func (u *UserDB) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string, usedAt time.Time) (bool, error) {
+	var used bool
+	err := u.audited(ctx, domain.AuditUseRecoveryCode, userID, func(q db.Querier) (err error) {
+		used, err = updated(q.ExecContext(ctx, SQLUseRecoveryCode, usedAt, userID, codeHash))
+		return err
+	})
+
+	return used, err
+}
+
+func updated(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
@@ -2,7 +2,13 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
 
 	"github.com/iliadmitriev/go-user-test/internal/db"
 	"github.com/iliadmitriev/go-user-test/internal/domain"
@@ -11,12 +17,39 @@ import (
 var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrUserLoginExists = errors.New("user with login already exists")
+	ErrTOTPNotFound    = errors.New("totp not found")
+	ErrVersionMismatch = errors.New("user version mismatch")
+	ErrUserEmailExists = errors.New("user with email already exists")
 )
 
 //go:generate mockery --name=UserRepository --output=../../internal/mocks/ --dry-run=false --with-expecter
 type UserRepository interface {
 	GetUser(ctx context.Context, login string) (*domain.User, error)
 	CreateUser(ctx context.Context, user *domain.User) error
+	// CreateUsers creates all users or none of them.
+	CreateUsers(ctx context.Context, users []domain.User) error
+	// ListUsers returns users with login greater than after ordered by
+	// login, with password hashes and emails.
+	ListUsers(ctx context.Context, after string, limit int) ([]domain.User, error)
+	// UpdateUser saves the name and email of the user if it is still at
+	// the expected version.
+	UpdateUser(ctx context.Context, user *domain.User, expectedVersion int64) error
+	// DeleteUser removes the user along with its roles, TOTP secret,
+	// recovery codes and passkeys.
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	GetUserAuth(ctx context.Context, login string) (*domain.User, error)
+	AddFailedAttempt(ctx context.Context, id uuid.UUID) (int, error)
+	SetLockout(ctx context.Context, id uuid.UUID, failedAttempts int, lockedUntil *time.Time) error
+	GetTOTP(ctx context.Context, userID uuid.UUID) (*domain.TOTP, error)
+	SaveTOTP(ctx context.Context, totp *domain.TOTP) error
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, confirmedAt time.Time, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string, usedAt time.Time) (bool, error)
+	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	SetEmailVerified(ctx context.Context, id uuid.UUID, verifiedAt time.Time) error
+	SetPassword(ctx context.Context, id uuid.UUID, password string, updatedAt time.Time) error
+	GetRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	SetRoles(ctx context.Context, userID uuid.UUID, roles []string) error
 }
 
 func NewUserDB(db db.DB) UserRepository {
@@ -30,19 +63,44 @@ type UserDB struct {
 var _ UserRepository = (*UserDB)(nil)
 
 const (
-	SQLGetUser    = `SELECT id, login, name, created_at, updated_at FROM users WHERE login = ?`
-	SQLCreateUser = `INSERT INTO users (id, login, password, name, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+	SQLGetUser    = `SELECT id, login, name, created_at, updated_at, version FROM users WHERE login = ?`
+	SQLCreateUser = `INSERT INTO users (id, login, password, name, created_at, updated_at, email) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	sqlSelectUserAuth = `SELECT id, login, password, name, created_at, updated_at, failed_attempts, locked_until, ` +
+		`email, email_verified_at, version FROM users `
+	SQLGetUserAuth      = sqlSelectUserAuth + `WHERE login = ?`
+	SQLGetUserByEmail   = sqlSelectUserAuth + `WHERE email = ?`
+	SQLListUsers        = sqlSelectUserAuth + `WHERE login > ? ORDER BY login LIMIT ?`
+	SQLSetEmailVerified = `UPDATE users SET email_verified_at = ? WHERE id = ?`
+	SQLSetPassword      = `UPDATE users SET password = ?, updated_at = ? WHERE id = ?`
+	SQLAddFailedAttempt = `UPDATE users SET failed_attempts = failed_attempts + 1 WHERE id = ? RETURNING failed_attempts`
+	SQLSetLockout       = `UPDATE users SET failed_attempts = ?, locked_until = ? WHERE id = ?`
+	// the verification is kept unless the email changes, one done since the
+	// user was read doesn't bump the version
+	SQLUpdateUser = `UPDATE users SET name = ?, email = ?, updated_at = ?, version = version + 1, ` +
+		`email_verified_at = CASE WHEN email IS ? THEN email_verified_at END WHERE id = ? AND version = ?`
+	SQLDeleteUser = `DELETE FROM users WHERE id = ?`
 )
 
+// sqlDeleteUserRows remove the rows referencing a user, foreign keys are not
+// enforced by sqlite unless enabled on the connection.
+var sqlDeleteUserRows = []string{
+	`DELETE FROM user_roles WHERE user_id = ?`,
+	`DELETE FROM user_totp WHERE user_id = ?`,
+	`DELETE FROM user_recovery_codes WHERE user_id = ?`,
+	`DELETE FROM user_passkeys WHERE user_id = ?`,
+}
+
 func (u *UserDB) GetUser(ctx context.Context, login string) (*domain.User, error) {
 	rows, err := u.db.QueryContext(ctx, SQLGetUser, login)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	rows.Next()
 	var user domain.User
-	if err := rows.Scan(&user.ID, &user.Login, &user.Name, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := rows.Scan(&user.ID, &user.Login, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.Version); err != nil {
 		return nil, ErrUserNotFound
 	}
 
@@ -50,9 +108,215 @@ func (u *UserDB) GetUser(ctx context.Context, login string) (*domain.User, error
 }
 
 func (u *UserDB) CreateUser(ctx context.Context, user *domain.User) error {
-	_, err := u.db.ExecContext(ctx, SQLCreateUser, user.ID, user.Login, user.Password, user.Name, user.CreatedAt, user.UpdatedAt)
+	email := sql.NullString{String: user.Email, Valid: user.Email != ""}
+
+	return u.audited(ctx, domain.AuditCreateUser, user.ID, func(q db.Querier) error {
+		_, err := q.ExecContext(ctx, SQLCreateUser, user.ID, user.Login, user.Password, user.Name, user.CreatedAt, user.UpdatedAt, email)
+		if err != nil {
+			return ErrUserLoginExists
+		}
+		return nil
+	})
+}
+
+func (u *UserDB) CreateUsers(ctx context.Context, users []domain.User) error {
+	tx, err := u.db.BeginTx(ctx, nil)
 	if err != nil {
-		return ErrUserLoginExists
+		return err
 	}
-	return nil
+	defer func() { _ = tx.Rollback() }()
+
+	for i := range users {
+		user := &users[i]
+		email := sql.NullString{String: user.Email, Valid: user.Email != ""}
+
+		if err := recordChange(ctx, tx, domain.AuditCreateUser, user.ID, func(q db.Querier) error {
+			_, err := q.ExecContext(ctx, SQLCreateUser, user.ID, user.Login, user.Password, user.Name, user.CreatedAt, user.UpdatedAt, email)
+			if err != nil {
+				return ErrUserLoginExists
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (u *UserDB) UpdateUser(ctx context.Context, user *domain.User, expectedVersion int64) error {
+	email := sql.NullString{String: user.Email, Valid: user.Email != ""}
+
+	return u.audited(ctx, domain.AuditUpdateUser, user.ID, func(q db.Querier) error {
+		result, err := q.ExecContext(ctx, SQLUpdateUser,
+			user.Name, email, user.UpdatedAt, email, user.ID, expectedVersion,
+		)
+		if isUniqueViolation(err, "users.email") {
+			return ErrUserEmailExists
+		}
+		if err != nil {
+			return err
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrVersionMismatch
+		}
+
+		return nil
+	})
+}
+
+func (u *UserDB) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return u.audited(ctx, domain.AuditDeleteUser, id, func(q db.Querier) error {
+		for _, query := range sqlDeleteUserRows {
+			if _, err := q.ExecContext(ctx, query, id); err != nil {
+				return err
+			}
+		}
+
+		result, err := q.ExecContext(ctx, SQLDeleteUser, id)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrUserNotFound
+		}
+
+		return nil
+	})
+}
+
+func (u *UserDB) ListUsers(ctx context.Context, after string, limit int) ([]domain.User, error) {
+	rows, err := u.db.QueryContext(ctx, SQLListUsers, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []domain.User
+	for rows.Next() {
+		user, err := scanUserAuth(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUserAuth returns the user with the password hash, lockout state and
+// email.
+func (u *UserDB) GetUserAuth(ctx context.Context, login string) (*domain.User, error) {
+	return u.getUserAuth(ctx, SQLGetUserAuth, login)
+}
+
+func (u *UserDB) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return u.getUserAuth(ctx, SQLGetUserByEmail, email)
+}
+
+func (u *UserDB) getUserAuth(ctx context.Context, query string, arg any) (*domain.User, error) {
+	rows, err := u.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrUserNotFound
+	}
+
+	return scanUserAuth(rows)
+}
+
+func scanUserAuth(rows *sql.Rows) (*domain.User, error) {
+	var (
+		user            domain.User
+		lockedUntil     sql.NullTime
+		email           sql.NullString
+		emailVerifiedAt sql.NullTime
+	)
+	if err := rows.Scan(
+		&user.ID, &user.Login, &user.Password, &user.Name, &user.CreatedAt, &user.UpdatedAt,
+		&user.FailedAttempts, &lockedUntil, &email, &emailVerifiedAt, &user.Version,
+	); err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	user.Email = email.String
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	return &user, nil
+}
+
+// AddFailedAttempt increments the failed login counter and returns the
+// new value.
+func (u *UserDB) AddFailedAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	var attempts int
+	err := u.audited(ctx, domain.AuditFailLogin, id, func(q db.Querier) error {
+		rows, err := q.QueryContext(ctx, SQLAddFailedAttempt, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			return ErrUserNotFound
+		}
+
+		return rows.Scan(&attempts)
+	})
+
+	return attempts, err
+}
+
+func (u *UserDB) SetLockout(ctx context.Context, id uuid.UUID, failedAttempts int, lockedUntil *time.Time) error {
+	return u.audited(ctx, domain.AuditSetLockout, id, func(q db.Querier) error {
+		_, err := q.ExecContext(ctx, SQLSetLockout, failedAttempts, lockedUntil, id)
+		return err
+	})
+}
+
+func (u *UserDB) SetEmailVerified(ctx context.Context, id uuid.UUID, verifiedAt time.Time) error {
+	return u.audited(ctx, domain.AuditVerifyEmail, id, func(q db.Querier) error {
+		_, err := q.ExecContext(ctx, SQLSetEmailVerified, verifiedAt, id)
+		return err
+	})
+}
+
+// SetPassword replaces the password hash of the user.
+func (u *UserDB) SetPassword(ctx context.Context, id uuid.UUID, password string, updatedAt time.Time) error {
+	return u.audited(ctx, domain.AuditSetPassword, id, func(q db.Querier) error {
+		_, err := q.ExecContext(ctx, SQLSetPassword, password, updatedAt, id)
+		return err
+	})
+}
+
+// isUniqueViolation reports whether err is a unique constraint failure on
+// the table.column.
+func isUniqueViolation(err error, column string) bool {
+	var sqliteErr sqlite3.Error
+
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		strings.Contains(sqliteErr.Error(), column)
 }
@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/db"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook *domain.Webhook) error
+	ListWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	// DeleteWebhook removes the webhook along with its deliveries.
+	DeleteWebhook(ctx context.Context, id string) error
+	// EnqueueEvent adds a delivery of the event to every webhook subscribed
+	// to it, events already enqueued are skipped.
+	EnqueueEvent(ctx context.Context, event *domain.Event) error
+	// PendingDeliveries returns deliveries due at now with the URL and
+	// secret of their webhooks.
+	PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]domain.WebhookDelivery, error)
+	// UpdateDelivery stores the outcome of an attempt.
+	UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+	// ListDeliveries returns deliveries of the webhook newest first,
+	// optionally in the given status only.
+	ListDeliveries(ctx context.Context, webhookID, status string, limit int) ([]domain.WebhookDelivery, error)
+	// RetryDelivery makes a dead delivery pending again.
+	RetryDelivery(ctx context.Context, webhookID string, id int64, now time.Time) error
+}
+
+func NewWebhookDB(db db.DB) WebhookRepository {
+	return &WebhookDB{db}
+}
+
+type WebhookDB struct {
+	db db.DB
+}
+
+var _ WebhookRepository = (*WebhookDB)(nil)
+
+const (
+	SQLCreateWebhook           = `INSERT INTO webhooks (id, url, events, secret, created_at) VALUES (?, ?, ?, ?, ?)`
+	SQLListWebhooks            = `SELECT id, url, events, secret, created_at FROM webhooks ORDER BY created_at`
+	SQLWebhookExists           = `SELECT 1 FROM webhooks WHERE id = ?`
+	SQLDeleteWebhook           = `DELETE FROM webhooks WHERE id = ?`
+	SQLDeleteWebhookDeliveries = `DELETE FROM webhook_deliveries WHERE webhook_id = ?`
+	SQLEnqueueEvent            = `INSERT OR IGNORE INTO webhook_deliveries ` +
+		`(webhook_id, event_id, event_type, payload, status, created_at, next_attempt_at) ` +
+		`SELECT id, ?, ?, ?, ?, ?, ? FROM webhooks WHERE events = '' OR instr(' ' || events || ' ', ?) > 0`
+	SQLPendingDeliveries = `SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.attempts, d.created_at, ` +
+		`d.payload, w.url, w.secret FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id ` +
+		`WHERE d.status = ? AND d.next_attempt_at <= ? ORDER BY d.id LIMIT ?`
+	SQLUpdateDelivery = `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, ` +
+		`last_attempt_at = ?, response_code = ?, last_error = ?, delivered_at = ? WHERE id = ?`
+	sqlSelectDeliveries = `SELECT id, webhook_id, event_id, event_type, status, attempts, created_at, ` +
+		`next_attempt_at, last_attempt_at, response_code, last_error, delivered_at FROM webhook_deliveries ` +
+		`WHERE webhook_id = ?`
+	SQLRetryDelivery = `UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? ` +
+		`WHERE id = ? AND webhook_id = ? AND status = ?`
+)
+
+func (w *WebhookDB) CreateWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	_, err := w.db.ExecContext(ctx, SQLCreateWebhook,
+		webhook.ID, webhook.URL, strings.Join(webhook.Events, " "), webhook.Secret, webhook.CreatedAt,
+	)
+	return err
+}
+
+func (w *WebhookDB) ListWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	rows, err := w.db.QueryContext(ctx, SQLListWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.Webhook
+	for rows.Next() {
+		var (
+			webhook domain.Webhook
+			events  string
+		)
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Secret, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhook.Events = strings.Fields(events)
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (w *WebhookDB) DeleteWebhook(ctx context.Context, id string) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, SQLDeleteWebhookDeliveries, id); err != nil {
+		return err
+	}
+
+	deleted, err := updated(tx.ExecContext(ctx, SQLDeleteWebhook, id))
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrWebhookNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (w *WebhookDB) EnqueueEvent(ctx context.Context, event *domain.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = w.db.ExecContext(ctx, SQLEnqueueEvent,
+		event.Key, event.Type, string(payload), domain.DeliveryPending, now, now, " "+event.Type+" ",
+	)
+	return err
+}
+
+func (w *WebhookDB) PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	rows, err := w.db.QueryContext(ctx, SQLPendingDeliveries, domain.DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var (
+			delivery = domain.WebhookDelivery{Status: domain.DeliveryPending}
+			payload  string
+		)
+		if err := rows.Scan(
+			&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &delivery.Attempts,
+			&delivery.CreatedAt, &payload, &delivery.URL, &delivery.Secret,
+		); err != nil {
+			return nil, err
+		}
+		delivery.Payload = []byte(payload)
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (w *WebhookDB) UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	_, err := w.db.ExecContext(ctx, SQLUpdateDelivery,
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt,
+		sql.NullInt64{Int64: int64(delivery.ResponseCode), Valid: delivery.ResponseCode != 0},
+		sql.NullString{String: delivery.LastError, Valid: delivery.LastError != ""},
+		delivery.DeliveredAt, delivery.ID,
+	)
+	return err
+}
+
+func (w *WebhookDB) ListDeliveries(ctx context.Context, webhookID, status string, limit int) ([]domain.WebhookDelivery, error) {
+	if err := w.exists(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	query, args := sqlSelectDeliveries, []any{webhookID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := w.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var (
+			delivery                                  domain.WebhookDelivery
+			nextAttemptAt, lastAttemptAt, deliveredAt sql.NullTime
+			responseCode                              sql.NullInt64
+			lastError                                 sql.NullString
+		)
+		if err := rows.Scan(
+			&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &delivery.Status,
+			&delivery.Attempts, &delivery.CreatedAt, &nextAttemptAt, &lastAttemptAt, &responseCode, &lastError,
+			&deliveredAt,
+		); err != nil {
+			return nil, err
+		}
+
+		delivery.ResponseCode, delivery.LastError = int(responseCode.Int64), lastError.String
+		if nextAttemptAt.Valid && delivery.Status == domain.DeliveryPending {
+			delivery.NextAttemptAt = &nextAttemptAt.Time
+		}
+		if lastAttemptAt.Valid {
+			delivery.LastAttemptAt = &lastAttemptAt.Time
+		}
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (w *WebhookDB) RetryDelivery(ctx context.Context, webhookID string, id int64, now time.Time) error {
+	retried, err := updated(w.db.ExecContext(ctx, SQLRetryDelivery,
+		domain.DeliveryPending, now.UTC(), id, webhookID, domain.DeliveryDead,
+	))
+	if err != nil {
+		return err
+	}
+	if !retried {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (w *WebhookDB) exists(ctx context.Context, id string) error {
+	rows, err := w.db.QueryContext(ctx, SQLWebhookExists, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// activatedListeners returns listeners passed by systemd socket
+// activation keyed by FileDescriptorName from the socket unit. Unnamed
+// sockets are taken in order: the first is "http", the second is "grpc".
+//
+// The LISTEN_* variables are unset so they are not inherited by children.
+func activatedListeners() (map[string]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count == 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	defaults := []string{"http", "grpc"}
+
+	listeners := make(map[string]net.Listener, count)
+	for i := range count {
+		fd := listenFDsStart + i
+
+		name := ""
+		if i < len(names) && names[i] != "unknown" {
+			name = names[i]
+		}
+		if name == "" && i < len(defaults) {
+			name = defaults[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation fd %d (%s): %w", fd, name, err)
+		}
+
+		if _, ok := listeners[name]; ok || name == "" {
+			_ = listener.Close()
+			closeListeners(listeners)
+			return nil, fmt.Errorf("socket activation fd %d: %w %q", fd, errBadFDName, name)
+		}
+
+		listeners[name] = listener
+	}
+
+	return listeners, nil
+}
+
+var errBadFDName = errors.New("duplicate or empty socket name")
+
+func closeListeners(listeners map[string]net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
+
+// listenUnix opens a unix socket listener. A socket file left from a
+// previous run is removed when nobody accepts connections on it.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+var errSocketInUse = errors.New("socket is in use")
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s: %w", path, errSocketInUse)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/soheilhy/cmux"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+// Listeners opens network listeners for HTTP and gRPC servers.
+//
+// In single port mode one listener is opened on cfg.Listen and connections
+// are dispatched by protocol: gRPC goes to the gRPC server, HTTP/1.1 and
+// h2c go to the HTTP server. TLS from cfg.TLS is then terminated on the
+// shared listener.
+//
+// Addresses may be unix:///path sockets. Listeners passed by systemd socket
+// activation (named "http" and "grpc") take precedence over addresses.
+type Listeners struct {
+	cfg       *config.Config
+	logger    *zap.SugaredLogger
+	certs     *certReloader
+	activated map[string]net.Listener
+
+	root net.Listener
+	mux  cmux.CMux
+	http net.Listener
+	grpc net.Listener
+	done chan struct{}
+}
+
+func NewListeners(cfg *config.Config, lc fx.Lifecycle, logger *zap.Logger) (*Listeners, error) {
+	listeners := &Listeners{
+		cfg:    cfg,
+		logger: logger.Sugar().Named("Listeners"),
+	}
+
+	if cfg.TLS.Enabled() {
+		certs, err := newCertReloader(cfg.TLS, []string{"h2", "http/1.1"}, listeners.logger)
+		if err != nil {
+			return nil, err
+		}
+		listeners.certs = certs
+	}
+
+	activated, err := activatedListeners()
+	if err != nil {
+		return nil, err
+	}
+	listeners.activated = activated
+
+	lc.Append(fx.Hook{
+		OnStart: listeners.Start,
+		OnStop:  listeners.Shutdown,
+	})
+
+	return listeners, nil
+}
+
+// HTTP returns the listener for the HTTP server, valid after Start.
+func (l *Listeners) HTTP() net.Listener {
+	return l.http
+}
+
+// GRPC returns the listener for the gRPC server, valid after Start.
+func (l *Listeners) GRPC() net.Listener {
+	return l.grpc
+}
+
+func (l *Listeners) Start(ctx context.Context) error {
+	if l.certs != nil {
+		if err := l.certs.Watch(); err != nil {
+			l.logger.Errorw("Error watching certificates", "error", err)
+			return err
+		}
+	}
+
+	// activated listeners which are not used are closed at the end
+	defer closeListeners(l.activated)
+
+	if l.cfg.SinglePort {
+		return l.startSinglePort()
+	}
+
+	httpListener, err := l.listen("http", l.cfg.Listen)
+	if err != nil {
+		return err
+	}
+
+	if l.certs != nil {
+		httpListener = tls.NewListener(httpListener, l.certs.TLSConfig())
+	}
+
+	grpcListener, err := l.listen("grpc", l.cfg.ListenGRPC)
+	if err != nil {
+		_ = httpListener.Close()
+		return err
+	}
+
+	l.http, l.grpc = httpListener, grpcListener
+
+	return nil
+}
+
+func (l *Listeners) startSinglePort() error {
+	root, err := l.listen("http", l.cfg.Listen)
+	if err != nil {
+		return err
+	}
+
+	if l.certs != nil {
+		root = tls.NewListener(root, l.certs.TLSConfig())
+	}
+
+	l.root = root
+	l.mux = cmux.New(root)
+
+	// order matters: gRPC is matched first, everything else is HTTP.
+	// gRPC-Web (application/grpc-web) is served by the HTTP server.
+	l.grpc = newMuxListener(l.mux.MatchWithWriters(matchGRPC))
+	l.http = newMuxListener(settingsAckListener{l.mux.Match(cmux.Any())})
+
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+
+		l.logger.Infow("Serving HTTP and gRPC on a single port", "addr", l.cfg.Listen)
+		if err := l.mux.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+			l.logger.Errorw("Listener error", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// listen takes the listener passed by systemd under name or opens a new
+// one on addr.
+func (l *Listeners) listen(name, addr string) (net.Listener, error) {
+	if listener, ok := l.activated[name]; ok {
+		delete(l.activated, name)
+		l.logger.Infow("Using listener from socket activation", "name", name, "addr", listener.Addr().String())
+		return listener, nil
+	}
+
+	var (
+		listener net.Listener
+		err      error
+	)
+
+	if path, ok := config.UnixSocketPath(addr); ok {
+		listener, err = listenUnix(path, l.cfg.SocketMode())
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+
+	if err != nil {
+		l.logger.Errorw("Error opening listener", "addr", addr, "error", err)
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func (l *Listeners) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	if l.mux != nil {
+		l.mux.Close()
+		if err := l.root.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+		<-l.done
+	}
+
+	if l.certs != nil {
+		errs = append(errs, l.certs.Close())
+	}
+
+	return errors.Join(errs...)
+}
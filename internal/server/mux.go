@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/soheilhy/cmux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc/credentials"
+)
+
+// muxListener wraps a listener returned by cmux. Closing cmux listener
+// closes the shared root listener and stops the other server as well, so
+// Close here only stops handing out connections to this server.
+type muxListener struct {
+	net.Listener
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxListener(l net.Listener) *muxListener {
+	ml := &muxListener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+
+	go ml.accept()
+
+	return ml
+}
+
+func (ml *muxListener) accept() {
+	for {
+		conn, err := ml.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		select {
+		case ml.conns <- conn:
+		case <-ml.done:
+			_ = conn.Close()
+		}
+	}
+}
+
+func (ml *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.conns:
+		return conn, nil
+	case <-ml.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ml *muxListener) Close() error {
+	ml.closeOnce.Do(func() { close(ml.done) })
+	return nil
+}
+
+// settingsAckListener wraps connections with settingsAckConn.
+type settingsAckListener struct {
+	net.Listener
+}
+
+func (l settingsAckListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &settingsAckConn{Conn: conn}, nil
+}
+
+const frameHeaderLen = 9
+
+// settingsAckConn drops the first SETTINGS ACK sent by an h2c client.
+//
+// While looking for the gRPC content type cmux answers the client
+// SETTINGS frame with its own SETTINGS (gRPC clients wait for it). When the
+// connection turns out to be plain h2c it is passed to net/http, which
+// sends SETTINGS again and treats the second ACK from the client as a
+// protocol error. HTTP/1 connections are passed through untouched.
+type settingsAckConn struct {
+	net.Conn
+
+	in      []byte
+	out     []byte
+	checked bool
+	done    bool
+}
+
+func (c *settingsAckConn) Read(p []byte) (int, error) {
+	for len(c.out) == 0 && !c.done {
+		buf := make([]byte, 4096)
+		n, err := c.Conn.Read(buf)
+		c.in = append(c.in, buf[:n]...)
+		c.filter()
+		if err != nil {
+			c.flush()
+			if len(c.out) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+
+	if len(c.out) > 0 {
+		n := copy(p, c.out)
+		c.out = c.out[n:]
+		return n, nil
+	}
+
+	return c.Conn.Read(p)
+}
+
+func (c *settingsAckConn) filter() {
+	if !c.checked {
+		preface := []byte(http2.ClientPreface)
+		if len(c.in) < len(preface) {
+			if !bytes.HasPrefix(preface, c.in) {
+				c.flush()
+			}
+			return
+		}
+		if !bytes.HasPrefix(c.in, preface) {
+			c.flush()
+			return
+		}
+		c.checked = true
+		c.out = append(c.out, c.in[:len(preface)]...)
+		c.in = c.in[len(preface):]
+	}
+
+	for !c.done && len(c.in) >= frameHeaderLen {
+		length := int(c.in[0])<<16 | int(c.in[1])<<8 | int(c.in[2])
+		if len(c.in) < frameHeaderLen+length {
+			return
+		}
+
+		frameType, flags := http2.FrameType(c.in[3]), http2.Flags(c.in[4])
+		if frameType == http2.FrameSettings && flags.Has(http2.FlagSettingsAck) {
+			c.in = c.in[frameHeaderLen+length:]
+			c.flush()
+			return
+		}
+
+		c.out = append(c.out, c.in[:frameHeaderLen+length]...)
+		c.in = c.in[frameHeaderLen+length:]
+	}
+}
+
+// flush stops filtering and passes the rest of the data as is.
+func (c *settingsAckConn) flush() {
+	c.done = true
+	c.out = append(c.out, c.in...)
+	c.in = nil
+}
+
+// matchGRPC matches HTTP/2 connections whose first request has the
+// application/grpc or application/grpc+<codec> content type. gRPC-Web
+// (application/grpc-web) is left to the HTTP server. Like the cmux
+// matchers it answers the client SETTINGS, but only once, which
+// settingsAckConn relies on.
+func matchGRPC(w io.Writer, r io.Reader) bool {
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(r, preface); err != nil || string(preface) != http2.ClientPreface {
+		return false
+	}
+
+	var (
+		matched, done bool
+		sentSettings  bool
+	)
+
+	framer := http2.NewFramer(w, r)
+	decoder := hpack.NewDecoder(4<<10, func(hf hpack.HeaderField) {
+		if hf.Name == "content-type" {
+			matched = hf.Value == "application/grpc" || strings.HasPrefix(hf.Value, "application/grpc+")
+		}
+	})
+
+	for !done {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return false
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() && !sentSettings {
+				sentSettings = true
+				if err := framer.WriteSettings(); err != nil {
+					return false
+				}
+			}
+		case *http2.HeadersFrame:
+			if _, err := decoder.Write(f.HeaderBlockFragment()); err != nil {
+				return false
+			}
+			done = f.HeadersEnded()
+		case *http2.ContinuationFrame:
+			if _, err := decoder.Write(f.HeaderBlockFragment()); err != nil {
+				return false
+			}
+			done = f.HeadersEnded()
+		}
+	}
+
+	return matched
+}
+
+// connTLSState returns the state of the TLS connection under the cmux
+// wrappers, nil for plain connections.
+func connTLSState(conn net.Conn) *tls.ConnectionState {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			state := c.ConnectionState()
+			return &state
+		case *cmux.MuxConn:
+			conn = c.Conn
+		case *settingsAckConn:
+			conn = c.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+type tlsStateKey struct{}
+
+// tlsConnContext keeps the TLS state of connections from the shared
+// listener, net/http only fills Request.TLS for *tls.Conn.
+func tlsConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if state := connTLSState(conn); state != nil {
+		return context.WithValue(ctx, tlsStateKey{}, state)
+	}
+
+	return ctx
+}
+
+// withTLSState sets Request.TLS from the state kept by tlsConnContext.
+func withTLSState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state, ok := r.Context().Value(tlsStateKey{}).(*tls.ConnectionState); ok && r.TLS == nil {
+			r = r.WithContext(r.Context())
+			r.TLS = state
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// terminatedTLS are gRPC server credentials for connections on which TLS
+// is already terminated by the shared listener. The handshake only
+// reports the TLS state, so peers carry credentials.TLSInfo with the
+// client certificate.
+type terminatedTLS struct{}
+
+func (terminatedTLS) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("terminatedTLS: client handshake not supported")
+}
+
+func (terminatedTLS) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	state := connTLSState(conn)
+	if state == nil {
+		return conn, nil, nil
+	}
+
+	return conn, credentials.TLSInfo{
+		State:          *state,
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (terminatedTLS) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (terminatedTLS) Clone() credentials.TransportCredentials {
+	return terminatedTLS{}
+}
+
+func (terminatedTLS) OverrideServerName(string) error {
+	return nil
+}
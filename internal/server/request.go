@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/iliadmitriev/go-user-test/internal/audit"
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/ratelimit"
+)
+
+const (
+	requestIDHeader   = "X-Request-Id"
+	requestIDMetadata = "x-request-id"
+	maxRequestIDLen   = 64
+)
+
+// requestInfo puts the request ID and client address in the context for
+// audit events. The request ID is taken from the client when it is sane,
+// otherwise generated, and echoed back in the response either way.
+type requestInfo struct {
+	obs *config.Observer
+}
+
+func (ri requestInfo) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &audit.Request{
+			ID: requestID(r.Header.Get(requestIDHeader)),
+			ClientIP: ratelimit.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"),
+				ri.obs.Current().RateLimit.Proxies()),
+		}
+
+		w.Header().Set(requestIDHeader, request.ID)
+		next.ServeHTTP(w, r.WithContext(audit.NewContext(r.Context(), request)))
+	})
+}
+
+func (ri requestInfo) UnaryInterceptor(
+	ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	return handler(ri.grpcContext(ctx), req)
+}
+
+func (ri requestInfo) StreamInterceptor(
+	srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	return handler(srv, &requestStream{ServerStream: ss, ctx: ri.grpcContext(ss.Context())})
+}
+
+func (ri requestInfo) grpcContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var remoteAddr, id string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	if values := md.Get(requestIDMetadata); len(values) > 0 {
+		id = values[0]
+	}
+
+	request := &audit.Request{
+		ID:       requestID(id),
+		ClientIP: ratelimit.ClientIP(remoteAddr, md.Get("x-forwarded-for"), ri.obs.Current().RateLimit.Proxies()),
+	}
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, request.ID))
+
+	return audit.NewContext(ctx, request)
+}
+
+// requestStream replaces the context of a stream.
+type requestStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+func (s *requestStream) Context() context.Context {
+	return s.ctx
+}
+
+// requestID returns the ID sent by the client, or a new one when it is
+// missing, too long or has characters other than printable ASCII.
+func requestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLen {
+		return rand.Text()
+	}
+
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' {
+			return rand.Text()
+		}
+	}
+
+	return id
+}
@@ -2,49 +2,121 @@ package server
 
 import (
 	"context"
-	"net"
 
 	"github.com/iliadmitriev/go-user-test/internal/config"
 	"github.com/iliadmitriev/go-user-test/internal/handler"
+	"github.com/iliadmitriev/go-user-test/internal/ratelimit"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
 var _ Server = (*grpcServer)(nil)
 
 type grpcServer struct {
-	srv    *grpc.Server
-	logger *zap.SugaredLogger
-	cfg    *config.Config
+	srv        *grpc.Server
+	logger     *zap.SugaredLogger
+	cfg        *config.Config
+	certs      *certReloader
+	listeners  *Listeners
+	inFlight   *inFlight
+	shutdowner fx.Shutdowner
 }
 
 func (srv *grpcServer) Start(ctx context.Context) error {
-	listener, err := net.Listen("tcp", srv.cfg.ListenGRPC)
-	if err != nil {
-		srv.logger.Errorw("Error starting server", "error", err)
-		return err
+	listener := srv.listeners.GRPC()
+
+	if srv.certs != nil {
+		if err := srv.certs.Watch(); err != nil {
+			srv.logger.Errorw("Error watching certificates", "error", err)
+			return err
+		}
 	}
 
 	go func(srv *grpcServer) {
-		srv.logger.Infow("Server started serving new connections", "addr", srv.cfg.ListenGRPC)
+		srv.logger.Infow("Server started serving new connections", "addr", listener.Addr().String())
 		if err := srv.srv.Serve(listener); err != nil {
-			srv.logger.Errorw("Server error", "error", err)
+			shutdownOnError(srv.shutdowner, srv.logger, err)
 		}
 	}(srv)
 
 	return nil
 }
 
+// Shutdown waits for in-flight RPCs until ctx is done and then closes
+// all connections.
 func (srv *grpcServer) Shutdown(ctx context.Context) error {
-	srv.logger.Infow("Server shutting down", "addr", srv.cfg.ListenGRPC)
-	srv.srv.GracefulStop()
+	srv.logger.Infow("Server shutting down",
+		"addr", srv.listeners.GRPC().Addr().String(),
+		"in_flight", srv.inFlight.Load(),
+	)
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.logger.Warnw("Graceful shutdown timed out, closing connections",
+			"error", ctx.Err(),
+			"in_flight", srv.inFlight.Load(),
+		)
+		srv.srv.Stop()
+		<-stopped
+	}
+
+	if srv.certs != nil {
+		return srv.certs.Close()
+	}
+
 	return nil
 }
 
-func NewGRPCServer(handler []handler.GRPCHandler, lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) Server {
-	server := grpc.NewServer()
+func NewGRPCServer(
+	handler []handler.GRPCHandler,
+	lc fx.Lifecycle,
+	cfg *config.Config,
+	obs *config.Observer,
+	listeners *Listeners,
+	authenticator *handler.Authenticator,
+	limiter *ratelimit.Limiter,
+	shutdowner fx.Shutdowner,
+	logger *zap.Logger,
+) (Server, error) {
+	log := logger.Sugar().Named("GRPCServer")
+	inFlight := &inFlight{}
+
+	var certs *certReloader
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			inFlight.UnaryInterceptor, requestInfo{obs}.UnaryInterceptor,
+			limiter.BeforeAuth().UnaryInterceptor, authenticator.UnaryInterceptor, limiter.AfterAuth().UnaryInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			inFlight.StreamInterceptor, requestInfo{obs}.StreamInterceptor,
+			limiter.BeforeAuth().StreamInterceptor, authenticator.StreamInterceptor, limiter.AfterAuth().StreamInterceptor,
+		),
+	}
+
+	if cfg.GRPCTLS.Enabled() {
+		var err error
+		if certs, err = newCertReloader(cfg.GRPCTLS, []string{"h2"}, log); err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.TLSConfig())))
+	}
+
+	if cfg.SinglePort && cfg.TLS.Enabled() {
+		// TLS is terminated by the shared listener
+		opts = append(opts, grpc.Creds(terminatedTLS{}))
+	}
+
+	server := grpc.NewServer(opts...)
 
 	for _, handler := range handler {
 		handler.RegisterGRPC(server)
@@ -53,9 +125,13 @@ func NewGRPCServer(handler []handler.GRPCHandler, lc fx.Lifecycle, cfg *config.C
 	reflection.Register(server)
 
 	srv := &grpcServer{
-		srv:    server,
-		cfg:    cfg,
-		logger: logger.Sugar().Named("GRPCServer"),
+		srv:        server,
+		cfg:        cfg,
+		certs:      certs,
+		listeners:  listeners,
+		inFlight:   inFlight,
+		shutdowner: shutdowner,
+		logger:     log,
 	}
 
 	lc.Append(fx.Hook{
@@ -63,5 +139,5 @@ func NewGRPCServer(handler []handler.GRPCHandler, lc fx.Lifecycle, cfg *config.C
 		OnStop:  srv.Shutdown,
 	})
 
-	return srv
+	return srv, nil
 }
@@ -3,11 +3,12 @@ package server
 import (
 	"context"
 	"errors"
-	"net"
 	"net/http"
+	"time"
 
 	"github.com/iliadmitriev/go-user-test/internal/config"
 	"github.com/iliadmitriev/go-user-test/internal/handler"
+	"github.com/iliadmitriev/go-user-test/internal/ratelimit"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
@@ -20,23 +21,22 @@ type Server interface {
 }
 
 type httpServer struct {
-	srv    *http.Server
-	logger *zap.SugaredLogger
-	cfg    *config.Config
+	srv        *http.Server
+	logger     *zap.SugaredLogger
+	cfg        *config.Config
+	listeners  *Listeners
+	inFlight   *inFlight
+	shutdowner fx.Shutdowner
 }
 
 func (srv *httpServer) Start(ctx context.Context) error {
-	listener, err := net.Listen("tcp", srv.cfg.Listen)
-	if err != nil {
-		srv.logger.Errorw("Error starting server", "error", err)
-		return err
-	}
+	listener := srv.listeners.HTTP()
 
 	go func(srv *httpServer) {
-		srv.logger.Infow("Server started serving new connections", "addr", srv.cfg.Listen)
+		srv.logger.Infow("Server started serving new connections", "addr", listener.Addr().String())
 
 		if err := srv.srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
-			srv.logger.Errorw("Server error", "error", err)
+			shutdownOnError(srv.shutdowner, srv.logger, err)
 		}
 
 		srv.logger.Info("Server stopped serving new connections")
@@ -45,27 +45,87 @@ func (srv *httpServer) Start(ctx context.Context) error {
 	return nil
 }
 
+// Shutdown waits for in-flight requests until ctx is done and then closes
+// all connections.
 func (srv *httpServer) Shutdown(ctx context.Context) error {
-	srv.logger.Infow("Server shutting down", "addr", srv.cfg.Listen)
-	return srv.srv.Shutdown(ctx)
+	srv.logger.Infow("Server shutting down",
+		"addr", srv.listeners.HTTP().Addr().String(),
+		"in_flight", srv.inFlight.Load(),
+	)
+
+	if err := srv.srv.Shutdown(ctx); err != nil {
+		srv.logger.Warnw("Graceful shutdown timed out, closing connections",
+			"error", err,
+			"in_flight", srv.inFlight.Load(),
+		)
+		return srv.srv.Close()
+	}
+
+	return nil
 }
 
-func NewHTTPServer(handlers []handler.HTTPHandler, lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) Server {
+// withTimeouts applies read and write timeouts from the current config
+// to every request, so they can be changed without a restart.
+func withTimeouts(obs *config.Observer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := obs.Current()
+		now := time.Now()
+
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(now.Add(cfg.ReadTimeout))
+		_ = rc.SetWriteDeadline(now.Add(cfg.WriteTimeout))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func NewHTTPServer(
+	handlers []handler.HTTPHandler,
+	lc fx.Lifecycle,
+	cfg *config.Config,
+	obs *config.Observer,
+	listeners *Listeners,
+	authenticator *handler.Authenticator,
+	limiter *ratelimit.Limiter,
+	shutdowner fx.Shutdowner,
+	logger *zap.Logger,
+) Server {
 	mux := http.NewServeMux()
 
 	for _, handler := range handlers {
 		handler.GetMux(mux)
 	}
 
+	inFlight := &inFlight{}
+
 	srv := &httpServer{
 		srv: &http.Server{
-			Handler:      mux,
+			// rules keyed by IP and login run before the authenticator, so
+			// wrong credentials are limited, rules keyed by user after it
+			Handler: inFlight.Middleware(withTimeouts(obs, requestInfo{obs}.Middleware(
+				limiter.BeforeAuth().Handler(mux, authenticator.Handler(mux, limiter.AfterAuth().Handler(mux, mux))),
+			))),
 			Addr:         cfg.Listen,
 			ReadTimeout:  cfg.ReadTimeout,
 			WriteTimeout: cfg.WriteTimeout,
 		},
-		cfg:    cfg,
-		logger: logger.Sugar().Named("HTTPServer"),
+		cfg:        cfg,
+		listeners:  listeners,
+		inFlight:   inFlight,
+		shutdowner: shutdowner,
+		logger:     logger.Sugar().Named("HTTPServer"),
+	}
+
+	if cfg.SinglePort {
+		// gRPC is split off by the listener, HTTP/2 that reaches the
+		// server is either h2c or h2 with TLS terminated on the listener
+		srv.srv.Protocols = new(http.Protocols)
+		srv.srv.Protocols.SetHTTP1(true)
+		srv.srv.Protocols.SetUnencryptedHTTP2(true)
+
+		// client certificates are only known from the connection
+		srv.srv.ConnContext = tlsConnContext
+		srv.srv.Handler = withTLSState(srv.srv.Handler)
 	}
 
 	lc.Append(fx.Hook{
@@ -0,0 +1,426 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/handler"
+	"github.com/iliadmitriev/go-user-test/internal/ratelimit"
+	"github.com/iliadmitriev/go-user-test/internal/service"
+)
+
+type pingHandler struct{}
+
+func (pingHandler) GetMux(mux handler.Router) {
+	mux.Handle("/ping", handler.Public(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+}
+
+type healthHandler struct{}
+
+func (healthHandler) RegisterGRPC(srv *grpc.Server) {
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+}
+
+type stubShutdowner struct{}
+
+func (stubShutdowner) Shutdown(...fx.ShutdownOption) error {
+	return nil
+}
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		Listen:       "127.0.0.1:0",
+		ListenGRPC:   "127.0.0.1:0",
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+}
+
+// whoamiHandler answers authenticated requests with the caller login.
+type whoamiHandler struct{}
+
+func (whoamiHandler) GetMux(mux handler.Router) {
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.FromContext(r.Context())
+		_, _ = io.WriteString(w, principal.Login)
+	})
+}
+
+// certUsers is a user service which knows the users by client
+// certificate only, the common names it was asked for are sent to names.
+type certUsers struct {
+	service.UserServiceInterface
+
+	names chan string
+}
+
+func (u certUsers) AuthenticateClientCert(_ context.Context, commonName string) (*auth.Principal, error) {
+	u.names <- commonName
+	return &auth.Principal{Method: auth.MethodClientCert, Login: commonName}, nil
+}
+
+// startServers starts HTTP and gRPC servers with the lifecycle and
+// returns their listeners.
+func startServers(t *testing.T, cfg *config.Config) *Listeners {
+	t.Helper()
+
+	return startServersWithUsers(t, cfg, nil)
+}
+
+func startServersWithUsers(t *testing.T, cfg *config.Config, users service.UserServiceInterface) *Listeners {
+	t.Helper()
+
+	logger := zap.NewNop()
+	lc := fxtest.NewLifecycle(t)
+
+	listeners, err := NewListeners(cfg, lc, logger)
+	require.NoError(t, err)
+
+	obs := config.NewObserver(cfg, lc, logger)
+	authenticator := handler.NewAuthenticator(users, nil, obs, logger)
+	limiter := ratelimit.NewLimiter(obs, ratelimit.NewMemoryStore(), logger)
+	NewHTTPServer([]handler.HTTPHandler{pingHandler{}, whoamiHandler{}}, lc, cfg, obs, listeners, authenticator, limiter, stubShutdowner{}, logger)
+	_, err = NewGRPCServer([]handler.GRPCHandler{healthHandler{}}, lc, cfg, obs, listeners, authenticator, limiter, stubShutdowner{}, logger)
+	require.NoError(t, err)
+
+	lc.RequireStart()
+	t.Cleanup(lc.RequireStop)
+
+	return listeners
+}
+
+func getProto(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	return string(body)
+}
+
+func checkHealth(t *testing.T, addr string) {
+	t.Helper()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
+
+func TestServers_SeparatePorts(t *testing.T) {
+	listeners := startServers(t, newTestConfig())
+
+	assert.NotEqual(t, listeners.HTTP().Addr().String(), listeners.GRPC().Addr().String())
+	assert.Equal(t, "HTTP/1.1", getProto(t, http.DefaultClient, "http://"+listeners.HTTP().Addr().String()+"/ping"))
+	checkHealth(t, listeners.GRPC().Addr().String())
+}
+
+func TestServers_RateLimitBeforeAuth(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.RateLimit = config.RateLimitConfig{Enabled: true, Rules: []config.RateLimitRule{
+		{Match: "/ping", Key: config.RateLimitKeyIP, Requests: 2, Period: time.Minute},
+		{Match: healthpb.Health_Check_FullMethodName, Key: config.RateLimitKeyIP, Requests: 2, Period: time.Minute},
+	}}
+
+	listeners := startServers(t, cfg)
+
+	t.Run("http", func(t *testing.T) {
+		var statuses []int
+		for range 3 {
+			r, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://"+listeners.HTTP().Addr().String()+"/ping", nil)
+			require.NoError(t, err)
+			r.Header.Set("Authorization", "Basic wrong")
+
+			resp, err := http.DefaultClient.Do(r)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			statuses = append(statuses, resp.StatusCode)
+		}
+
+		assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, statuses)
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		conn, err := grpc.NewClient(listeners.GRPC().Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Basic wrong")
+
+		var got []codes.Code
+		for range 3 {
+			_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			got = append(got, status.Code(err))
+		}
+
+		assert.Equal(t, []codes.Code{codes.Unauthenticated, codes.Unauthenticated, codes.ResourceExhausted}, got)
+	})
+}
+
+func TestServers_SinglePort(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.SinglePort = true
+
+	listeners := startServers(t, cfg)
+	addr := listeners.HTTP().Addr().String()
+
+	require.Equal(t, addr, listeners.GRPC().Addr().String())
+
+	t.Run("http/1.1", func(t *testing.T) {
+		assert.Equal(t, "HTTP/1.1", getProto(t, &http.Client{Transport: &http.Transport{}}, "http://"+addr+"/ping"))
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		var dials atomic.Int32
+		dialer := &net.Dialer{}
+
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{
+			Protocols: protocols,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dials.Add(1)
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}}
+
+		// the connection must survive the SETTINGS exchange done by the
+		// listener while sniffing for gRPC, so all requests share it
+		for range 3 {
+			assert.Equal(t, "HTTP/2.0", getProto(t, client, "http://"+addr+"/ping"))
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, int32(1), dials.Load())
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		checkHealth(t, addr)
+	})
+
+	t.Run("grpc-web", func(t *testing.T) {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+		// gRPC-Web is served by the HTTP server
+		resp, err := client.Post("http://"+addr+"/ping", "application/grpc-web+proto", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "HTTP/2.0", string(body))
+	})
+}
+
+func TestServers_ClientCertAuth(t *testing.T) {
+	ca, tlsCfg := newTestPKI(t)
+	tlsCfg.ClientCAFile = filepath.Join(t.TempDir(), "ca.crt")
+	ca.writeFiles(t, tlsCfg.ClientCAFile, "")
+
+	client := newTestCert(t, 3, ca, false)
+	clientTLS := &tls.Config{RootCAs: ca.pool(), Certificates: []tls.Certificate{client.tlsCertificate()}}
+
+	for _, singlePort := range []bool{false, true} {
+		t.Run(fmt.Sprintf("single port %t", singlePort), func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.SinglePort = singlePort
+			cfg.TLS = tlsCfg
+			if !singlePort {
+				cfg.GRPCTLS = tlsCfg
+			}
+
+			users := certUsers{names: make(chan string, 10)}
+			listeners := startServersWithUsers(t, cfg, users)
+
+			httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			assert.Equal(t, "test", getProto(t, httpClient, "https://"+listeners.HTTP().Addr().String()+"/whoami"))
+			assert.Equal(t, "test", <-users.names)
+
+			conn, err := grpc.NewClient(listeners.GRPC().Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, "test", <-users.names)
+		})
+	}
+}
+
+func TestServers_UnixSockets(t *testing.T) {
+	dir := t.TempDir()
+	httpSock, grpcSock := filepath.Join(dir, "http.sock"), filepath.Join(dir, "grpc.sock")
+
+	// leave a stale socket file behind as after a crash
+	stale, err := net.Listen("unix", httpSock)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	cfg := newTestConfig()
+	cfg.Listen = "unix://" + httpSock
+	cfg.ListenGRPC = "unix://" + grpcSock
+	cfg.UnixSocketMode = "0600"
+
+	startServers(t, cfg)
+
+	info, err := os.Stat(httpSock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", httpSock)
+		},
+	}}
+	assert.Equal(t, "HTTP/1.1", getProto(t, client, "http://unix/ping"))
+
+	checkHealth(t, "unix://"+grpcSock)
+}
+
+// TestServers_SocketActivation runs the test binary with listening sockets
+// inherited the way systemd passes them, the child serves on them until its
+// stdin is closed.
+func TestServers_SocketActivation(t *testing.T) {
+	if os.Getenv("TEST_SOCKET_ACTIVATION") != "" {
+		// LISTEN_PID is only known once the child runs
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+		startServers(t, newTestConfig())
+		assert.Empty(t, os.Getenv("LISTEN_FDS"), "LISTEN_* are not inherited")
+
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		return
+	}
+
+	inherit := func() (*os.File, string) {
+		t.Helper()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		file, err := listener.(*net.TCPListener).File()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = file.Close() })
+
+		return file, listener.Addr().String()
+	}
+
+	// named sockets in reverse order
+	grpcFile, grpcAddr := inherit()
+	httpFile, httpAddr := inherit()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServers_SocketActivation$")
+	cmd.Env = append(os.Environ(), "TEST_SOCKET_ACTIVATION=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=grpc:http")
+	cmd.ExtraFiles = []*os.File{grpcFile, httpFile}
+
+	var output strings.Builder
+	cmd.Stdout, cmd.Stderr = &output, &output
+
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() { _ = cmd.Process.Kill() })
+
+	// the sockets are listening already, connections wait for the child
+	client := &http.Client{Timeout: 5 * time.Second}
+	assert.Equal(t, "HTTP/1.1", getProto(t, client, "http://"+httpAddr+"/ping"))
+	checkHealth(t, grpcAddr)
+
+	require.NoError(t, stdin.Close())
+	require.NoError(t, cmd.Wait(), output.String())
+}
+
+func TestGRPCServer_ShutdownTimeout(t *testing.T) {
+	cfg := newTestConfig()
+	logger := zap.NewNop()
+	lc := fxtest.NewLifecycle(t)
+
+	listeners, err := NewListeners(cfg, lc, logger)
+	require.NoError(t, err)
+
+	obs := config.NewObserver(cfg, lc, logger)
+	authenticator := handler.NewAuthenticator(nil, nil, obs, logger)
+	limiter := ratelimit.NewLimiter(obs, ratelimit.NewMemoryStore(), logger)
+	srv, err := NewGRPCServer([]handler.GRPCHandler{healthHandler{}}, lc, cfg, obs, listeners, authenticator, limiter, stubShutdowner{}, logger)
+	require.NoError(t, err)
+
+	lc.RequireStart()
+	t.Cleanup(lc.RequireStop)
+
+	conn, err := grpc.NewClient(listeners.GRPC().Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Watch streams until the client goes away, blocking graceful stop
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), srv.(*grpcServer).inFlight.Load())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	_, err = stream.Recv()
+	require.Error(t, err)
+}
+
+func Test_removeStaleSocket_InUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "live.sock")
+
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	require.ErrorIs(t, removeStaleSocket(path), errSocketInUse)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/watch"
+)
+
+// inFlight counts requests which are being served.
+type inFlight struct {
+	n atomic.Int64
+}
+
+func (c *inFlight) Load() int64 {
+	return c.n.Load()
+}
+
+func (c *inFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.n.Add(1)
+		defer c.n.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *inFlight) UnaryInterceptor(
+	ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	c.n.Add(1)
+	defer c.n.Add(-1)
+
+	return handler(ctx, req)
+}
+
+func (c *inFlight) StreamInterceptor(
+	srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	c.n.Add(1)
+	defer c.n.Add(-1)
+
+	return handler(srv, ss)
+}
+
+// RegisterDrain delays stopping of the servers by shutdown_drain_delay,
+// so that load balancers notice the instance is going away before its
+// listeners are closed. The servers keep serving requests meanwhile.
+// Watch streams are closed afterwards, graceful stop would wait for them
+// otherwise.
+//
+// fx runs stop hooks in reverse order, taking the servers as a parameter
+// makes the drain hook run before they are stopped.
+func RegisterDrain(_ []Server, hub *watch.Hub, lc fx.Lifecycle, obs *config.Observer, logger *zap.Logger) {
+	log := logger.Sugar().Named("Drain")
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			defer hub.Close()
+
+			delay := obs.Current().ShutdownDrainDelay
+			if delay <= 0 {
+				return nil
+			}
+
+			log.Infow("Draining before shutdown", "delay", delay)
+
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				log.Warnw("Drain cut short by stop timeout", "error", ctx.Err())
+			}
+
+			return nil
+		},
+	})
+}
+
+// shutdownOnError stops the application with a non-zero exit code when a
+// server stops serving unexpectedly.
+func shutdownOnError(shutdowner fx.Shutdowner, logger *zap.SugaredLogger, err error) {
+	logger.Errorw("Server error, shutting down", "error", err)
+
+	if err := shutdowner.Shutdown(fx.ExitCode(1)); err != nil {
+		logger.Errorw("Error shutting down", "error", err)
+	}
+}
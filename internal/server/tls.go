@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+// certReloadDebounce groups bursts of file events (cert and key are
+// usually replaced one after another) into a single reload.
+const certReloadDebounce = 500 * time.Millisecond
+
+var errNoCertificates = errors.New("no certificates found in client CA bundle")
+
+// certReloader keeps the server certificate and the client CA bundle
+// loaded from disk and reloads them when the files change.
+type certReloader struct {
+	cfg        config.TLSConfig
+	nextProtos []string
+	logger     *zap.SugaredLogger
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newCertReloader(cfg config.TLSConfig, nextProtos []string, logger *zap.SugaredLogger) (*certReloader, error) {
+	reloader := &certReloader{
+		cfg:        cfg,
+		nextProtos: nextProtos,
+		logger:     logger,
+	}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CA bundle: %w", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("loading client CA bundle %s: %w", r.cfg.ClientCAFile, errNoCertificates)
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(clientCAs)
+
+	return nil
+}
+
+// TLSConfig returns a config which picks up the latest certificate and
+// client CA bundle on every handshake.
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.cfg.Version(),
+		NextProtos: r.nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:   r.cfg.Version(),
+				CipherSuites: r.cfg.CipherSuiteIDs(),
+				NextProtos:   r.nextProtos,
+				Certificates: []tls.Certificate{*r.cert.Load()},
+			}
+
+			if clientCAs := r.clientCAs.Load(); clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = clientCAs
+			}
+
+			return cfg, nil
+		},
+	}
+}
+
+// Watch starts reloading certificates on file changes.
+func (r *certReloader) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	files := map[string]bool{}
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		files[filepath.Clean(file)] = true
+
+		// watch directories to notice files replaced with rename
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+
+	r.watcher = watcher
+	r.done = make(chan struct{})
+
+	r.wg.Add(1)
+	go r.loop(files)
+
+	return nil
+}
+
+func (r *certReloader) loop(files map[string]bool) {
+	defer r.wg.Done()
+
+	debounce := time.NewTimer(certReloadDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			debounce.Stop()
+			return
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if files[filepath.Clean(event.Name)] || filepath.Base(event.Name) == "..data" {
+				debounce.Reset(certReloadDebounce)
+			}
+		case <-debounce.C:
+			if err := r.load(); err != nil {
+				r.logger.Errorw("Certificate reload failed, keeping current certificate", "error", err)
+				continue
+			}
+			r.logger.Infow("Certificate reloaded", "cert", r.cfg.CertFile)
+		}
+	}
+}
+
+func (r *certReloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+
+	close(r.done)
+	err := r.watcher.Close()
+	r.wg.Wait()
+
+	return err
+}
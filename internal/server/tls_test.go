@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, serial int64, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) writeFiles(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+
+	if keyFile != "" {
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+		require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+func (c *testCert) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+// serveTLS accepts connections with the reloader config and completes
+// the handshake, returns the listener address.
+func serveTLS(t *testing.T, reloader *certReloader) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err == nil {
+					_, _ = conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// handshake dials addr and returns the certificate presented by server.
+func handshake(addr string, cfg *tls.Config) (*x509.Certificate, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// with TLS 1.3 rejected client certificate is reported on first read
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return nil, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func newTestPKI(t *testing.T) (ca *testCert, cfg config.TLSConfig) {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg = config.TLSConfig{
+		CertFile:   filepath.Join(dir, "server.crt"),
+		KeyFile:    filepath.Join(dir, "server.key"),
+		MinVersion: "1.2",
+	}
+
+	ca = newTestCert(t, 1, nil, true)
+	newTestCert(t, 2, ca, false).writeFiles(t, cfg.CertFile, cfg.KeyFile)
+
+	return ca, cfg
+}
+
+func Test_certReloader_TLS(t *testing.T) {
+	ca, cfg := newTestPKI(t)
+
+	reloader, err := newCertReloader(cfg, nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	addr := serveTLS(t, reloader)
+
+	cert, err := handshake(addr, &tls.Config{RootCAs: ca.pool()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cert.SerialNumber.Int64())
+}
+
+func Test_certReloader_MutualTLS(t *testing.T) {
+	ca, cfg := newTestPKI(t)
+	cfg.ClientCAFile = filepath.Join(t.TempDir(), "ca.crt")
+	ca.writeFiles(t, cfg.ClientCAFile, "")
+
+	reloader, err := newCertReloader(cfg, nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	addr := serveTLS(t, reloader)
+
+	t.Run("no client certificate", func(t *testing.T) {
+		_, err := handshake(addr, &tls.Config{RootCAs: ca.pool()})
+		require.Error(t, err)
+	})
+
+	t.Run("untrusted client certificate", func(t *testing.T) {
+		other := newTestCert(t, 10, newTestCert(t, 9, nil, true), false)
+		_, err := handshake(addr, &tls.Config{RootCAs: ca.pool(), Certificates: []tls.Certificate{other.tlsCertificate()}})
+		require.Error(t, err)
+	})
+
+	t.Run("trusted client certificate", func(t *testing.T) {
+		client := newTestCert(t, 3, ca, false)
+		_, err := handshake(addr, &tls.Config{RootCAs: ca.pool(), Certificates: []tls.Certificate{client.tlsCertificate()}})
+		require.NoError(t, err)
+	})
+}
+
+func Test_certReloader_Reload(t *testing.T) {
+	ca, cfg := newTestPKI(t)
+
+	reloader, err := newCertReloader(cfg, nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	require.NoError(t, reloader.Watch())
+	t.Cleanup(func() { _ = reloader.Close() })
+
+	addr := serveTLS(t, reloader)
+
+	newTestCert(t, 42, ca, false).writeFiles(t, cfg.CertFile, cfg.KeyFile)
+
+	assert.Eventually(t, func() bool {
+		cert, err := handshake(addr, &tls.Config{RootCAs: ca.pool()})
+		return err == nil && cert.SerialNumber.Int64() == 42
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func Test_newCertReloader_InvalidFiles(t *testing.T) {
+	_, cfg := newTestPKI(t)
+	cfg.ClientCAFile = filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(cfg.ClientCAFile, []byte("not a pem"), 0o600))
+
+	_, err := newCertReloader(cfg, nil, zap.NewNop().Sugar())
+	require.ErrorIs(t, err, errNoCertificates)
+
+	cfg.KeyFile = filepath.Join(t.TempDir(), "missing.key")
+	_, err = newCertReloader(cfg, nil, zap.NewNop().Sugar())
+	require.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/mailer"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrMailDisabled         = errors.New("email verification and password reset are not configured")
+	ErrInvalidEmail         = errors.New("invalid email address")
+	ErrEmailAlreadyExists   = errors.New("user with email already exists")
+	ErrNoEmail              = errors.New("user has no email address")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+	ErrInvalidToken         = errors.New("invalid or expired token")
+	ErrEmptyPassword        = errors.New("password must not be empty")
+)
+
+// resetMailTimeout bounds a password reset mail sent in the background.
+const resetMailTimeout = 30 * time.Second
+
+// normalizeEmail validates a bare address like alice@example.com and
+// lowercases it.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}
+
+// SendVerification mails a link confirming the email address of the user.
+func (userservice *userService) SendVerification(ctx context.Context, login string) error {
+	if err := authorize(ctx, PermissionUpdateUser, login); err != nil {
+		return err
+	}
+
+	return userservice.sendVerification(ctx, login)
+}
+
+func (userservice *userService) sendVerification(ctx context.Context, login string) error {
+	cfg := userservice.obs.Current().Mail
+	if !cfg.Enabled() {
+		return ErrMailDisabled
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Email == "" {
+		return ErrNoEmail
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	token := newSignedToken(cfg.Key(), purposeVerifyEmail, user.ID, user.Login,
+		time.Now().Add(cfg.VerifyTTL), []byte(user.Email))
+
+	return userservice.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nconfirm your email address within %s:\n\n%s\n",
+			displayName(user), cfg.VerifyTTL, tokenLink(cfg, "verify-email", token)),
+	})
+}
+
+// VerifyEmail marks the email address of the user from the token as
+// verified, the token can't be used again.
+func (userservice *userService) VerifyEmail(ctx context.Context, token string) (*domain.UserOut, error) {
+	cfg := userservice.obs.Current().Mail
+	if !cfg.Enabled() {
+		return nil, ErrMailDisabled
+	}
+
+	user, decoded, err := userservice.tokenUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Email == "" || user.EmailVerifiedAt != nil || !decoded.verify(cfg.Key(), purposeVerifyEmail, []byte(user.Email)) {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now().UTC()
+	if err := userservice.userRepository.SetEmailVerified(ctx, user.ID, now); err != nil {
+		return nil, err
+	}
+
+	return toUserOut(user), nil
+}
+
+// RequestPasswordReset mails a password reset link to a verified address.
+// Unknown and unverified addresses are ignored, so callers can't tell
+// which addresses are registered. The mail is sent in the background, a
+// reply doesn't take longer for registered addresses either.
+func (userservice *userService) RequestPasswordReset(ctx context.Context, email string) error {
+	cfg := userservice.obs.Current().Mail
+	if !cfg.Enabled() {
+		return ErrMailDisabled
+	}
+
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	user, err := userservice.userRepository.GetUserByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) || (err == nil && user.EmailVerifiedAt == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token := newSignedToken(cfg.Key(), purposeResetPassword, user.ID, user.Login,
+		time.Now().Add(cfg.ResetTTL), []byte(user.Password))
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nset a new password within %s:\n\n%s\n\n"+
+			"If you didn't ask to reset your password, ignore this message.\n",
+			displayName(user), cfg.ResetTTL, tokenLink(cfg, "reset-password", token)),
+	}
+
+	// the mailer logs failures, the caller gets the same reply either way
+	sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+	go func() {
+		defer cancel()
+		_ = userservice.mailer.Send(sendCtx, msg)
+	}()
+
+	return nil
+}
+
+// ResetPassword sets a new password and clears the lockout. The token is
+// bound to the old password hash, so it stops working once used.
+func (userservice *userService) ResetPassword(ctx context.Context, reset *domain.PasswordReset) error {
+	cfg := userservice.obs.Current().Mail
+	if !cfg.Enabled() {
+		return ErrMailDisabled
+	}
+
+	if reset.Password == "" {
+		return ErrEmptyPassword
+	}
+
+	user, decoded, err := userservice.tokenUser(ctx, reset.Token)
+	if err != nil {
+		return err
+	}
+
+	if !decoded.verify(cfg.Key(), purposeResetPassword, []byte(user.Password)) {
+		return ErrInvalidToken
+	}
+
+	password, err := hashPassword(reset.Password)
+	if err != nil {
+		return err
+	}
+
+	if err := userservice.userRepository.SetPassword(ctx, user.ID, password, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return userservice.resetLockout(ctx, user)
+}
+
+// tokenUser decodes the token and loads its user, the MAC is checked by
+// the caller against the state the token is bound to.
+func (userservice *userService) tokenUser(ctx context.Context, token string) (*domain.User, *signedToken, error) {
+	decoded, ok := decodeSignedToken(token, time.Now())
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, decoded.login)
+	if errors.Is(err, repository.ErrUserNotFound) || (err == nil && user.ID != decoded.userID) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, decoded, nil
+}
+
+// tokenLink returns a link to page on mail.base_url or the bare token.
+func tokenLink(cfg config.MailConfig, page, token string) string {
+	if cfg.BaseURL == "" {
+		return token
+	}
+
+	return strings.TrimSuffix(cfg.BaseURL, "/") + "/" + page + "?token=" + url.QueryEscape(token)
+}
+
+func displayName(user *domain.User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Login
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidExpiry  = errors.New("api key expiry is in the past")
+)
+
+// apiKeyPrefix starts every key, keys look like gu_<id>_<secret>.
+const apiKeyPrefix = "gu_"
+
+// apiKeyTouchInterval limits writes of the last use time to one per key
+// and interval.
+const apiKeyTouchInterval = time.Minute
+
+// IsAPIKey tells whether a bearer token is an API key.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+type APIKeyServiceInterface interface {
+	CreateAPIKey(ctx context.Context, in *domain.APIKeyIn) (*domain.APIKeyOut, error)
+	ListAPIKeys(ctx context.Context) ([]domain.APIKeyOut, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	Authenticate(ctx context.Context, key string) (*auth.Principal, error)
+}
+
+type apiKeyService struct {
+	apiKeyRepository repository.APIKeyRepository
+	logger           *zap.SugaredLogger
+}
+
+// CreateAPIKey generates a key, the returned key is the only copy of the
+// secret.
+func (apikeyservice *apiKeyService) CreateAPIKey(ctx context.Context, in *domain.APIKeyIn) (*domain.APIKeyOut, error) {
+	if err := authorize(ctx, PermissionManageAPIKeys, ""); err != nil {
+		return nil, err
+	}
+
+	scopes, err := validateScopes(in.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(now) {
+		return nil, ErrInvalidExpiry
+	}
+
+	id, secret := strings.ToLower(rand.Text()[:12]), rand.Text()
+
+	key := &domain.APIKey{
+		ID:         id,
+		Name:       in.Name,
+		SecretHash: hashAPIKeySecret(secret),
+		Scopes:     scopes,
+		CreatedAt:  now,
+		ExpiresAt:  in.ExpiresAt,
+	}
+
+	if err := apikeyservice.apiKeyRepository.CreateAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	out := toAPIKeyOut(key)
+	out.Key = apiKeyPrefix + id + "_" + secret
+
+	return out, nil
+}
+
+func (apikeyservice *apiKeyService) ListAPIKeys(ctx context.Context) ([]domain.APIKeyOut, error) {
+	if err := authorize(ctx, PermissionManageAPIKeys, ""); err != nil {
+		return nil, err
+	}
+
+	keys, err := apikeyservice.apiKeyRepository.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]domain.APIKeyOut, 0, len(keys))
+	for i := range keys {
+		out = append(out, *toAPIKeyOut(&keys[i]))
+	}
+
+	return out, nil
+}
+
+func (apikeyservice *apiKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := authorize(ctx, PermissionManageAPIKeys, ""); err != nil {
+		return err
+	}
+
+	err := apikeyservice.apiKeyRepository.RevokeAPIKey(ctx, id, time.Now().UTC())
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return ErrAPIKeyNotFound
+	}
+
+	return err
+}
+
+// Authenticate checks the key and returns a principal holding its scopes.
+// Unknown, revoked and expired keys are all rejected with ErrInvalidAPIKey.
+func (apikeyservice *apiKeyService) Authenticate(ctx context.Context, key string) (*auth.Principal, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok || !IsAPIKey(key) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	stored, err := apikeyservice.apiKeyRepository.GetAPIKey(ctx, id)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(stored.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= apiKeyTouchInterval {
+		if err := apikeyservice.apiKeyRepository.TouchAPIKey(ctx, id, now); err != nil {
+			apikeyservice.logger.Warnw("Error updating api key last use", "id", id, "err", err)
+		}
+	}
+
+	return &auth.Principal{Method: auth.MethodAPIKey, APIKeyID: id, Scopes: stored.Scopes}, nil
+}
+
+// hashAPIKeySecret hashes the random part of a key. Secrets are long random
+// strings, a fast hash is enough for them.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func toAPIKeyOut(key *domain.APIKey) *domain.APIKeyOut {
+	return &domain.APIKeyOut{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+func NewAPIKeyService(apiKeyRepository repository.APIKeyRepository, logger *zap.Logger) APIKeyServiceInterface {
+	return &apiKeyService{
+		apiKeyRepository: apiKeyRepository,
+		logger:           logger.Named("APIKeyService").Sugar(),
+	}
+}
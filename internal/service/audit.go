@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+// MaxAuditEvents limits events returned at once, more are read with
+// AfterID.
+const (
+	MaxAuditEvents     = 1000
+	defaultAuditEvents = 100
+)
+
+type AuditServiceInterface interface {
+	ListAuditEvents(ctx context.Context, filter *domain.AuditFilter) ([]domain.AuditEvent, error)
+}
+
+type auditService struct {
+	auditRepository repository.AuditRepository
+}
+
+func (auditservice *auditService) ListAuditEvents(ctx context.Context, filter *domain.AuditFilter) ([]domain.AuditEvent, error) {
+	if err := authorize(ctx, PermissionReadAudit, ""); err != nil {
+		return nil, err
+	}
+
+	query := *filter
+	if query.Limit <= 0 {
+		query.Limit = defaultAuditEvents
+	}
+	query.Limit = min(query.Limit, MaxAuditEvents)
+
+	events, err := auditservice.auditRepository.ListAuditEvents(ctx, &query)
+	if err != nil {
+		return nil, err
+	}
+
+	if events == nil {
+		events = []domain.AuditEvent{}
+	}
+
+	return events, nil
+}
+
+func NewAuditService(auditRepository repository.AuditRepository) AuditServiceInterface {
+	return &auditService{auditRepository: auditRepository}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	ErrForbidden          = errors.New("forbidden")
+	ErrMFARequired        = errors.New("two-factor authentication is enabled, password is not enough")
+)
+
+// LockedError is returned while an account is locked after failed logins.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrAccountLocked, e.Until.Format(time.RFC3339))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// authenticate checks the login and password. Locked accounts are rejected
+// without checking the password, failed attempts lock the account for a
+// growing delay and for lockout.duration once lockout.max_attempts is hit.
+//
+// Failed attempts are not reset here: with two-factor authentication the
+// counter has to survive until the second step succeeds.
+func (loginservice *loginService) authenticate(ctx context.Context, credentials *domain.Credentials) (*domain.User, error) {
+	user, err := loginservice.userRepository.GetUserAuth(ctx, credentials.Login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		checkPassword(dummyHash(), credentials.Password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lockout := loginservice.obs.Current().Lockout
+	now := time.Now().UTC()
+
+	if err := checkLocked(user, lockout, now); err != nil {
+		return nil, err
+	}
+
+	if !checkPassword(user.Password, credentials.Password) {
+		if lockout.Enabled() {
+			if err := loginservice.addFailedAttempt(ctx, user, lockout, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// Authenticate checks credentials sent along a request and returns the
+// principal. Users with two-factor authentication enabled have to use
+// another way in.
+func (userservice *userService) Authenticate(ctx context.Context, credentials *domain.Credentials) (*auth.Principal, error) {
+	user, err := userservice.authenticate(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := userservice.userRepository.GetTOTP(ctx, user.ID)
+	if err == nil && secret.ConfirmedAt != nil {
+		return nil, ErrMFARequired
+	}
+	if err != nil && !errors.Is(err, repository.ErrTOTPNotFound) {
+		return nil, err
+	}
+
+	if err := userservice.resetLockout(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return userservice.principal(ctx, user, auth.MethodBasic)
+}
+
+// AuthenticateToken checks an access token issued on login. Roles are
+// looked up on every request, so changes apply before the token expires.
+func (userservice *userService) AuthenticateToken(ctx context.Context, token string) (*auth.Principal, error) {
+	cfg := userservice.obs.Current().Auth
+	if !cfg.Enabled() {
+		return nil, auth.ErrInvalidToken
+	}
+
+	userID, login, err := auth.ParseToken(cfg.Key(), cfg.Issuer, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) || (err == nil && user.ID != userID) {
+		return nil, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return userservice.principal(ctx, user, auth.MethodJWT)
+}
+
+// AuthenticateClientCert maps the common name of a verified client
+// certificate to the user with that login.
+func (userservice *userService) AuthenticateClientCert(ctx context.Context, commonName string) (*auth.Principal, error) {
+	user, err := userservice.userRepository.GetUserAuth(ctx, commonName)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return userservice.principal(ctx, user, auth.MethodClientCert)
+}
+
+func (userservice *userService) principal(ctx context.Context, user *domain.User, method auth.Method) (*auth.Principal, error) {
+	roles, err := userservice.userRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.Principal{Method: method, UserID: user.ID, Login: user.Login, Roles: roles}, nil
+}
+
+// loginResult returns the logged in user along with an access token when
+// auth.jwt_key is set.
+func (loginservice *loginService) loginResult(user *domain.User) (*domain.LoginResult, error) {
+	result := &domain.LoginResult{User: toUserOut(user)}
+
+	cfg := loginservice.obs.Current().Auth
+	if !cfg.Enabled() {
+		return result, nil
+	}
+
+	expires := time.Now().Add(cfg.TokenTTL).UTC().Truncate(time.Second)
+
+	token, err := auth.NewToken(cfg.Key(), cfg.Issuer, user.ID, user.Login, expires)
+	if err != nil {
+		return nil, err
+	}
+	result.AccessToken, result.ExpiresAt = token, &expires
+
+	return result, nil
+}
+
+func checkLocked(user *domain.User, lockout config.LockoutConfig, now time.Time) error {
+	if lockout.Enabled() && user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return &LockedError{Until: *user.LockedUntil}
+	}
+
+	return nil
+}
+
+// resetLockout clears failed attempts after a successful login.
+func (loginservice *loginService) resetLockout(ctx context.Context, user *domain.User) error {
+	if user.FailedAttempts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+
+	if err := loginservice.userRepository.SetLockout(ctx, user.ID, 0, nil); err != nil {
+		return err
+	}
+	user.FailedAttempts, user.LockedUntil = 0, nil
+
+	return nil
+}
+
+func (loginservice *loginService) addFailedAttempt(
+	ctx context.Context, user *domain.User, lockout config.LockoutConfig, now time.Time,
+) error {
+	attempts, err := loginservice.userRepository.AddFailedAttempt(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	lockedUntil := now.Add(lockout.DelayAfter(attempts))
+	if attempts >= lockout.MaxAttempts {
+		// the counter starts over once the lockout is served
+		attempts = 0
+	}
+
+	return loginservice.userRepository.SetLockout(ctx, user.ID, attempts, &lockedUntil)
+}
+
+// UnlockUser clears failed attempts and the lockout of the user.
+func (userservice *userService) UnlockUser(ctx context.Context, login string) error {
+	if err := authorize(ctx, PermissionManageUser, login); err != nil {
+		return err
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return userservice.userRepository.SetLockout(ctx, user.ID, 0, nil)
+}
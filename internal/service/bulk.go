@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrEmptyLogin          = errors.New("login must not be empty")
+	ErrAmbiguousPassword   = errors.New("either password or password_hash must be set, not both")
+	ErrInvalidPasswordHash = errors.New("password_hash must be a bcrypt or argon2id hash")
+)
+
+// rowErrors are the problems of single rows, reported in their results.
+// Other errors fail the whole batch.
+var rowErrors = []error{
+	ErrEmptyLogin, ErrEmptyPassword, ErrAmbiguousPassword, ErrInvalidPasswordHash,
+	ErrInvalidEmail, ErrUserAlreadyExists, ErrEmailAlreadyExists,
+}
+
+// ImportBatchSize is how many rows are created in one transaction.
+const ImportBatchSize = 100
+
+// MaxExportUsers limits users exported at once, more are read with after.
+const MaxExportUsers = 1000
+
+type BulkServiceInterface interface {
+	// ImportUsers creates a batch of users in one transaction, rows which
+	// fail validation are reported and skipped, other errors fail the batch.
+	// Nothing is created in dry runs. An empty batch only checks the caller
+	// may import.
+	ImportUsers(ctx context.Context, rows []domain.UserImport, dryRun bool) ([]domain.ImportResult, error)
+	// ExportUsers returns users with login greater than after ordered by
+	// login, password hashes are only included when asked for.
+	ExportUsers(ctx context.Context, after string, limit int, passwordHashes bool) ([]domain.UserExport, error)
+}
+
+type bulkService struct {
+	userRepository repository.UserRepository
+}
+
+// imported is a valid row waiting to be created.
+type imported struct {
+	result   *domain.ImportResult
+	user     domain.User
+	password string
+}
+
+func (bulkservice *bulkService) ImportUsers(
+	ctx context.Context, rows []domain.UserImport, dryRun bool,
+) ([]domain.ImportResult, error) {
+	if err := authorize(ctx, PermissionCreateUser, ""); err != nil {
+		return nil, err
+	}
+
+	results := make([]domain.ImportResult, len(rows))
+	batch := make([]imported, 0, len(rows))
+	logins, emails := map[string]bool{}, map[string]bool{}
+	now := time.Now().UTC()
+
+	for i := range rows {
+		row := &rows[i]
+		results[i] = domain.ImportResult{Row: row.Row, Login: row.Login}
+
+		user, err := bulkservice.validate(ctx, row, logins, emails)
+		if err != nil {
+			if !slices.ContainsFunc(rowErrors, func(target error) bool { return errors.Is(err, target) }) {
+				return nil, err
+			}
+			results[i].Status, results[i].Error = domain.ImportFailed, err.Error()
+			continue
+		}
+
+		user.ID, user.CreatedAt, user.UpdatedAt = uuid.New(), now, now
+		batch = append(batch, imported{result: &results[i], user: *user, password: row.Password})
+	}
+
+	if dryRun {
+		for _, row := range batch {
+			row.result.Status = domain.ImportValid
+		}
+		return results, nil
+	}
+
+	if err := hashPasswords(batch); err != nil {
+		return nil, err
+	}
+
+	users := make([]domain.User, 0, len(batch))
+	for _, row := range batch {
+		users = append(users, row.user)
+	}
+
+	if len(users) > 0 {
+		err := bulkservice.userRepository.CreateUsers(ctx, users)
+		if errors.Is(err, repository.ErrUserLoginExists) {
+			// created meanwhile by someone else, the batch is rolled back
+			err = ErrUserAlreadyExists
+		} else if err != nil {
+			return nil, err
+		}
+		for _, row := range batch {
+			if err != nil {
+				row.result.Status, row.result.Error = domain.ImportFailed, err.Error()
+				continue
+			}
+			row.result.Status, row.result.ID = domain.ImportCreated, &row.user.ID
+		}
+	}
+
+	return results, nil
+}
+
+// validate returns the user to create from the row, logins and emails
+// hold those taken by earlier rows of the batch.
+func (bulkservice *bulkService) validate(
+	ctx context.Context, row *domain.UserImport, logins, emails map[string]bool,
+) (*domain.User, error) {
+	switch {
+	case row.Login == "":
+		return nil, ErrEmptyLogin
+	case row.Password == "" && row.PasswordHash == "":
+		return nil, ErrEmptyPassword
+	case row.Password != "" && row.PasswordHash != "":
+		return nil, ErrAmbiguousPassword
+	case row.PasswordHash != "" && !validPasswordHash(row.PasswordHash):
+		return nil, ErrInvalidPasswordHash
+	}
+
+	if logins[row.Login] {
+		return nil, ErrUserAlreadyExists
+	}
+	if _, err := bulkservice.userRepository.GetUser(ctx, row.Login); err == nil {
+		return nil, ErrUserAlreadyExists
+	}
+
+	var email string
+	if row.Email != "" {
+		normalized, err := normalizeEmail(row.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		if emails[normalized] {
+			return nil, ErrEmailAlreadyExists
+		}
+		_, err = bulkservice.userRepository.GetUserByEmail(ctx, normalized)
+		if err == nil {
+			return nil, ErrEmailAlreadyExists
+		}
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		email = normalized
+	}
+
+	logins[row.Login] = true
+	if email != "" {
+		emails[email] = true
+	}
+
+	return &domain.User{Login: row.Login, Password: row.PasswordHash, Name: row.Name, Email: email}, nil
+}
+
+// hashPasswords hashes plain passwords of the batch in parallel, bcrypt
+// takes most of the time of an import.
+func hashPasswords(batch []imported) error {
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	slots := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	for i := range batch {
+		if batch[i].password == "" {
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+
+			hash, err := hashPassword(batch[i].password)
+			if err != nil {
+				errOnce.Do(func() { firstErr = err })
+				return
+			}
+			batch[i].user.Password = hash
+		})
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+func (bulkservice *bulkService) ExportUsers(
+	ctx context.Context, after string, limit int, passwordHashes bool,
+) ([]domain.UserExport, error) {
+	if err := authorize(ctx, PermissionManageUser, ""); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > MaxExportUsers {
+		limit = MaxExportUsers
+	}
+
+	users, err := bulkservice.userRepository.ListUsers(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]domain.UserExport, 0, len(users))
+	for _, user := range users {
+		export := domain.UserExport{
+			ID:              user.ID,
+			Login:           user.Login,
+			Name:            user.Name,
+			Email:           user.Email,
+			EmailVerifiedAt: user.EmailVerifiedAt,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       user.UpdatedAt,
+		}
+		if passwordHashes {
+			export.PasswordHash = user.Password
+		}
+		exported = append(exported, export)
+	}
+
+	return exported, nil
+}
+
+func NewBulkService(userRepository repository.UserRepository) BulkServiceInterface {
+	return &bulkService{userRepository: userRepository}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ceremony is a started WebAuthn registration or login. Login is set for
+// registrations and for logins started with a login.
+type ceremony struct {
+	session      webauthn.SessionData
+	registration bool
+	login        string
+	name         string
+	expires      time.Time
+}
+
+// ceremonyStore keeps started ceremonies in memory, every ceremony can be
+// finished once.
+type ceremonyStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]ceremony
+}
+
+func newCeremonyStore() *ceremonyStore {
+	return &ceremonyStore{ceremonies: make(map[string]ceremony)}
+}
+
+func (s *ceremonyStore) Put(c ceremony) string {
+	id := rand.Text()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, other := range s.ceremonies {
+		if now.After(other.expires) {
+			delete(s.ceremonies, key)
+		}
+	}
+
+	s.ceremonies[id] = c
+
+	return id
+}
+
+// Take removes the ceremony and returns it unless it has expired.
+func (s *ceremonyStore) Take(id string) (ceremony, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.ceremonies[id]
+	delete(s.ceremonies, id)
+
+	if !ok || time.Now().After(c.expires) {
+		return ceremony{}, false
+	}
+
+	return c, true
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/audit"
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be at most 255 characters")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was used with a different request")
+	ErrIdempotencyKeyInUse   = errors.New("request with the idempotency key is still in progress")
+	ErrIdempotencyKeyCaller  = errors.New("idempotency key needs credentials or a known client address")
+)
+
+const (
+	maxIdempotencyKey = 255
+	// abandonedAfter frees keys of requests which never finished, e.g.
+	// because the service was restarted meanwhile.
+	abandonedAfter = time.Minute
+)
+
+// createUserOnce creates the user the first time the caller sends the key
+// and replays the stored response to retries with the same request.
+func (userservice *userService) createUserOnce(ctx context.Context, user *domain.UserIn, ttl time.Duration) (*domain.UserOut, error) {
+	if len(user.IdempotencyKey) > maxIdempotencyKey {
+		return nil, ErrInvalidIdempotencyKey
+	}
+
+	caller, ok := idempotencyCaller(ctx)
+	if !ok {
+		return nil, ErrIdempotencyKeyCaller
+	}
+
+	now := time.Now().UTC()
+	record := &domain.IdempotencyRecord{
+		Caller:      caller,
+		Key:         user.IdempotencyKey,
+		Fingerprint: fingerprint(user.Login, user.Name, user.Email),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	earlier, err := userservice.idempotencyRepository.Reserve(ctx, record, now.Add(-abandonedAfter))
+	if err != nil {
+		return nil, err
+	}
+	if earlier != nil {
+		return userservice.replay(ctx, earlier, record, user)
+	}
+
+	// the client may have given up waiting, the outcome is recorded for
+	// its retry anyway
+	recordCtx := context.WithoutCancel(ctx)
+
+	created, err := userservice.createUser(ctx, user)
+	if err != nil {
+		_ = userservice.idempotencyRepository.Release(recordCtx, record.Caller, record.Key)
+		return nil, err
+	}
+
+	response, err := json.Marshal(created)
+	if err == nil {
+		err = userservice.idempotencyRepository.Complete(recordCtx, record.Caller, record.Key, response)
+	}
+	if err != nil {
+		// retries fail as without a key rather than waiting for the ttl
+		_ = userservice.idempotencyRepository.Release(recordCtx, record.Caller, record.Key)
+	}
+
+	return created, nil
+}
+
+// idempotencyCaller returns the namespace of the keys of the caller.
+// Anonymous callers get one per client address, so they can't replay or
+// block each other's requests.
+func idempotencyCaller(ctx context.Context) (string, bool) {
+	if _, ok := auth.FromContext(ctx); ok {
+		return audit.Actor(ctx), true
+	}
+
+	request, ok := audit.FromContext(ctx)
+	if !ok || request.ClientIP == "" {
+		return "", false
+	}
+
+	return audit.Anonymous + ":" + request.ClientIP, true
+}
+
+func (userservice *userService) replay(
+	ctx context.Context, earlier, record *domain.IdempotencyRecord, user *domain.UserIn,
+) (*domain.UserOut, error) {
+	if earlier.Fingerprint != record.Fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if earlier.Response == nil {
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	// passwords are left out of fingerprints, the password of the created
+	// user is checked instead
+	if created, err := userservice.userRepository.GetUserAuth(ctx, user.Login); err == nil {
+		if !checkPassword(created.Password, user.Password) {
+			return nil, ErrIdempotencyKeyReused
+		}
+	}
+
+	var replayed domain.UserOut
+	if err := json.Unmarshal(earlier.Response, &replayed); err != nil {
+		return nil, err
+	}
+
+	return &replayed, nil
+}
+
+// fingerprint identifies the request made with an idempotency key.
+func fingerprint(fields ...string) string {
+	hash := sha256.New()
+	for _, field := range fields {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
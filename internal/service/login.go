@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+// LoginServiceInterface holds the steps of a login shared by passwords and
+// passkeys, so both count failed attempts and issue tokens the same way.
+type LoginServiceInterface interface {
+	// CheckPassword returns the user when the password is right, see
+	// authenticate.
+	CheckPassword(ctx context.Context, credentials *domain.Credentials) (*domain.User, error)
+	// CheckSecondFactor requires a valid TOTP code from users with TOTP
+	// enabled, wrong codes count as failed login attempts.
+	CheckSecondFactor(ctx context.Context, user *domain.User, code string) error
+	// CheckLocked returns a LockedError while the account is locked.
+	CheckLocked(user *domain.User) error
+	// ResetLockout clears failed attempts after a successful login.
+	ResetLockout(ctx context.Context, user *domain.User) error
+	// LoginResult returns the logged in user along with an access token.
+	LoginResult(user *domain.User) (*domain.LoginResult, error)
+}
+
+type loginService struct {
+	userRepository repository.UserRepository
+	obs            *config.Observer
+}
+
+var _ LoginServiceInterface = (*loginService)(nil)
+
+func (loginservice *loginService) CheckPassword(ctx context.Context, credentials *domain.Credentials) (*domain.User, error) {
+	return loginservice.authenticate(ctx, credentials)
+}
+
+func (loginservice *loginService) CheckSecondFactor(ctx context.Context, user *domain.User, code string) error {
+	secret, err := loginservice.userRepository.GetTOTP(ctx, user.ID)
+	if errors.Is(err, repository.ErrTOTPNotFound) || (err == nil && secret.ConfirmedAt == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	mfa := loginservice.obs.Current().MFA
+	if !mfa.Enabled() {
+		return ErrMFADisabled
+	}
+
+	now := time.Now().UTC()
+
+	valid, err := loginservice.useTOTPCode(ctx, mfa, secret, code, now)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		if lockout := loginservice.obs.Current().Lockout; lockout.Enabled() {
+			if err := loginservice.addFailedAttempt(ctx, user, lockout, now); err != nil {
+				return err
+			}
+		}
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (loginservice *loginService) CheckLocked(user *domain.User) error {
+	return checkLocked(user, loginservice.obs.Current().Lockout, time.Now().UTC())
+}
+
+func (loginservice *loginService) ResetLockout(ctx context.Context, user *domain.User) error {
+	return loginservice.resetLockout(ctx, user)
+}
+
+func (loginservice *loginService) LoginResult(user *domain.User) (*domain.LoginResult, error) {
+	return loginservice.loginResult(user)
+}
+
+func NewLoginService(userRepository repository.UserRepository, obs *config.Observer) LoginServiceInterface {
+	return &loginService{userRepository, obs}
+}
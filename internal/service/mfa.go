@@ -0,0 +1,348 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrMFADisabled        = errors.New("two-factor authentication is not configured")
+	ErrTOTPAlreadyEnabled = errors.New("totp is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp enrolment is not started")
+	ErrInvalidCode        = errors.New("invalid code")
+	ErrInvalidMFAToken    = errors.New("invalid or expired mfa token")
+)
+
+const (
+	totpPeriod        = 30
+	totpSkew          = 1
+	qrSize            = 256
+	recoveryCodeCount = 10
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// Login checks the password and, when the user has TOTP enabled, returns
+// a token for the second step instead of the user.
+func (userservice *userService) Login(ctx context.Context, credentials *domain.Credentials) (*domain.LoginResult, error) {
+	user, err := userservice.authenticate(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := userservice.userRepository.GetTOTP(ctx, user.ID)
+	if errors.Is(err, repository.ErrTOTPNotFound) || (err == nil && secret.ConfirmedAt == nil) {
+		if err := userservice.resetLockout(ctx, user); err != nil {
+			return nil, err
+		}
+		return userservice.loginResult(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mfa := userservice.obs.Current().MFA
+	if !mfa.Enabled() {
+		return nil, ErrMFADisabled
+	}
+
+	token := newSignedToken(mfa.Key(), purposeMFA, user.ID, user.Login, time.Now().Add(mfa.ChallengeTTL), mfaState(secret))
+
+	return &domain.LoginResult{MFAToken: token}, nil
+}
+
+// mfaState is what MFA tokens are bound to. Every accepted code uses up a
+// time step or a recovery code, so a token completes one login only.
+func mfaState(secret *domain.TOTP) []byte {
+	return fmt.Appendf(nil, "%d/%d", secret.LastStep, secret.RecoveryCodes)
+}
+
+// LoginMFA completes the login with a TOTP or a recovery code. Wrong codes
+// count as failed login attempts, the token can be used until a code is
+// accepted.
+func (userservice *userService) LoginMFA(ctx context.Context, code *domain.MFACode) (*domain.LoginResult, error) {
+	mfa := userservice.obs.Current().MFA
+	if !mfa.Enabled() {
+		return nil, ErrMFADisabled
+	}
+
+	token, ok := decodeSignedToken(code.MFAToken, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, token.login)
+	if errors.Is(err, repository.ErrUserNotFound) || (err == nil && user.ID != token.userID) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := userservice.userRepository.GetTOTP(ctx, user.ID)
+	if errors.Is(err, repository.ErrTOTPNotFound) || (err == nil && secret.ConfirmedAt == nil) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.verify(mfa.Key(), purposeMFA, mfaState(secret)) {
+		return nil, ErrInvalidMFAToken
+	}
+
+	lockout := userservice.obs.Current().Lockout
+	now := time.Now().UTC()
+
+	if err := checkLocked(user, lockout, now); err != nil {
+		return nil, err
+	}
+
+	var valid bool
+	if code.RecoveryCode != "" {
+		valid, err = userservice.userRepository.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code.RecoveryCode), now)
+	} else {
+		valid, err = userservice.useTOTPCode(ctx, mfa, secret, code.Code, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		if lockout.Enabled() {
+			if err := userservice.addFailedAttempt(ctx, user, lockout, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidCode
+	}
+
+	if err := userservice.resetLockout(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return userservice.loginResult(user)
+}
+
+// EnrollTOTP generates a new TOTP secret for the user, it is used for
+// logins once confirmed with ConfirmTOTP.
+func (userservice *userService) EnrollTOTP(ctx context.Context, credentials *domain.Credentials) (*domain.TOTPEnrollment, error) {
+	mfa := userservice.obs.Current().MFA
+	if !mfa.Enabled() {
+		return nil, ErrMFADisabled
+	}
+
+	user, err := userservice.authenticate(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := userservice.userRepository.GetTOTP(ctx, user.ID)
+	if err == nil && current.ConfirmedAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if err != nil && !errors.Is(err, repository.ErrTOTPNotFound) {
+		return nil, err
+	}
+
+	if err := userservice.resetLockout(ctx, user); err != nil {
+		return nil, err
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      mfa.Issuer,
+		AccountName: user.Login,
+		Period:      totpPeriod,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := sealSecret(mfa.Key(), user.ID, []byte(key.Secret()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := userservice.userRepository.SaveTOTP(ctx, &domain.TOTP{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(qrSize, qrSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return nil, err
+	}
+
+	return &domain.TOTPEnrollment{URI: key.URL(), QRPNG: qr.Bytes()}, nil
+}
+
+// ConfirmTOTP enables TOTP after checking the first code and returns
+// recovery codes, they are stored hashed and can't be shown again. Wrong
+// codes count as failed login attempts.
+func (userservice *userService) ConfirmTOTP(ctx context.Context, credentials *domain.Credentials, code string) ([]string, error) {
+	mfa := userservice.obs.Current().MFA
+	if !mfa.Enabled() {
+		return nil, ErrMFADisabled
+	}
+
+	user, err := userservice.authenticate(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := userservice.userRepository.GetTOTP(ctx, user.ID)
+	if errors.Is(err, repository.ErrTOTPNotFound) {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret.ConfirmedAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	now := time.Now().UTC()
+
+	valid, err := userservice.useTOTPCode(ctx, mfa, secret, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		if lockout := userservice.obs.Current().Lockout; lockout.Enabled() {
+			if err := userservice.addFailedAttempt(ctx, user, lockout, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidCode
+	}
+
+	if err := userservice.resetLockout(ctx, user); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := userservice.userRepository.ConfirmTOTP(ctx, user.ID, now, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// useTOTPCode checks the code against the current time step and its
+// neighbours. Every step is accepted once.
+func (loginservice *loginService) useTOTPCode(
+	ctx context.Context, mfa config.MFAConfig, secret *domain.TOTP, code string, now time.Time,
+) (bool, error) {
+	key, err := openSecret(mfa.Key(), secret.UserID, secret.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		t := now.Add(time.Duration(skew) * totpPeriod * time.Second)
+
+		expected, err := totp.GenerateCodeCustom(string(key), t, totpOpts)
+		if err != nil {
+			return false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return loginservice.userRepository.UseTOTPStep(ctx, secret.UserID, t.Unix()/totpPeriod)
+		}
+	}
+
+	return false, nil
+}
+
+// sealSecret encrypts the TOTP secret with AES-GCM bound to the user.
+func sealSecret(key []byte, userID uuid.UUID, secret []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, secret, userID[:]), nil
+}
+
+func openSecret(key []byte, userID uuid.UUID, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted totp secret is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, userID[:])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// newRecoveryCode returns a random code like "k3mzq-8vx2p".
+func newRecoveryCode() string {
+	code := strings.ToLower(rand.Text()[:10])
+	return code[:5] + "-" + code[5:]
+}
+
+// hashRecoveryCode normalizes and hashes the code. Codes are random
+// enough for a plain SHA-256.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,443 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrPasskeysDisabled     = errors.New("passkeys are not configured")
+	ErrInvalidCeremony      = errors.New("invalid or expired webauthn session")
+	ErrPasskeyRejected      = errors.New("passkey verification failed")
+	ErrPasskeyCloned        = errors.New("passkey signature counter went back, the authenticator may be cloned")
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrPasskeyAlreadyExists = errors.New("passkey is already registered")
+)
+
+type PasskeyServiceInterface interface {
+	BeginRegistration(ctx context.Context, registration *domain.PasskeyRegistration) (*domain.PasskeyCeremony, error)
+	FinishRegistration(ctx context.Context, sessionID string, response io.Reader) (*domain.Passkey, error)
+	BeginLogin(ctx context.Context, login string) (*domain.PasskeyCeremony, error)
+	FinishLogin(ctx context.Context, sessionID string, response io.Reader) (*domain.LoginResult, error)
+	ListPasskeys(ctx context.Context, login string) ([]domain.Passkey, error)
+	DeletePasskey(ctx context.Context, login string, id []byte) error
+}
+
+type passkeyService struct {
+	userRepository    repository.UserRepository
+	logins            LoginServiceInterface
+	passkeyRepository repository.PasskeyRepository
+	obs               *config.Observer
+	ceremonies        *ceremonyStore
+	logger            *zap.SugaredLogger
+}
+
+// BeginRegistration checks the password, and the TOTP code when it's
+// enabled, and starts registration of a new passkey.
+func (passkeyservice *passkeyService) BeginRegistration(
+	ctx context.Context, registration *domain.PasskeyRegistration,
+) (*domain.PasskeyCeremony, error) {
+	cfg, relyingParty, err := passkeyservice.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := passkeyservice.logins.CheckPassword(ctx, &domain.Credentials{
+		Login:    registration.Login,
+		Password: registration.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := passkeyservice.logins.CheckSecondFactor(ctx, user, registration.Code); err != nil {
+		return nil, err
+	}
+
+	if err := passkeyservice.logins.ResetLockout(ctx, user); err != nil {
+		return nil, err
+	}
+
+	owner, err := passkeyservice.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := relyingParty.BeginRegistration(owner,
+		webauthn.WithExclusions(webauthn.Credentials(owner.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	name := registration.Name
+	if name == "" {
+		name = "Passkey " + strconv.Itoa(len(owner.credentials)+1)
+	}
+
+	return passkeyservice.newCeremony(creation, ceremony{
+		session:      *session,
+		registration: true,
+		login:        user.Login,
+		name:         name,
+		expires:      time.Now().Add(cfg.SessionTTL),
+	})
+}
+
+func (passkeyservice *passkeyService) FinishRegistration(
+	ctx context.Context, sessionID string, response io.Reader,
+) (*domain.Passkey, error) {
+	_, relyingParty, err := passkeyservice.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	started, ok := passkeyservice.ceremonies.Take(sessionID)
+	if !ok || !started.registration {
+		return nil, ErrInvalidCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPasskeyRejected, err)
+	}
+
+	user, err := passkeyservice.userRepository.GetUserAuth(ctx, started.login)
+	if errors.Is(err, repository.ErrUserNotFound) || (err == nil && !bytes.Equal(user.ID[:], started.session.UserID)) {
+		return nil, ErrInvalidCeremony
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := passkeyservice.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := relyingParty.CreateCredential(owner, started.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPasskeyRejected, err)
+	}
+
+	record, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey := &domain.Passkey{
+		ID:         credential.ID,
+		UserID:     user.ID,
+		Name:       started.name,
+		Credential: record,
+		SignCount:  credential.Authenticator.SignCount,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	err = passkeyservice.passkeyRepository.CreatePasskey(ctx, passkey)
+	if errors.Is(err, repository.ErrPasskeyExists) {
+		return nil, ErrPasskeyAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return passkey, nil
+}
+
+// BeginLogin starts a login with the passkeys of the user, without login
+// the browser offers discoverable passkeys of any user.
+func (passkeyservice *passkeyService) BeginLogin(ctx context.Context, login string) (*domain.PasskeyCeremony, error) {
+	cfg, relyingParty, err := passkeyservice.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	started := ceremony{login: login, expires: time.Now().Add(cfg.SessionTTL)}
+
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+	)
+
+	if login == "" {
+		assertion, session, err = relyingParty.BeginDiscoverableLogin()
+	} else {
+		assertion, session, err = passkeyservice.beginUserLogin(ctx, relyingParty, login)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	started.session = *session
+
+	return passkeyservice.newCeremony(assertion, started)
+}
+
+func (passkeyservice *passkeyService) beginUserLogin(
+	ctx context.Context, relyingParty *webauthn.WebAuthn, login string,
+) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	user, err := passkeyservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := passkeyservice.logins.CheckLocked(user); err != nil {
+		return nil, nil, err
+	}
+
+	owner, err := passkeyservice.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(owner.credentials) == 0 {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	return relyingParty.BeginLogin(owner)
+}
+
+// FinishLogin verifies the assertion and returns the user. Assertions with
+// a signature counter not above the stored one are rejected.
+func (passkeyservice *passkeyService) FinishLogin(
+	ctx context.Context, sessionID string, response io.Reader,
+) (*domain.LoginResult, error) {
+	_, relyingParty, err := passkeyservice.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	started, ok := passkeyservice.ceremonies.Take(sessionID)
+	if !ok || started.registration {
+		return nil, ErrInvalidCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPasskeyRejected, err)
+	}
+
+	login := started.login
+	if login == "" {
+		login, err = passkeyservice.passkeyRepository.GetPasskeyOwner(ctx, parsed.RawID)
+		if errors.Is(err, repository.ErrPasskeyNotFound) {
+			return nil, ErrPasskeyRejected
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := passkeyservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrPasskeyRejected
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := passkeyservice.logins.CheckLocked(user); err != nil {
+		return nil, err
+	}
+
+	owner, err := passkeyservice.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var credential *webauthn.Credential
+	if len(started.session.UserID) == 0 {
+		_, credential, err = relyingParty.ValidatePasskeyLogin(
+			func(_, _ []byte) (webauthn.User, error) { return owner, nil }, started.session, parsed,
+		)
+	} else {
+		credential, err = relyingParty.ValidateLogin(owner, started.session, parsed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPasskeyRejected, err)
+	}
+
+	if credential.Authenticator.CloneWarning {
+		passkeyservice.logger.Warnw("Passkey signature counter went back", "login", user.Login)
+		return nil, ErrPasskeyCloned
+	}
+
+	if err := passkeyservice.updatePasskey(ctx, owner, credential); err != nil {
+		return nil, err
+	}
+
+	if err := passkeyservice.logins.ResetLockout(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return passkeyservice.logins.LoginResult(user)
+}
+
+func (passkeyservice *passkeyService) updatePasskey(
+	ctx context.Context, owner *webAuthnUser, credential *webauthn.Credential,
+) error {
+	record, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	for _, passkey := range owner.passkeys {
+		if bytes.Equal(passkey.ID, credential.ID) {
+			passkey.Credential, passkey.SignCount, passkey.LastUsedAt = record, credential.Authenticator.SignCount, &now
+			return passkeyservice.passkeyRepository.UpdatePasskey(ctx, &passkey)
+		}
+	}
+
+	return ErrPasskeyNotFound
+}
+
+// ListPasskeys returns passkeys of the user, users may list their own.
+func (passkeyservice *passkeyService) ListPasskeys(ctx context.Context, login string) ([]domain.Passkey, error) {
+	if err := authorize(ctx, PermissionReadUser, login); err != nil {
+		return nil, err
+	}
+
+	user, err := passkeyservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return passkeyservice.passkeyRepository.ListPasskeys(ctx, user.ID)
+}
+
+// DeletePasskey removes a passkey of the user, users may remove their own.
+func (passkeyservice *passkeyService) DeletePasskey(ctx context.Context, login string, id []byte) error {
+	if err := authorize(ctx, PermissionUpdateUser, login); err != nil {
+		return err
+	}
+
+	user, err := passkeyservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = passkeyservice.passkeyRepository.DeletePasskey(ctx, user.ID, id)
+	if errors.Is(err, repository.ErrPasskeyNotFound) {
+		return ErrPasskeyNotFound
+	}
+
+	return err
+}
+
+// relyingParty builds the relying party from the current configuration.
+func (passkeyservice *passkeyService) relyingParty() (config.WebAuthnConfig, *webauthn.WebAuthn, error) {
+	cfg := passkeyservice.obs.Current().WebAuthn
+	if !cfg.Enabled() {
+		return cfg, nil, ErrPasskeysDisabled
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.SessionTTL, TimeoutUVD: cfg.SessionTTL}
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+
+	return cfg, relyingParty, err
+}
+
+func (passkeyservice *passkeyService) newCeremony(options any, started ceremony) (*domain.PasskeyCeremony, error) {
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.PasskeyCeremony{
+		SessionID: passkeyservice.ceremonies.Put(started),
+		Options:   encoded,
+	}, nil
+}
+
+func (passkeyservice *passkeyService) webAuthnUser(ctx context.Context, user *domain.User) (*webAuthnUser, error) {
+	passkeys, err := passkeyservice.passkeyRepository.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := &webAuthnUser{user: user, passkeys: passkeys}
+	for _, passkey := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(passkey.Credential, &credential); err != nil {
+			return nil, err
+		}
+		owner.credentials = append(owner.credentials, credential)
+	}
+
+	return owner, nil
+}
+
+// webAuthnUser adapts a user and its passkeys to webauthn.User, the user
+// handle is the user ID.
+type webAuthnUser struct {
+	user        *domain.User
+	passkeys    []domain.Passkey
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Login
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return displayName(u.user)
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// NewPasskeyService shares password checks, lockouts and logins with the
+// user service through logins.
+func NewPasskeyService(
+	userRepository repository.UserRepository,
+	logins LoginServiceInterface,
+	passkeyRepository repository.PasskeyRepository,
+	obs *config.Observer,
+	logger *zap.Logger,
+) PasskeyServiceInterface {
+	return &passkeyService{
+		userRepository:    userRepository,
+		logins:            logins,
+		passkeyRepository: passkeyRepository,
+		obs:               obs,
+		ceremonies:        newCeremonyStore(),
+		logger:            logger.Named("PasskeyService").Sugar(),
+	}
+}
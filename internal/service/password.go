@@ -0,0 +1,111 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errInvalidArgon2id = errors.New("invalid argon2id hash")
+
+// argon2idPrefix starts argon2id hashes in the PHC string format, such
+// hashes are only imported, new passwords are hashed with bcrypt.
+const argon2idPrefix = "$argon2id$"
+
+// Bounds of imported argon2id parameters. Every login runs the hash with
+// them, so larger values would let one account exhaust memory or CPU.
+const (
+	argon2MaxMemory = 256 << 10 // KiB
+	argon2MaxTime   = 10
+	argon2MinKeyLen = 16
+	argon2MaxKeyLen = 64
+)
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return checkArgon2id(hash, password)
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// validPasswordHash tells whether an imported hash can be checked.
+func validPasswordHash(hash string) bool {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		_, _, err := parseArgon2id(hash)
+		return err == nil
+	}
+
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+}
+
+// parseArgon2id parses $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<key>
+// with the salt and key in unpadded base64.
+func parseArgon2id(hash string) (*argon2Params, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, nil, errInvalidArgon2id
+	}
+
+	var params argon2Params
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil || params.time == 0 || params.time > argon2MaxTime || params.threads == 0 ||
+		params.memory == 0 || params.memory > argon2MaxMemory {
+		return nil, nil, errInvalidArgon2id
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, errInvalidArgon2id
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2MinKeyLen || len(key) > argon2MaxKeyLen {
+		return nil, nil, errInvalidArgon2id
+	}
+	params.salt = salt
+
+	return &params, key, nil
+}
+
+// checkArgon2id parses the hash again, so the bounds hold for hashes
+// stored before they were enforced too.
+func checkArgon2id(hash, password string) bool {
+	params, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// dummyHash is compared against for unknown logins so that they take as
+// long as wrong passwords.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("dummy password")
+	return hash
+})
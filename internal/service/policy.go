@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrUnknownRole     = errors.New("unknown role")
+	ErrInvalidScope    = errors.New("invalid scope")
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleUser    = "user"
+	RoleService = "service"
+)
+
+// Permission is an operation on users checked by the policy.
+type Permission string
+
+const (
+	PermissionReadUser   Permission = "user:read"
+	PermissionCreateUser Permission = "user:create"
+	PermissionUpdateUser Permission = "user:update"
+	// PermissionManageUser covers unlocking, roles, deleting and the
+	// administrator view of users.
+	PermissionManageUser    Permission = "user:manage"
+	PermissionManageAPIKeys Permission = "api_key:manage"
+	PermissionReadAudit     Permission = "audit:read"
+	// PermissionManageWebhooks covers webhook subscriptions and their
+	// delivery history.
+	PermissionManageWebhooks Permission = "webhook:manage"
+)
+
+var permissions = []Permission{
+	PermissionReadUser,
+	PermissionCreateUser,
+	PermissionUpdateUser,
+	PermissionManageUser,
+	PermissionManageAPIKeys,
+	PermissionReadAudit,
+	PermissionManageWebhooks,
+}
+
+// scope tells whether a permission is granted on every user or on the
+// caller only.
+type scope int
+
+const (
+	scopeSelf scope = iota + 1
+	scopeAny
+)
+
+var policy = map[string]map[Permission]scope{
+	RoleAdmin: {
+		PermissionReadUser:       scopeAny,
+		PermissionCreateUser:     scopeAny,
+		PermissionUpdateUser:     scopeAny,
+		PermissionManageUser:     scopeAny,
+		PermissionManageAPIKeys:  scopeAny,
+		PermissionReadAudit:      scopeAny,
+		PermissionManageWebhooks: scopeAny,
+	},
+	RoleService: {
+		PermissionReadUser:   scopeAny,
+		PermissionCreateUser: scopeAny,
+	},
+	RoleUser: {
+		PermissionReadUser:   scopeSelf,
+		PermissionUpdateUser: scopeSelf,
+	},
+}
+
+// allowed tells whether the principal has the permission on the user
+// with login. API keys are granted their scopes on every user.
+func allowed(principal *auth.Principal, permission Permission, login string) bool {
+	if slices.Contains(principal.Scopes, string(permission)) {
+		return true
+	}
+
+	for _, role := range principal.Roles {
+		switch policy[role][permission] {
+		case scopeAny:
+			return true
+		case scopeSelf:
+			if principal.Login != "" && principal.Login == login {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// authorize checks the principal of ctx has the permission on the user
+// with login.
+func authorize(ctx context.Context, permission Permission, login string) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !allowed(principal, permission, login) {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// can is authorize for optional parts of a response.
+func can(ctx context.Context, permission Permission, login string) bool {
+	return authorize(ctx, permission, login) == nil
+}
+
+// validateScopes checks scopes of an API key are known permissions and
+// sorts them without duplicates.
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: no scopes", ErrInvalidScope)
+	}
+
+	scopes = slices.Clone(scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(permissions, Permission(scope)) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+
+	slices.Sort(scopes)
+
+	return slices.Compact(scopes), nil
+}
+
+// validateRoles checks roles against the policy and sorts them without
+// duplicates.
+func validateRoles(roles []string) ([]string, error) {
+	roles = slices.Clone(roles)
+	for _, role := range roles {
+		if _, ok := policy[role]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownRole, role)
+		}
+	}
+
+	slices.Sort(roles)
+
+	return slices.Compact(roles), nil
+}
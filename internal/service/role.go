@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+// userRoles returns stored roles of the user, users without any have the
+// user role.
+func (userservice *userService) userRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	roles, err := userservice.userRepository.GetRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roles) == 0 {
+		return []string{RoleUser}, nil
+	}
+
+	return roles, nil
+}
+
+func (userservice *userService) GetRoles(ctx context.Context, login string) ([]string, error) {
+	if err := authorize(ctx, PermissionManageUser, login); err != nil {
+		return nil, err
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return userservice.userRoles(ctx, user.ID)
+}
+
+// SetRoles replaces roles of the user and returns the stored ones.
+func (userservice *userService) SetRoles(ctx context.Context, login string, roles []string) ([]string, error) {
+	if err := authorize(ctx, PermissionManageUser, login); err != nil {
+		return nil, err
+	}
+
+	roles, err := validateRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := userservice.userRepository.SetRoles(ctx, user.ID, roles); err != nil {
+		return nil, err
+	}
+
+	return userservice.userRoles(ctx, user.ID)
+}
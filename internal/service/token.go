@@ -0,0 +1,87 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Token purposes, every purpose signs with its own derived key.
+const (
+	purposeMFA           = "mfa token"
+	purposeVerifyEmail   = "verify email"
+	purposeResetPassword = "reset password"
+)
+
+// newSignedToken returns base64url(expiry | user ID | login) "." MAC.
+//
+// The MAC also covers state the token is bound to, like the password hash
+// for password reset, so a token stops working once the state changes
+// and is effectively single use.
+func newSignedToken(key []byte, purpose string, userID uuid.UUID, login string, expires time.Time, state []byte) string {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	payload = append(payload, userID[:]...)
+	payload = append(payload, login...)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(tokenMAC(key, purpose, payload, state))
+}
+
+// signedToken is a decoded token, its MAC is checked with verify once the
+// state it is bound to is loaded.
+type signedToken struct {
+	userID  uuid.UUID
+	login   string
+	payload []byte
+	mac     []byte
+}
+
+// decodeSignedToken parses the token and checks the expiry time.
+func decodeSignedToken(token string, now time.Time) (*signedToken, bool) {
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) < 8+len(uuid.UUID{}) {
+		return nil, false
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return nil, false
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if now.After(expires) {
+		return nil, false
+	}
+
+	userID, _ := uuid.FromBytes(payload[8:24])
+
+	return &signedToken{userID: userID, login: string(payload[24:]), payload: payload, mac: mac}, true
+}
+
+func (t *signedToken) verify(key []byte, purpose string, state []byte) bool {
+	return hmac.Equal(t.mac, tokenMAC(key, purpose, t.payload, state))
+}
+
+func tokenMAC(key []byte, purpose string, payload, state []byte) []byte {
+	// a separate key is derived so the configured key is never used directly
+	derived := hmac.New(sha256.New, key)
+	derived.Write([]byte(purpose))
+
+	stateHash := sha256.Sum256(state)
+
+	mac := hmac.New(sha256.New, derived.Sum(nil))
+	mac.Write(payload)
+	mac.Write(stateHash[:])
+
+	return mac.Sum(nil)
+}
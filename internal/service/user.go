@@ -6,25 +6,65 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/iliadmitriev/go-user-test/internal/auth"
+	"github.com/iliadmitriev/go-user-test/internal/config"
 	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/mailer"
 	"github.com/iliadmitriev/go-user-test/internal/repository"
 )
 
 var (
 	ErrUserAlreadyExists = errors.New("user with login already exists")
 	ErrUserNotFound      = errors.New("user not found")
+	ErrVersionRequired   = errors.New("the expected version of the user is required")
+	ErrVersionMismatch   = errors.New("user was changed meanwhile, get it again and retry")
 )
 
 type UserServiceInterface interface {
 	GetUser(ctx context.Context, login string) (*domain.UserOut, error)
 	CreateUser(ctx context.Context, user *domain.UserIn) (*domain.UserOut, error)
+	UpdateUser(ctx context.Context, login string, update *domain.UserUpdate, expectedVersion int64) (*domain.UserOut, error)
+	DeleteUser(ctx context.Context, login string) error
+	Login(ctx context.Context, credentials *domain.Credentials) (*domain.LoginResult, error)
+	LoginMFA(ctx context.Context, code *domain.MFACode) (*domain.LoginResult, error)
+	EnrollTOTP(ctx context.Context, credentials *domain.Credentials) (*domain.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, credentials *domain.Credentials, code string) ([]string, error)
+	UnlockUser(ctx context.Context, login string) error
+	SendVerification(ctx context.Context, login string) error
+	VerifyEmail(ctx context.Context, token string) (*domain.UserOut, error)
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, reset *domain.PasswordReset) error
+	Authenticate(ctx context.Context, credentials *domain.Credentials) (*auth.Principal, error)
+	AuthenticateToken(ctx context.Context, token string) (*auth.Principal, error)
+	AuthenticateClientCert(ctx context.Context, commonName string) (*auth.Principal, error)
+	GetRoles(ctx context.Context, login string) ([]string, error)
+	SetRoles(ctx context.Context, login string, roles []string) ([]string, error)
 }
 
+// userService shares the steps of a login with the other ways to log in
+// through loginService.
 type userService struct {
-	userRepository repository.UserRepository
+	loginService
+
+	idempotencyRepository repository.IdempotencyRepository
+	mailer                mailer.Mailer
 }
 
 func (userservice *userService) GetUser(ctx context.Context, login string) (*domain.UserOut, error) {
+	if err := authorize(ctx, PermissionReadUser, login); err != nil {
+		return nil, err
+	}
+
+	return userservice.getUser(ctx, login)
+}
+
+// getUser returns the administrator view to callers allowed to manage the
+// user.
+func (userservice *userService) getUser(ctx context.Context, login string) (*domain.UserOut, error) {
+	if can(ctx, PermissionManageUser, login) {
+		return userservice.getUserWithLockout(ctx, login)
+	}
+
 	user, err := userservice.userRepository.GetUser(ctx, login)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
@@ -34,40 +74,215 @@ func (userservice *userService) GetUser(ctx context.Context, login string) (*dom
 		}
 	}
 
+	return toUserOut(user), nil
+}
+
+func (userservice *userService) getUserWithLockout(ctx context.Context, login string) (*domain.UserOut, error) {
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userOut := toUserOut(user)
+	userOut.Email, userOut.EmailVerifiedAt = user.Email, user.EmailVerifiedAt
+	userOut.Lockout = &domain.Lockout{
+		FailedAttempts: user.FailedAttempts,
+		LockedUntil:    user.LockedUntil,
+	}
+
+	if userOut.Roles, err = userservice.userRoles(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	return userOut, nil
+}
+
+func toUserOut(user *domain.User) *domain.UserOut {
 	return &domain.UserOut{
 		ID:        user.ID,
 		Login:     user.Login,
 		Name:      user.Name,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
-	}, nil
+		Version:   user.Version,
+	}
 }
 
+// CreateUser registers a user, anyone may sign up when allow_signup is set.
+// Retries with the idempotency key of the request get the first response.
 func (userservice *userService) CreateUser(ctx context.Context, user *domain.UserIn) (*domain.UserOut, error) {
+	cfg := userservice.obs.Current()
+	if !cfg.AllowSignup {
+		if err := authorize(ctx, PermissionCreateUser, user.Login); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.IdempotencyKey != "" && cfg.IdempotencyTTL > 0 {
+		return userservice.createUserOnce(ctx, user, cfg.IdempotencyTTL)
+	}
+
+	return userservice.createUser(ctx, user)
+}
+
+func (userservice *userService) createUser(ctx context.Context, user *domain.UserIn) (*domain.UserOut, error) {
 	if _, err := userservice.userRepository.GetUser(ctx, user.Login); err == nil {
 		return nil, ErrUserAlreadyExists
 	}
 
+	var email string
+	if user.Email != "" {
+		normalized, err := normalizeEmail(user.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := userservice.userRepository.GetUserByEmail(ctx, normalized); err == nil {
+			return nil, ErrEmailAlreadyExists
+		}
+		email = normalized
+	}
+
 	id := uuid.New()
 
+	password, err := hashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+
 	userSave := &domain.User{
 		ID:        id,
 		Login:     user.Login,
-		Password:  user.Password,
+		Password:  password,
 		Name:      user.Name,
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
+		Email:     email,
 	}
 
 	if err := userservice.userRepository.CreateUser(ctx, userSave); err != nil {
 		return nil, err
 	}
 
-	return userservice.GetUser(ctx, userSave.Login)
+	if email != "" && userservice.obs.Current().Mail.Enabled() {
+		// failures are logged by the mailer, the user can ask for another
+		// message with SendVerification
+		_ = userservice.sendVerification(ctx, userSave.Login)
+	}
+
+	return userservice.getUser(ctx, userSave.Login)
+}
+
+// UpdateUser changes the user if it is still at the expected version, so
+// that concurrent updates don't overwrite each other.
+func (userservice *userService) UpdateUser(
+	ctx context.Context, login string, update *domain.UserUpdate, expectedVersion int64,
+) (*domain.UserOut, error) {
+	if err := authorize(ctx, PermissionUpdateUser, login); err != nil {
+		return nil, err
+	}
+
+	if expectedVersion <= 0 {
+		return nil, ErrVersionRequired
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Version != expectedVersion {
+		return nil, ErrVersionMismatch
+	}
+
+	var changed, emailChanged bool
+	if update.Name != nil && *update.Name != user.Name {
+		user.Name, changed = *update.Name, true
+	}
+
+	if update.Email != nil {
+		email := *update.Email
+		if email != "" {
+			if email, err = normalizeEmail(email); err != nil {
+				return nil, err
+			}
+		}
+
+		if email != user.Email {
+			if email != "" {
+				if _, err := userservice.userRepository.GetUserByEmail(ctx, email); err == nil {
+					return nil, ErrEmailAlreadyExists
+				}
+			}
+			user.Email, user.EmailVerifiedAt, emailChanged = email, nil, email != ""
+			changed = true
+		}
+	}
+
+	// the version and updated_at stay as they are when nothing changes
+	if !changed {
+		return userservice.getUser(ctx, user.Login)
+	}
+
+	user.UpdatedAt = time.Now().UTC()
+
+	err = userservice.userRepository.UpdateUser(ctx, user, expectedVersion)
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return nil, ErrVersionMismatch
+	}
+	if errors.Is(err, repository.ErrUserEmailExists) {
+		// taken by another user after the check above
+		return nil, ErrEmailAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if emailChanged && userservice.obs.Current().Mail.Enabled() {
+		_ = userservice.sendVerification(ctx, user.Login)
+	}
+
+	return userservice.getUser(ctx, user.Login)
+}
+
+// DeleteUser removes the user, its access tokens stop working.
+func (userservice *userService) DeleteUser(ctx context.Context, login string) error {
+	if err := authorize(ctx, PermissionManageUser, login); err != nil {
+		return err
+	}
+
+	user, err := userservice.userRepository.GetUserAuth(ctx, login)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = userservice.userRepository.DeleteUser(ctx, user.ID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		// deleted by another request after the check above
+		return ErrUserNotFound
+	}
+
+	return err
 }
 
-func NewUserService(userRepository repository.UserRepository) UserServiceInterface {
+func NewUserService(
+	userRepository repository.UserRepository,
+	idempotencyRepository repository.IdempotencyRepository,
+	mailer mailer.Mailer,
+	obs *config.Observer,
+) UserServiceInterface {
 	return &userService{
-		userRepository,
+		loginService{userRepository, obs},
+		idempotencyRepository,
+		mailer,
 	}
 }
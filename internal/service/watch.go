@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+
+	"github.com/iliadmitriev/go-user-test/internal/watch"
+)
+
+type WatchServiceInterface interface {
+	// WatchUsers starts a change feed after the revision, from now on when
+	// revision is nil.
+	WatchUsers(ctx context.Context, revision *int64) (*watch.Watcher, error)
+}
+
+type watchService struct {
+	hub *watch.Hub
+}
+
+func (watchservice *watchService) WatchUsers(ctx context.Context, revision *int64) (*watch.Watcher, error) {
+	if err := authorize(ctx, PermissionReadUser, ""); err != nil {
+		return nil, err
+	}
+
+	return watchservice.hub.Watch(ctx, revision)
+}
+
+func NewWatchService(hub *watch.Hub) WatchServiceInterface {
+	return &watchService{hub: hub}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+	"github.com/iliadmitriev/go-user-test/internal/webhook"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook url must be an absolute http or https url")
+	ErrPrivateWebhookURL = errors.New("webhook url must not point to a loopback, link-local or private address")
+	ErrInvalidEventType  = errors.New("unknown event type")
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	ErrDeliveryNotFound  = errors.New("dead webhook delivery not found")
+	ErrInvalidStatus     = errors.New("unknown delivery status")
+)
+
+// webhookSecretPrefix starts every webhook secret.
+const webhookSecretPrefix = "whsec_"
+
+// MaxDeliveries limits deliveries returned at once.
+const MaxDeliveries = 1000
+
+const defaultDeliveries = 100
+
+var eventTypes = []string{
+	domain.EventUserCreated,
+	domain.EventUserUpdated,
+	domain.EventUserDeleted,
+	domain.EventPasswordChanged,
+}
+
+type WebhookServiceInterface interface {
+	CreateWebhook(ctx context.Context, in *domain.WebhookIn) (*domain.WebhookOut, error)
+	ListWebhooks(ctx context.Context) ([]domain.WebhookOut, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	ListDeliveries(ctx context.Context, webhookID, status string, limit int) ([]domain.WebhookDelivery, error)
+	RetryDelivery(ctx context.Context, webhookID string, id int64) error
+}
+
+type webhookService struct {
+	webhookRepository repository.WebhookRepository
+	obs               *config.Observer
+	logger            *zap.SugaredLogger
+}
+
+// CreateWebhook subscribes the URL to events, the returned secret is the
+// only copy shown.
+func (webhookservice *webhookService) CreateWebhook(ctx context.Context, in *domain.WebhookIn) (*domain.WebhookOut, error) {
+	if err := authorize(ctx, PermissionManageWebhooks, ""); err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(in.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	if !webhookservice.obs.Current().Webhook.AllowPrivate && !webhook.PublicHost(target.Hostname()) {
+		return nil, ErrPrivateWebhookURL
+	}
+
+	events := slices.Compact(slices.Sorted(slices.Values(in.Events)))
+	for _, event := range events {
+		if !slices.Contains(eventTypes, event) {
+			return nil, ErrInvalidEventType
+		}
+	}
+
+	webhook := &domain.Webhook{
+		ID:        strings.ToLower(rand.Text()[:12]),
+		URL:       target.String(),
+		Events:    events,
+		Secret:    webhookSecretPrefix + rand.Text(),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := webhookservice.webhookRepository.CreateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	out := toWebhookOut(webhook)
+	out.Secret = webhook.Secret
+
+	return out, nil
+}
+
+func (webhookservice *webhookService) ListWebhooks(ctx context.Context) ([]domain.WebhookOut, error) {
+	if err := authorize(ctx, PermissionManageWebhooks, ""); err != nil {
+		return nil, err
+	}
+
+	webhooks, err := webhookservice.webhookRepository.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]domain.WebhookOut, 0, len(webhooks))
+	for i := range webhooks {
+		out = append(out, *toWebhookOut(&webhooks[i]))
+	}
+
+	return out, nil
+}
+
+func (webhookservice *webhookService) DeleteWebhook(ctx context.Context, id string) error {
+	if err := authorize(ctx, PermissionManageWebhooks, ""); err != nil {
+		return err
+	}
+
+	err := webhookservice.webhookRepository.DeleteWebhook(ctx, id)
+	if errors.Is(err, repository.ErrWebhookNotFound) {
+		return ErrWebhookNotFound
+	}
+
+	return err
+}
+
+// ListDeliveries returns the delivery history of the webhook, newest
+// first. limit defaults to 100 and is capped at MaxDeliveries.
+func (webhookservice *webhookService) ListDeliveries(
+	ctx context.Context, webhookID, status string, limit int,
+) ([]domain.WebhookDelivery, error) {
+	if err := authorize(ctx, PermissionManageWebhooks, ""); err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "", domain.DeliveryPending, domain.DeliveryDelivered, domain.DeliveryDead:
+	default:
+		return nil, ErrInvalidStatus
+	}
+
+	if limit <= 0 {
+		limit = defaultDeliveries
+	}
+
+	deliveries, err := webhookservice.webhookRepository.ListDeliveries(ctx, webhookID, status, min(limit, MaxDeliveries))
+	if errors.Is(err, repository.ErrWebhookNotFound) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deliveries == nil {
+		deliveries = []domain.WebhookDelivery{}
+	}
+
+	return deliveries, nil
+}
+
+// RetryDelivery sends a dead delivery again with a fresh set of attempts.
+func (webhookservice *webhookService) RetryDelivery(ctx context.Context, webhookID string, id int64) error {
+	if err := authorize(ctx, PermissionManageWebhooks, ""); err != nil {
+		return err
+	}
+
+	err := webhookservice.webhookRepository.RetryDelivery(ctx, webhookID, id, time.Now())
+	if errors.Is(err, repository.ErrDeliveryNotFound) {
+		return ErrDeliveryNotFound
+	}
+
+	return err
+}
+
+func toWebhookOut(webhook *domain.Webhook) *domain.WebhookOut {
+	events := webhook.Events
+	if events == nil {
+		events = []string{}
+	}
+
+	return &domain.WebhookOut{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    events,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+func NewWebhookService(
+	webhookRepository repository.WebhookRepository,
+	obs *config.Observer,
+	logger *zap.Logger,
+) WebhookServiceInterface {
+	return &webhookService{
+		webhookRepository: webhookRepository,
+		obs:               obs,
+		logger:            logger.Named("WebhookService").Sugar(),
+	}
+}
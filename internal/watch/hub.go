@@ -0,0 +1,278 @@
+// Package watch streams changes of users to watchers, every event carries
+// the revision to resume from after reconnecting.
+package watch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+var (
+	ErrDisabled            = errors.New("watching users is disabled")
+	ErrRevisionUnavailable = errors.New("revision is no longer available, list users and watch from now")
+	ErrLagging             = errors.New("watcher fell behind, resume from the last revision")
+	ErrClosed              = errors.New("server is shutting down, resume from the last revision")
+)
+
+const (
+	// pausedInterval is how often the config is checked while watching
+	// is disabled.
+	pausedInterval = time.Second
+	// pageSize is how many events are read from the outbox at once.
+	pageSize = 100
+)
+
+// Hub polls the outbox and fans new events out to watchers. A watcher
+// which does not keep up is disconnected instead of slowing others down.
+type Hub struct {
+	repo   repository.OutboxRepository
+	obs    *config.Observer
+	logger *zap.SugaredLogger
+
+	// head is the last revision fanned out, only the loop uses it.
+	head   int64
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	watchers map[*Watcher]struct{}
+	closed   bool
+}
+
+func NewHub(repo repository.OutboxRepository, obs *config.Observer, lc fx.Lifecycle, logger *zap.Logger) *Hub {
+	hub := &Hub{
+		repo:     repo,
+		obs:      obs,
+		logger:   logger.Named("WatchHub").Sugar(),
+		watchers: make(map[*Watcher]struct{}),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: hub.Start,
+		OnStop:  hub.Stop,
+	})
+
+	return hub
+}
+
+func (h *Hub) Start(ctx context.Context) error {
+	_, head, err := h.repo.Revisions(ctx)
+	if err != nil {
+		return err
+	}
+	h.head = head
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+
+	h.wg.Add(1)
+	go h.loop(loopCtx)
+
+	return nil
+}
+
+func (h *Hub) Stop(context.Context) error {
+	h.Close()
+	h.cancel()
+	h.wg.Wait()
+
+	return nil
+}
+
+// Close disconnects every watcher with ErrClosed and refuses new ones,
+// long-lived streams would otherwise hold up graceful shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for w := range h.watchers {
+		h.drop(w, ErrClosed)
+	}
+	h.logger.Debug("Closed watchers")
+}
+
+// Watch starts watching from the revision, nil watches from now on.
+func (h *Hub) Watch(ctx context.Context, revision *int64) (*Watcher, error) {
+	cfg := h.obs.Current().Watch
+	if !cfg.Enabled() {
+		return nil, ErrDisabled
+	}
+
+	w := &Watcher{hub: h, events: make(chan domain.Event, cfg.Buffer)}
+
+	// Subscribing before reading revisions leaves no gap between
+	// events read from the outbox and events fanned out.
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, ErrClosed
+	}
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+
+	oldest, latest, err := h.repo.Revisions(ctx)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	switch {
+	case revision == nil:
+		w.last = latest
+	case *revision < oldest-1 || *revision > latest:
+		w.Close()
+		return nil, ErrRevisionUnavailable
+	default:
+		w.last = *revision
+		w.catchingUp = *revision < latest
+	}
+
+	return w, nil
+}
+
+func (h *Hub) remove(w *Watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.watchers, w)
+}
+
+// drop disconnects the watcher with err, h.mu must be held.
+func (h *Hub) drop(w *Watcher, err error) {
+	w.err = err
+	close(w.events)
+	delete(h.watchers, w)
+}
+
+func (h *Hub) loop(ctx context.Context) {
+	defer h.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		cfg := h.obs.Current().Watch
+		if !cfg.Enabled() {
+			timer.Reset(pausedInterval)
+			continue
+		}
+
+		for h.poll(ctx) {
+		}
+
+		timer.Reset(cfg.PollInterval)
+	}
+}
+
+// poll fans out a page of new events and reports whether more may be
+// waiting.
+func (h *Hub) poll(ctx context.Context) bool {
+	events, err := h.repo.EventsAfter(ctx, h.head, pageSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.logger.Warnw("Error reading outbox", "err", err)
+		}
+		return false
+	}
+	if len(events) == 0 {
+		return false
+	}
+
+	h.mu.Lock()
+	for w := range h.watchers {
+		h.send(w, events)
+	}
+	h.mu.Unlock()
+
+	h.head = events[len(events)-1].ID
+
+	return len(events) == pageSize
+}
+
+// send queues events without blocking, h.mu must be held.
+func (h *Hub) send(w *Watcher, events []domain.Event) {
+	for _, event := range events {
+		select {
+		case w.events <- event:
+		default:
+			h.logger.Debugw("Dropping lagging watcher", "revision", event.ID)
+			h.drop(w, ErrLagging)
+			return
+		}
+	}
+}
+
+// Watcher receives events of a single watch in revision order.
+type Watcher struct {
+	hub    *Hub
+	events chan domain.Event
+	// err is why events was closed, set under hub.mu before closing.
+	err error
+
+	last       int64
+	catchingUp bool
+	backlog    []domain.Event
+}
+
+// Next blocks until the next event, it returns ErrLagging or ErrClosed
+// once the watcher is disconnected.
+func (w *Watcher) Next(ctx context.Context) (*domain.Event, error) {
+	for {
+		if len(w.backlog) == 0 && w.catchingUp {
+			events, err := w.hub.repo.EventsAfter(ctx, w.last, pageSize)
+			if err != nil {
+				return nil, err
+			}
+			w.backlog = events
+			w.catchingUp = len(events) == pageSize
+		}
+
+		var event domain.Event
+		if len(w.backlog) > 0 {
+			event, w.backlog = w.backlog[0], w.backlog[1:]
+		} else {
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case event, ok = <-w.events:
+			}
+			if !ok {
+				return nil, w.err
+			}
+		}
+
+		// Events read while catching up are fanned out as well.
+		if event.ID <= w.last {
+			continue
+		}
+		w.last = event.ID
+
+		return &event, nil
+	}
+}
+
+// Close stops watching.
+func (w *Watcher) Close() {
+	w.hub.remove(w)
+}
@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+// next reads an event or fails after a second.
+func next(t *testing.T, w *Watcher) (*domain.Event, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return w.Next(ctx)
+}
+
+func Test_Hub(t *testing.T) {
+	ctx := context.Background()
+	sqlite := dbtest.New(t)
+	users := repository.NewUserDB(sqlite)
+	outbox := repository.NewOutboxDB(sqlite)
+
+	obs := config.NewObserver(&config.Config{Watch: config.WatchConfig{Buffer: 2}}, fxtest.NewLifecycle(t), zap.NewNop())
+	hub := NewHub(outbox, obs, fxtest.NewLifecycle(t), zap.NewNop())
+	require.NoError(t, hub.Start(ctx))
+	hub.cancel()
+	hub.wg.Wait()
+
+	now := time.Now()
+	alice := &domain.User{ID: uuid.New(), Login: "alice", Password: "hash", Name: "Alice", CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, users.CreateUser(ctx, alice))
+
+	live, err := hub.Watch(ctx, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, users.SetPassword(ctx, alice.ID, "new-hash", now))
+	hub.poll(ctx)
+
+	t.Run("new events only without revision", func(t *testing.T) {
+		event, err := next(t, live)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), event.ID)
+		assert.Equal(t, domain.EventPasswordChanged, event.Type)
+	})
+
+	t.Run("resume catches up from the outbox", func(t *testing.T) {
+		revision := int64(0)
+		w, err := hub.Watch(ctx, &revision)
+		require.NoError(t, err)
+		defer w.Close()
+
+		for _, want := range []string{domain.EventUserCreated, domain.EventPasswordChanged} {
+			event, err := next(t, w)
+			require.NoError(t, err)
+			assert.Equal(t, want, event.Type)
+		}
+
+		// the fanned out copy of the caught up event is skipped
+		require.NoError(t, users.SetPassword(ctx, alice.ID, "hash", now))
+		hub.poll(ctx)
+
+		event, err := next(t, w)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), event.ID)
+	})
+
+	t.Run("unknown revisions are refused", func(t *testing.T) {
+		revision := int64(10)
+		_, err := hub.Watch(ctx, &revision)
+		assert.ErrorIs(t, err, ErrRevisionUnavailable)
+
+		for _, id := range []int64{1, 2} {
+			require.NoError(t, outbox.MarkDelivered(ctx, id, now))
+		}
+		_, err = outbox.DeleteDelivered(ctx, now.Add(time.Hour))
+		require.NoError(t, err)
+
+		revision = 0
+		_, err = hub.Watch(ctx, &revision)
+		assert.ErrorIs(t, err, ErrRevisionUnavailable)
+
+		revision = 2
+		w, err := hub.Watch(ctx, &revision)
+		require.NoError(t, err)
+		w.Close()
+	})
+
+	t.Run("lagging watcher is dropped", func(t *testing.T) {
+		for _, password := range []string{"a", "b"} {
+			require.NoError(t, users.SetPassword(ctx, alice.ID, password, now))
+		}
+		hub.poll(ctx)
+
+		event, err := next(t, live)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), event.ID)
+		event, err = next(t, live)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), event.ID)
+		_, err = next(t, live)
+		assert.ErrorIs(t, err, ErrLagging)
+	})
+
+	t.Run("close disconnects watchers", func(t *testing.T) {
+		w, err := hub.Watch(ctx, nil)
+		require.NoError(t, err)
+
+		hub.Close()
+
+		_, err = next(t, w)
+		assert.ErrorIs(t, err, ErrClosed)
+		_, err = hub.Watch(ctx, nil)
+		assert.ErrorIs(t, err, ErrClosed)
+	})
+}
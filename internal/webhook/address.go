@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+)
+
+// ErrPrivateAddress is returned for webhooks on loopback, link-local or
+// private addresses unless webhook.allow_private is set.
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// PublicAddr reports whether webhooks may be sent to addr without
+// webhook.allow_private.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+// PublicHost reports whether the host of a webhook URL may be public, IP
+// literals are checked here, names once they are resolved on dialing.
+func PublicHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return true
+	}
+
+	return PublicAddr(addr)
+}
+
+// dialControl refuses connections to addresses which are not public while
+// the current config doesn't allow them. It runs after names are resolved,
+// so a name can't be pointed at an internal address later.
+func dialControl(obs *config.Observer) func(network, address string, _ syscall.RawConn) error {
+	return func(_, address string, _ syscall.RawConn) error {
+		if obs.Current().Webhook.AllowPrivate {
+			return nil
+		}
+
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if !PublicAddr(addrPort.Addr()) {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+		}
+
+		return nil
+	}
+}
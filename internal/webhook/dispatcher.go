@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+const (
+	// pausedInterval is how often the config is checked while sending is
+	// paused.
+	pausedInterval = time.Second
+	// maxResponseSize is how much of a response body is read before the
+	// connection is reused.
+	maxResponseSize = 64 << 10
+)
+
+// Dispatcher sends queued deliveries to webhooks. Deliveries are retried
+// until they get a 2xx response or run out of attempts, redirects are
+// not followed. Webhooks are sent directly, not through a proxy, and only
+// to public addresses unless webhook.allow_private is set.
+type Dispatcher struct {
+	repo   repository.WebhookRepository
+	obs    *config.Observer
+	client *http.Client
+	logger *zap.SugaredLogger
+	now    func() time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(
+	repo repository.WebhookRepository,
+	obs *config.Observer,
+	lc fx.Lifecycle,
+	logger *zap.Logger,
+) *Dispatcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl(obs),
+	}).DialContext
+
+	dispatcher := &Dispatcher{
+		repo: repo,
+		obs:  obs,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger.Named("WebhookDispatcher").Sugar(),
+		now:    time.Now,
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: dispatcher.Start,
+		OnStop:  dispatcher.Stop,
+	})
+
+	return dispatcher
+}
+
+func (d *Dispatcher) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	d.wg.Add(1)
+	go d.loop(ctx)
+
+	return nil
+}
+
+// Stop cancels requests in flight, they are sent again after restart.
+func (d *Dispatcher) Stop(context.Context) error {
+	d.cancel()
+	d.wg.Wait()
+
+	return nil
+}
+
+func (d *Dispatcher) loop(ctx context.Context) {
+	defer d.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		cfg := d.obs.Current().Webhook
+		if !cfg.Enabled() {
+			timer.Reset(pausedInterval)
+			continue
+		}
+
+		for d.dispatch(ctx, cfg) {
+		}
+
+		timer.Reset(cfg.PollInterval)
+	}
+}
+
+// dispatch sends a batch of due deliveries and reports whether more may
+// be waiting.
+func (d *Dispatcher) dispatch(ctx context.Context, cfg config.WebhookConfig) bool {
+	deliveries, err := d.repo.PendingDeliveries(ctx, d.now(), cfg.BatchSize)
+	if err != nil {
+		d.logger.Warnw("Error reading webhook deliveries", "err", err)
+		return false
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		code, err := d.send(ctx, cfg, delivery)
+		if ctx.Err() != nil {
+			return false
+		}
+
+		now := d.now().UTC()
+		delivery.Attempts++
+		delivery.LastAttemptAt = &now
+		delivery.ResponseCode = code
+		delivery.LastError = ""
+		delivery.NextAttemptAt = nil
+
+		switch {
+		case err == nil:
+			delivery.Status = domain.DeliveryDelivered
+			delivery.DeliveredAt = &now
+		case delivery.Attempts >= cfg.MaxAttempts:
+			delivery.Status = domain.DeliveryDead
+			delivery.LastError = err.Error()
+			d.logger.Warnw("Webhook delivery is dead", "id", delivery.ID, "webhook_id", delivery.WebhookID,
+				"event_id", delivery.EventID, "attempts", delivery.Attempts, "err", err)
+		default:
+			next := now.Add(cfg.RetryAfter(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+			delivery.LastError = err.Error()
+			d.logger.Infow("Error sending webhook", "id", delivery.ID, "webhook_id", delivery.WebhookID,
+				"attempts", delivery.Attempts, "next_attempt_at", next, "err", err)
+		}
+
+		if err := d.repo.UpdateDelivery(ctx, delivery); err != nil {
+			d.logger.Warnw("Error recording webhook delivery", "id", delivery.ID, "err", err)
+			return false
+		}
+	}
+
+	return len(deliveries) == cfg.BatchSize
+}
+
+// send posts the signed payload and returns the response status code.
+func (d *Dispatcher) send(ctx context.Context, cfg config.WebhookConfig, delivery *domain.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	now := d.now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-user-webhook")
+	req.Header.Set(HeaderID, delivery.EventID)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, now, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
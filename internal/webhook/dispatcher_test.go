@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/iliadmitriev/go-user-test/internal/config"
+	"github.com/iliadmitriev/go-user-test/internal/dbtest"
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+// receiver is a webhook endpoint checking signatures, it answers with
+// status until it is changed.
+type receiver struct {
+	t      *testing.T
+	secret string
+
+	mu     sync.Mutex
+	status int
+	events []domain.Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(rc.t, err)
+
+	assert.NoError(rc.t, Verify(rc.secret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature),
+		body, time.Now(), 2*time.Hour))
+
+	var event domain.Event
+	require.NoError(rc.t, json.Unmarshal(body, &event))
+	assert.Equal(rc.t, event.Key, r.Header.Get(HeaderID))
+	assert.Equal(rc.t, event.Type, r.Header.Get(HeaderEvent))
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.events = append(rc.events, event)
+	w.WriteHeader(rc.status)
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"type":"user.created"}`)
+	signature := Sign("secret", now, body)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	assert.NoError(t, Verify("secret", timestamp, signature, body, now, time.Minute))
+	assert.ErrorIs(t, Verify("other", timestamp, signature, body, now, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", timestamp, signature, []byte(`{}`), now, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", timestamp, signature, body, now.Add(time.Hour), time.Minute), ErrExpiredTimestamp)
+	assert.ErrorIs(t, Verify("secret", "yesterday", signature, body, now, time.Minute), ErrInvalidSignature)
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewWebhookDB(dbtest.New(t))
+
+	rc := &receiver{t: t, secret: "whsec_test", status: http.StatusInternalServerError}
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	require.NoError(t, repo.CreateWebhook(ctx, &domain.Webhook{
+		ID: "created", URL: server.URL, Events: []string{domain.EventUserCreated}, Secret: rc.secret,
+	}))
+	require.NoError(t, repo.CreateWebhook(ctx, &domain.Webhook{
+		ID: "password", URL: server.URL, Events: []string{domain.EventPasswordChanged}, Secret: rc.secret,
+	}))
+
+	event := &domain.Event{Key: uuid.NewString(), Type: domain.EventUserCreated, User: domain.EventUser{Login: "alice"}}
+	sink := NewSink(repo)
+	require.NoError(t, sink.Deliver(ctx, event))
+	require.NoError(t, sink.Deliver(ctx, event), "redelivered events are queued once")
+
+	cfg := config.WebhookConfig{
+		BatchSize: 10, Timeout: time.Second, MaxAttempts: 2, RetryDelay: time.Minute, MaxRetryDelay: time.Hour,
+		AllowPrivate: true,
+	}
+	obs := config.NewObserver(&config.Config{Webhook: cfg}, fxtest.NewLifecycle(t), zap.NewNop())
+	dispatcher := NewDispatcher(repo, obs, fxtest.NewLifecycle(t), zap.NewNop())
+	now := time.Now()
+	dispatcher.now = func() time.Time { return now }
+
+	deliveries := func(webhookID, status string) []domain.WebhookDelivery {
+		t.Helper()
+
+		deliveries, err := repo.ListDeliveries(ctx, webhookID, status, 10)
+		require.NoError(t, err)
+
+		return deliveries
+	}
+
+	t.Run("retries", func(t *testing.T) {
+		assert.False(t, dispatcher.dispatch(ctx, cfg))
+		assert.False(t, dispatcher.dispatch(ctx, cfg))
+		require.Len(t, rc.events, 1, "the retry waits for retry_delay")
+		assert.Equal(t, event.Key, rc.events[0].Key)
+		assert.Empty(t, deliveries("password", ""))
+
+		pending := deliveries("created", domain.DeliveryPending)
+		require.Len(t, pending, 1)
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, http.StatusInternalServerError, pending[0].ResponseCode)
+		assert.Equal(t, "unexpected status 500", pending[0].LastError)
+		require.NotNil(t, pending[0].NextAttemptAt)
+		assert.WithinDuration(t, now.Add(time.Minute), *pending[0].NextAttemptAt, time.Second)
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		dispatcher.dispatch(ctx, cfg)
+		require.Len(t, rc.events, 2)
+
+		dead := deliveries("created", domain.DeliveryDead)
+		require.Len(t, dead, 1)
+		assert.Equal(t, 2, dead[0].Attempts)
+		assert.Nil(t, dead[0].NextAttemptAt)
+
+		now = now.Add(time.Hour)
+		dispatcher.dispatch(ctx, cfg)
+		assert.Len(t, rc.events, 2, "dead deliveries are not retried")
+	})
+
+	t.Run("manual retry", func(t *testing.T) {
+		dead := deliveries("created", domain.DeliveryDead)
+		require.Len(t, dead, 1)
+		assert.ErrorIs(t, repo.RetryDelivery(ctx, "password", dead[0].ID, now), repository.ErrDeliveryNotFound)
+		require.NoError(t, repo.RetryDelivery(ctx, "created", dead[0].ID, now))
+		assert.ErrorIs(t, repo.RetryDelivery(ctx, "created", dead[0].ID, now), repository.ErrDeliveryNotFound)
+
+		rc.status = http.StatusNoContent
+		dispatcher.dispatch(ctx, cfg)
+		require.Len(t, rc.events, 3)
+		assert.Equal(t, event.Key, rc.events[2].Key)
+
+		delivered := deliveries("created", domain.DeliveryDelivered)
+		require.Len(t, delivered, 1)
+		assert.Equal(t, http.StatusNoContent, delivered[0].ResponseCode)
+		assert.Empty(t, delivered[0].LastError)
+		assert.NotNil(t, delivered[0].DeliveredAt)
+	})
+
+	t.Run("redirects are failures", func(t *testing.T) {
+		redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+		t.Cleanup(redirect.Close)
+
+		require.NoError(t, repo.CreateWebhook(ctx, &domain.Webhook{ID: "redirect", URL: redirect.URL, Secret: rc.secret}))
+		require.NoError(t, sink.Deliver(ctx, &domain.Event{Key: uuid.NewString(), Type: domain.EventPasswordChanged}))
+
+		dispatcher.dispatch(ctx, cfg)
+
+		pending := deliveries("redirect", domain.DeliveryPending)
+		require.Len(t, pending, 1)
+		assert.Equal(t, http.StatusFound, pending[0].ResponseCode)
+		assert.Len(t, deliveries("password", domain.DeliveryDelivered), 1)
+	})
+}
+
+func TestDispatcher_privateAddress(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewWebhookDB(dbtest.New(t))
+
+	rc := &receiver{t: t, secret: "whsec_test", status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+
+	// created before allow_private was turned off
+	require.NoError(t, repo.CreateWebhook(ctx, &domain.Webhook{ID: "local", URL: server.URL, Secret: rc.secret}))
+	require.NoError(t, NewSink(repo).Deliver(ctx, &domain.Event{Key: uuid.NewString(), Type: domain.EventUserCreated}))
+
+	cfg := config.WebhookConfig{BatchSize: 10, Timeout: time.Second, MaxAttempts: 2, RetryDelay: time.Minute, MaxRetryDelay: time.Hour}
+	obs := config.NewObserver(&config.Config{Webhook: cfg}, fxtest.NewLifecycle(t), zap.NewNop())
+	dispatcher := NewDispatcher(repo, obs, fxtest.NewLifecycle(t), zap.NewNop())
+
+	dispatcher.dispatch(ctx, cfg)
+	assert.Empty(t, rc.events)
+
+	pending, err := repo.ListDeliveries(ctx, "local", domain.DeliveryPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Contains(t, pending[0].LastError, ErrPrivateAddress.Error())
+}
+
+func TestPublicHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"partner.example.com": true,
+		"93.184.215.14":       true,
+		"2606:2800:21f::1":    true,
+		"localhost":           false,
+		"api.localhost.":      false,
+		"127.0.0.1":           false,
+		"::1":                 false,
+		"0.0.0.0":             false,
+		"169.254.169.254":     false,
+		"fe80::1":             false,
+		"10.1.2.3":            false,
+		"192.168.0.1":         false,
+		"fd00::1":             false,
+		"::ffff:127.0.0.1":    false,
+	} {
+		assert.Equal(t, want, PublicHost(host), host)
+	}
+}
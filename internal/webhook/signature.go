@@ -0,0 +1,59 @@
+// Package webhook sends user events to subscribed URLs.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Headers of webhook requests.
+const (
+	HeaderID        = "Webhook-Id"
+	HeaderEvent     = "Webhook-Event"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+)
+
+// signatureVersion prefixes signatures in HeaderSignature.
+const signatureVersion = "v1="
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredTimestamp = errors.New("webhook timestamp out of tolerance")
+)
+
+// Sign returns the signature of body sent at timestamp, the hex encoded
+// HMAC-SHA256 of "<unix timestamp>.<body>" keyed with the secret.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signatureVersion + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp and signature headers of a received
+// webhook. Timestamps further than tolerance from now are rejected to
+// limit replays.
+func Verify(secret, timestamp, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	sent := time.Unix(unix, 0)
+	if now.Sub(sent).Abs() > tolerance {
+		return ErrExpiredTimestamp
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, sent, body))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
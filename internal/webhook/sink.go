@@ -0,0 +1,27 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/iliadmitriev/go-user-test/internal/domain"
+	"github.com/iliadmitriev/go-user-test/internal/outbox"
+	"github.com/iliadmitriev/go-user-test/internal/repository"
+)
+
+type sink struct {
+	repo repository.WebhookRepository
+}
+
+// NewSink returns the outbox sink queueing events for the webhooks
+// subscribed to them. Queued events are sent by the Dispatcher.
+func NewSink(repo repository.WebhookRepository) outbox.Sink {
+	return &sink{repo}
+}
+
+func (s *sink) Name() string {
+	return "webhook"
+}
+
+func (s *sink) Deliver(ctx context.Context, event *domain.Event) error {
+	return s.repo.EnqueueEvent(ctx, event)
+}
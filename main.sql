@@ -4,5 +4,142 @@ CREATE TABLE users (
     password varchar(64),
     name varchar(32),
     created_at timestamp,
-    updated_at timestamp
+    updated_at timestamp,
+    failed_attempts integer NOT NULL DEFAULT 0,
+    locked_until timestamp,
+    email varchar(254),
+    email_verified_at timestamp,
+    version integer NOT NULL DEFAULT 1
 );
+
+CREATE UNIQUE INDEX users_email ON users (email);
+
+
+CREATE TABLE user_roles (
+    user_id varchar(32) REFERENCES users (id) ON DELETE CASCADE,
+    role varchar(32) NOT NULL,
+    PRIMARY KEY (user_id, role)
+);
+
+
+CREATE TABLE user_totp (
+    user_id varchar(32) PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret blob NOT NULL,
+    confirmed_at timestamp,
+    last_step integer NOT NULL DEFAULT 0,
+    created_at timestamp
+);
+
+
+CREATE TABLE user_recovery_codes (
+    user_id varchar(32) REFERENCES users (id) ON DELETE CASCADE,
+    code_hash varchar(64),
+    used_at timestamp,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+
+CREATE TABLE user_passkeys (
+    id blob PRIMARY KEY,
+    user_id varchar(32) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name varchar(64) NOT NULL,
+    credential blob NOT NULL,
+    sign_count integer NOT NULL DEFAULT 0,
+    created_at timestamp,
+    last_used_at timestamp
+);
+
+
+CREATE TABLE api_keys (
+    id varchar(16) PRIMARY KEY,
+    name varchar(64) NOT NULL,
+    secret_hash varchar(64) NOT NULL,
+    scopes varchar(255) NOT NULL,
+    created_at timestamp,
+    expires_at timestamp,
+    last_used_at timestamp,
+    revoked_at timestamp
+);
+
+
+CREATE TABLE audit_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at timestamp NOT NULL,
+    actor varchar(64) NOT NULL,
+    action varchar(32) NOT NULL,
+    user_id varchar(32) NOT NULL,
+    login varchar(32) NOT NULL,
+    request_id varchar(64),
+    client_ip varchar(45),
+    diff text NOT NULL
+);
+
+CREATE INDEX audit_events_user_id ON audit_events (user_id);
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+
+CREATE TABLE outbox (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    key varchar(36) NOT NULL UNIQUE,
+    type varchar(32) NOT NULL,
+    payload text NOT NULL,
+    created_at timestamp NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp NOT NULL,
+    last_error text,
+    delivered_at timestamp,
+    dead_at timestamp
+);
+
+CREATE INDEX outbox_pending ON outbox (next_attempt_at) WHERE delivered_at IS NULL AND dead_at IS NULL;
+
+
+CREATE TABLE webhooks (
+    id varchar(16) PRIMARY KEY,
+    url varchar(2048) NOT NULL,
+    events varchar(255) NOT NULL,
+    secret varchar(64) NOT NULL,
+    created_at timestamp
+);
+
+
+CREATE TABLE webhook_deliveries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    webhook_id varchar(16) NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id varchar(36) NOT NULL,
+    event_type varchar(32) NOT NULL,
+    payload text NOT NULL,
+    status varchar(16) NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    created_at timestamp NOT NULL,
+    next_attempt_at timestamp,
+    last_attempt_at timestamp,
+    response_code integer,
+    last_error text,
+    delivered_at timestamp,
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+
+CREATE TABLE idempotency_keys (
+    caller varchar(64) NOT NULL,
+    key varchar(255) NOT NULL,
+    fingerprint varchar(64) NOT NULL,
+    response blob,
+    created_at timestamp NOT NULL,
+    expires_at timestamp NOT NULL,
+    PRIMARY KEY (caller, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);